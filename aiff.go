@@ -0,0 +1,443 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// AIFF and AIFC support. Samples are converted to and from the WAV data
+// layout on the way in and out, so the rest of muxic only ever sees
+// little-endian data described by a WaveFormat. Plain AIFF and AIFC "NONE"
+// and "twos" hold big-endian integers, AIFC "sowt" little-endian integers and
+// "fl32" big-endian floats.
+
+const (
+	aiffForm = "AIFF"
+	aifcForm = "AIFC"
+
+	aifcNone = "NONE"
+	aifcTwos = "twos"
+	aifcSowt = "sowt"
+	aifcFl32 = "fl32"
+	aifcFL32 = "FL32"
+
+	// aifcVersion1 is the only AIFC version, stored in the FVER chunk
+	aifcVersion1 = 0xA2805140
+
+	// maxAIFFDataSize is the largest sound data the 32-bit FORM size can
+	// describe, leaving room for the fixed-size header chunks.
+	maxAIFFDataSize = math.MaxUint32 - 1024
+)
+
+// aifcCompressionNames are the human-readable names written after the
+// compression type.
+var aifcCompressionNames = map[string]string{
+	aifcNone: "not compressed",
+	aifcSowt: "",
+	aifcFl32: "32-bit floating point",
+}
+
+func isAIFFPath(path string) bool {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".aif", ".aiff", ".aifc":
+		return true
+	}
+	return false
+}
+
+// saveAIFFFile writes sample data as AIFF, or as AIFC when asked for or when
+// the samples are float, which plain AIFF cannot hold. AIFC stores integers
+// little-endian ("sowt"), as macOS does.
+func saveAIFFFile(path string, audioData []byte, wfx *WaveFormat, aifc bool) error {
+	compression := ""
+	switch {
+	case isFloatFormat(wfx) && wfx.BitsPerSample == 32:
+		compression = aifcFl32
+	case isFloatFormat(wfx):
+		return fmt.Errorf("unsupported float bit depth %d", wfx.BitsPerSample)
+	case wfx.Encoding() != waveFormatPCM:
+		return errors.New("AIFF holds only PCM or float samples")
+	case aifc:
+		compression = aifcSowt
+	}
+	if uint64(len(audioData)) > maxAIFFDataSize {
+		return fmt.Errorf("%d bytes of audio is too large for AIFF", len(audioData))
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	if err := writeAIFF(w, audioData, wfx, compression); err != nil {
+		f.Close()
+		return err
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// writeAIFF writes a complete AIFF file, or AIFC when compression is set.
+func writeAIFF(w io.Writer, audioData []byte, wfx *WaveFormat, compression string) error {
+	bitsPerSample := wfx.BitsPerSample
+	if wfx.FormatTag == waveFormatExtensible && wfx.ValidBitsPerSample != 0 {
+		bitsPerSample = wfx.ValidBitsPerSample
+	}
+	frames := uint32(0)
+	if wfx.BlockAlign > 0 {
+		frames = uint32(len(audioData) / int(wfx.BlockAlign))
+	}
+
+	comm := make([]byte, 18)
+	binary.BigEndian.PutUint16(comm[0:], wfx.Channels)
+	binary.BigEndian.PutUint32(comm[2:], frames)
+	binary.BigEndian.PutUint16(comm[6:], bitsPerSample)
+	rate := float64ToExtended(float64(wfx.SampleRate))
+	copy(comm[8:], rate[:])
+
+	form := aiffForm
+	var chunks []byte
+	if compression != "" {
+		form = aifcForm
+		chunks = appendAIFFChunk(chunks, "FVER", binary.BigEndian.AppendUint32(nil, aifcVersion1))
+		comm = append(comm, compression...)
+		comm = appendPascalString(comm, aifcCompressionNames[compression])
+	}
+	chunks = appendAIFFChunk(chunks, "COMM", comm)
+
+	samples := wavToAIFFSamples(audioData, wfx, compression == aifcSowt)
+	ssndHeader := make([]byte, 8) // offset and block size, both zero
+	ssndSize := uint32(len(ssndHeader) + len(samples))
+
+	header := make([]byte, 12)
+	copy(header[0:], "FORM")
+	binary.BigEndian.PutUint32(header[4:], uint32(4+len(chunks)+8)+ssndSize+ssndSize%2)
+	copy(header[8:], form)
+	header = append(header, chunks...)
+	header = append(header, "SSND"...)
+	header = binary.BigEndian.AppendUint32(header, ssndSize)
+	header = append(header, ssndHeader...)
+
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+	if _, err := w.Write(samples); err != nil {
+		return err
+	}
+	if ssndSize%2 == 1 {
+		if _, err := w.Write([]byte{0}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func appendAIFFChunk(buf []byte, id string, body []byte) []byte {
+	buf = append(buf, id...)
+	buf = binary.BigEndian.AppendUint32(buf, uint32(len(body)))
+	buf = append(buf, body...)
+	if len(body)%2 == 1 {
+		buf = append(buf, 0)
+	}
+	return buf
+}
+
+// appendPascalString appends a length-prefixed string padded to an even
+// total length.
+func appendPascalString(buf []byte, s string) []byte {
+	buf = append(buf, byte(len(s)))
+	buf = append(buf, s...)
+	if (len(s)+1)%2 == 1 {
+		buf = append(buf, 0)
+	}
+	return buf
+}
+
+// wavToAIFFSamples converts WAV sample data to AIFF byte order: big-endian,
+// or little-endian for sowt, with signed 8-bit samples.
+func wavToAIFFSamples(data []byte, wfx *WaveFormat, littleEndian bool) []byte {
+	out := make([]byte, len(data))
+	copy(out, data)
+	bytesPerSample := int(wfx.BitsPerSample) / 8
+	if bytesPerSample == 1 {
+		for i := range out {
+			out[i] ^= 0x80
+		}
+		return out
+	}
+	if !littleEndian {
+		swapSampleBytes(out, bytesPerSample)
+	}
+	return out
+}
+
+// aiffToWAVSamples is the inverse of wavToAIFFSamples, converting in place.
+func aiffToWAVSamples(data []byte, bytesPerSample int, littleEndian bool) {
+	if bytesPerSample == 1 {
+		for i := range data {
+			data[i] ^= 0x80
+		}
+		return
+	}
+	if !littleEndian {
+		swapSampleBytes(data, bytesPerSample)
+	}
+}
+
+// swapSampleBytes reverses the byte order of every sample in place.
+func swapSampleBytes(data []byte, bytesPerSample int) {
+	for i := 0; i+bytesPerSample <= len(data); i += bytesPerSample {
+		sample := data[i : i+bytesPerSample]
+		for a, b := 0, bytesPerSample-1; a < b; a, b = a+1, b-1 {
+			sample[a], sample[b] = sample[b], sample[a]
+		}
+	}
+}
+
+// readAIFFFile parses an AIFF or AIFC file and returns its format and the
+// sample data in WAV layout.
+func readAIFFFile(path string) (*WaveFormat, []byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+
+	wfx, audioData, err := parseAIFF(bufio.NewReader(f))
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return wfx, audioData, nil
+}
+
+// aiffCommon is the content of a COMM chunk.
+type aiffCommon struct {
+	wfx          *WaveFormat
+	frames       uint32
+	littleEndian bool
+}
+
+// parseAIFF reads an AIFF or AIFC stream chunk by chunk, skipping everything
+// but COMM and SSND.
+func parseAIFF(r io.Reader) (*WaveFormat, []byte, error) {
+	form, err := readAIFFFormHeader(r)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var comm *aiffCommon
+	var audioData []byte
+	chunkHeader := make([]byte, 8)
+	for {
+		n, err := io.ReadFull(r, chunkHeader)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("truncated chunk header (%d of 8 bytes)", n)
+		}
+		chunkID := string(chunkHeader[0:4])
+		chunkSize := int64(binary.BigEndian.Uint32(chunkHeader[4:8]))
+
+		switch chunkID {
+		case "COMM":
+			body, err := readChunkBody(r, chunkID, chunkSize)
+			if err != nil {
+				return nil, nil, err
+			}
+			if comm, err = parseAIFFCommon(body, form == aifcForm); err != nil {
+				return nil, nil, err
+			}
+		case "SSND":
+			body, err := readChunkBody(r, chunkID, chunkSize)
+			if err != nil {
+				return nil, nil, err
+			}
+			if len(body) < 8 {
+				return nil, nil, errors.New("SSND chunk is too short")
+			}
+			offset := int(binary.BigEndian.Uint32(body[0:4]))
+			if 8+offset > len(body) {
+				return nil, nil, errors.New("SSND offset is past the end of the chunk")
+			}
+			audioData = body[8+offset:]
+		default:
+			if _, err := io.CopyN(io.Discard, r, chunkSize); err != nil {
+				return nil, nil, fmt.Errorf("truncated %q chunk: expected %d bytes", chunkID, chunkSize)
+			}
+		}
+
+		if chunkSize%2 == 1 {
+			if _, err := io.CopyN(io.Discard, r, 1); err != nil && err != io.EOF {
+				return nil, nil, err
+			}
+		}
+	}
+
+	if comm == nil {
+		return nil, nil, errors.New("missing COMM chunk")
+	}
+	if audioData == nil {
+		if comm.frames > 0 {
+			return nil, nil, errors.New("missing SSND chunk")
+		}
+		audioData = []byte{}
+	}
+
+	// Trust the frame count over the chunk size, which may include padding
+	blockAlign := int(comm.wfx.BlockAlign)
+	size := int(comm.frames) * blockAlign
+	if size > len(audioData) {
+		return nil, nil, fmt.Errorf("SSND chunk holds %d frames, COMM promises %d", len(audioData)/blockAlign, comm.frames)
+	}
+	audioData = audioData[:size]
+	aiffToWAVSamples(audioData, int(comm.wfx.BitsPerSample)/8, comm.littleEndian)
+	return comm.wfx, audioData, nil
+}
+
+// readAIFFFormHeader checks the FORM header and returns the form type.
+func readAIFFFormHeader(r io.Reader) (string, error) {
+	header := make([]byte, 12)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return "", errors.New("not an AIFF file: file is too short for a FORM header")
+	}
+	if string(header[0:4]) != "FORM" {
+		return "", fmt.Errorf("not an AIFF file: expected FORM header, got %q", header[0:4])
+	}
+	form := string(header[8:12])
+	if form != aiffForm && form != aifcForm {
+		return "", fmt.Errorf("not an AIFF file: expected AIFF or AIFC form type, got %q", form)
+	}
+	return form, nil
+}
+
+func parseAIFFCommon(body []byte, aifc bool) (*aiffCommon, error) {
+	if len(body) < 18 || aifc && len(body) < 22 {
+		return nil, errors.New("COMM chunk is too short")
+	}
+	channels := binary.BigEndian.Uint16(body[0:2])
+	frames := binary.BigEndian.Uint32(body[2:6])
+	bits := binary.BigEndian.Uint16(body[6:8])
+	var extended [10]byte
+	copy(extended[:], body[8:18])
+	rate := extendedToFloat64(extended)
+
+	if channels == 0 {
+		return nil, errors.New("invalid channel count 0")
+	}
+	if rate < 1 || rate > math.MaxUint32 {
+		return nil, fmt.Errorf("invalid sample rate %g", rate)
+	}
+	if bits == 0 || bits > 32 {
+		return nil, fmt.Errorf("unsupported bit depth %d", bits)
+	}
+
+	compression := aifcNone
+	if aifc {
+		compression = string(body[18:22])
+	}
+	container := (bits + 7) / 8 * 8
+	comm := &aiffCommon{frames: frames}
+	switch compression {
+	case aifcNone, aifcTwos, aifcSowt:
+		comm.littleEndian = compression == aifcSowt
+		comm.wfx = newPCMFormat(channels, uint32(math.Round(rate)), container)
+		if container != bits {
+			comm.wfx.FormatTag = waveFormatExtensible
+			comm.wfx.ValidBitsPerSample = bits
+			comm.wfx.ChannelMask = defaultChannelMask(channels)
+			comm.wfx.SubFormat = subFormatPCM
+		}
+	case aifcFl32, aifcFL32:
+		if bits != 32 {
+			return nil, fmt.Errorf("unsupported float bit depth %d", bits)
+		}
+		comm.wfx = newFloatFormat(channels, uint32(math.Round(rate)))
+	default:
+		return nil, fmt.Errorf("unsupported AIFC compression %q", compression)
+	}
+	return comm, nil
+}
+
+// readAIFFHeader returns an AIFF file's format and the size of its sample
+// data without reading the samples themselves.
+func readAIFFHeader(path string) (*WaveFormat, int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer f.Close()
+
+	form, err := readAIFFFormHeader(f)
+	if err != nil {
+		return nil, 0, fmt.Errorf("%s: %v", path, err)
+	}
+
+	// COMM may come before or after SSND, so look at every chunk
+	var comm *aiffCommon
+	chunkHeader := make([]byte, 8)
+	for comm == nil {
+		if _, err := io.ReadFull(f, chunkHeader); err != nil {
+			return nil, 0, fmt.Errorf("%s: missing COMM chunk", path)
+		}
+		chunkID := string(chunkHeader[0:4])
+		chunkSize := int64(binary.BigEndian.Uint32(chunkHeader[4:8]))
+		if chunkID == "COMM" {
+			body, err := readChunkBody(f, chunkID, chunkSize)
+			if err != nil {
+				return nil, 0, fmt.Errorf("%s: %v", path, err)
+			}
+			if comm, err = parseAIFFCommon(body, form == aifcForm); err != nil {
+				return nil, 0, fmt.Errorf("%s: %v", path, err)
+			}
+		} else if _, err := f.Seek(chunkSize, io.SeekCurrent); err != nil {
+			return nil, 0, err
+		}
+		if chunkSize%2 == 1 {
+			if _, err := f.Seek(1, io.SeekCurrent); err != nil {
+				return nil, 0, err
+			}
+		}
+	}
+	return comm.wfx, int64(comm.frames) * int64(comm.wfx.BlockAlign), nil
+}
+
+// float64ToExtended encodes a value as an 80-bit IEEE 754 extended precision
+// number, the way AIFF stores its sample rate.
+func float64ToExtended(v float64) [10]byte {
+	var b [10]byte
+	if v == 0 {
+		return b
+	}
+	sign := uint16(0)
+	if v < 0 {
+		sign, v = 0x8000, -v
+	}
+	frac, exp := math.Frexp(v) // v = frac * 2^exp with frac in [0.5, 1)
+	binary.BigEndian.PutUint16(b[0:], sign|uint16(exp-1+16383))
+	binary.BigEndian.PutUint64(b[2:], uint64(math.Ldexp(frac, 64)))
+	return b
+}
+
+func extendedToFloat64(b [10]byte) float64 {
+	exp := int(binary.BigEndian.Uint16(b[0:]) & 0x7FFF)
+	mantissa := binary.BigEndian.Uint64(b[2:])
+	if exp == 0 && mantissa == 0 {
+		return 0
+	}
+	v := math.Ldexp(float64(mantissa), exp-16383-63)
+	if b[0]&0x80 != 0 {
+		v = -v
+	}
+	return v
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestExtendedFloat(t *testing.T) {
+	rate := float64ToExtended(44100)
+	expected := [10]byte{0x40, 0x0E, 0xAC, 0x44, 0, 0, 0, 0, 0, 0}
+	if rate != expected {
+		t.Errorf("Expected 44100 to encode as % X, got % X", expected, rate)
+	}
+	for _, v := range []float64{0, 1, 8000, 22050, 48000, 96000, 192000, 44100.5} {
+		if got := extendedToFloat64(float64ToExtended(v)); got != v {
+			t.Errorf("Expected %g to round-trip, got %g", v, got)
+		}
+	}
+}
+
+func TestAIFFRoundTrip(t *testing.T) {
+	tests := []struct {
+		name string
+		ext  string
+		wfx  *WaveFormat
+	}{
+		{"aiff 16-bit", ".aif", newPCMFormat(2, 44100, 16)},
+		{"aiff 24-bit", ".aiff", newPCMFormat(2, 48000, 24)},
+		{"aiff 8-bit", ".aif", newPCMFormat(1, 22050, 8)},
+		{"aifc sowt", ".aifc", newPCMFormat(2, 44100, 16)},
+		{"aifc sowt 24-bit", ".aifc", newPCMFormat(6, 96000, 24)},
+		{"float", ".aif", newFloatFormat(2, 48000)},
+	}
+	dir := t.TempDir()
+	for _, tt := range tests {
+		samples := []float64{0.5, -0.5, 0.25, -0.25, 0.999, -1, 0, 0.125, -0.125, 0.75, -0.75, 0.1}
+		samples = samples[:len(samples)/int(tt.wfx.Channels)*int(tt.wfx.Channels)]
+		data, _ := encodeSamples(samples, tt.wfx, nil)
+		path := filepath.Join(dir, tt.name+tt.ext)
+		if err := saveAudioFile(path, data, tt.wfx); err != nil {
+			t.Fatalf("%s: save failed: %v", tt.name, err)
+		}
+
+		wfx, decoded, err := readAudioFile(path)
+		if err != nil {
+			t.Fatalf("%s: read failed: %v", tt.name, err)
+		}
+		if wfx.Channels != tt.wfx.Channels || wfx.SampleRate != tt.wfx.SampleRate || wfx.BitsPerSample != tt.wfx.BitsPerSample || wfx.Encoding() != tt.wfx.Encoding() {
+			t.Errorf("%s: expected %+v, got %+v", tt.name, tt.wfx, wfx)
+		}
+		if !bytes.Equal(decoded, data) {
+			t.Errorf("%s: samples differ after round trip", tt.name)
+		}
+
+		header, size, err := readAudioHeader(path)
+		if err != nil || header.SampleRate != tt.wfx.SampleRate || size != int64(len(data)) {
+			t.Errorf("%s: header read gave %+v, %d bytes, %v", tt.name, header, size, err)
+		}
+	}
+}
+
+func TestSaveAIFFFile_Layout(t *testing.T) {
+	dir := t.TempDir()
+	wfx := newPCMFormat(1, 44100, 16)
+	data := []byte{0x34, 0x12, 0xFF, 0x7F} // 0x1234, 0x7FFF little-endian
+
+	aiff := filepath.Join(dir, "a.aif")
+	if err := saveAudioFile(aiff, data, wfx); err != nil {
+		t.Fatal(err)
+	}
+	raw, _ := os.ReadFile(aiff)
+	if string(raw[0:4]) != "FORM" || string(raw[8:12]) != "AIFF" {
+		t.Fatalf("Expected a FORM AIFF header, got %q", raw[0:12])
+	}
+	if int(binary.BigEndian.Uint32(raw[4:8])) != len(raw)-8 {
+		t.Errorf("FORM size %d does not match the file length %d", binary.BigEndian.Uint32(raw[4:8]), len(raw))
+	}
+	if !bytes.HasSuffix(raw, []byte{0x12, 0x34, 0x7F, 0xFF}) {
+		t.Errorf("Expected big-endian samples, got % X", raw[len(raw)-4:])
+	}
+
+	aifc := filepath.Join(dir, "a.aifc")
+	if err := saveAudioFile(aifc, data, wfx); err != nil {
+		t.Fatal(err)
+	}
+	raw, _ = os.ReadFile(aifc)
+	if string(raw[8:12]) != "AIFC" || !bytes.Contains(raw, []byte("sowt")) || !bytes.Contains(raw, []byte("FVER")) {
+		t.Errorf("Expected an AIFC file with sowt compression and a version chunk")
+	}
+	if !bytes.HasSuffix(raw, data) {
+		t.Errorf("Expected little-endian samples, got % X", raw[len(raw)-4:])
+	}
+}
+
+// buildAIFF assembles an AIFF file from raw chunks.
+func buildAIFF(form string, chunks ...[]byte) []byte {
+	body := []byte(form)
+	for _, chunk := range chunks {
+		body = append(body, chunk...)
+	}
+	file := []byte("FORM")
+	file = binary.BigEndian.AppendUint32(file, uint32(len(body)))
+	return append(file, body...)
+}
+
+func aiffComm(channels uint16, frames uint32, bits uint16, extra string) []byte {
+	body := binary.BigEndian.AppendUint16(nil, channels)
+	body = binary.BigEndian.AppendUint32(body, frames)
+	body = binary.BigEndian.AppendUint16(body, bits)
+	rate := float64ToExtended(44100)
+	body = append(body, rate[:]...)
+	return appendAIFFChunk(nil, "COMM", append(body, extra...))
+}
+
+func TestParseAIFF_ChunkOrderAndOffset(t *testing.T) {
+	// SSND before COMM, a non-zero offset, an odd-sized unknown chunk and
+	// 12-bit samples in 16-bit containers
+	ssnd := []byte{0, 0, 0, 2, 0, 0, 0, 0, 0xEE, 0xEE, 0x12, 0x30, 0xFF, 0xF0}
+	data := buildAIFF(aiffForm,
+		appendAIFFChunk(nil, "SSND", ssnd),
+		appendAIFFChunk(nil, "NAME", []byte("odd")),
+		aiffComm(1, 2, 12, ""),
+	)
+	wfx, samples, err := parseAIFF(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("parseAIFF failed: %v", err)
+	}
+	if wfx.BitsPerSample != 16 || wfx.ValidBitsPerSample != 12 || wfx.FormatTag != waveFormatExtensible {
+		t.Errorf("Expected 12 valid bits in a 16-bit container, got %+v", wfx)
+	}
+	if !bytes.Equal(samples, []byte{0x30, 0x12, 0xF0, 0xFF}) {
+		t.Errorf("Unexpected samples % X", samples)
+	}
+}
+
+func TestParseAIFF_Malformed(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"not FORM", append([]byte("RIFF"), make([]byte, 8)...)},
+		{"wrong form type", buildAIFF("WAVE")},
+		{"missing COMM", buildAIFF(aiffForm, appendAIFFChunk(nil, "SSND", make([]byte, 12)))},
+		{"missing SSND", buildAIFF(aiffForm, aiffComm(1, 10, 16, ""))},
+		{"short SSND", buildAIFF(aiffForm, aiffComm(1, 10, 16, ""), appendAIFFChunk(nil, "SSND", make([]byte, 12)))},
+		{"compressed", buildAIFF(aifcForm, aiffComm(1, 0, 16, "ulaw\x00\x00"))},
+		{"zero channels", buildAIFF(aiffForm, aiffComm(0, 0, 16, ""))},
+	}
+	for _, tt := range tests {
+		if _, _, err := parseAIFF(bytes.NewReader(tt.data)); err == nil {
+			t.Errorf("%s: expected an error", tt.name)
+		}
+	}
+}
+
+func TestAIFFTracks(t *testing.T) {
+	isolateProjects(t)
+	projectRoot = t.TempDir()
+	if err := ensureTracksDir(); err != nil {
+		t.Fatal(err)
+	}
+	wfx := newPCMFormat(2, 44100, 16)
+	data, _ := encodeSamples([]float64{0.5, -0.5, 0.25, -0.25}, wfx, nil)
+	stemPath := filepath.Join(tracksDir(), "stem.aif")
+	if err := saveAudioFile(stemPath, data, wfx); err != nil {
+		t.Fatal(err)
+	}
+	writeTestTrack(t, getTrackPath("vocals"), 2, []int16{1000, -1000})
+
+	if got := getTrackPath("stem"); got != stemPath {
+		t.Errorf("Expected the AIFF track at %s, got %s", stemPath, got)
+	}
+	names, err := recordedTrackNames()
+	if err != nil {
+		t.Fatal(err)
+	}
+	slices.Sort(names)
+	if !slices.Equal(names, []string{"stem", "vocals"}) {
+		t.Errorf("Expected both tracks listed, got %v", names)
+	}
+
+	// Exporting across containers converts; to the same one copies
+	toWav := filepath.Join(t.TempDir(), "stem.wav")
+	if err := exportTrack("stem", toWav, exportOptions{Dither: ditherNone}); err != nil {
+		t.Fatalf("Export to WAV failed: %v", err)
+	}
+	if _, exported, err := readWavFile(toWav); err != nil || !bytes.Equal(exported, data) {
+		t.Errorf("Expected a WAV copy of the AIFF track, got %v", err)
+	}
+	toAIFF := filepath.Join(t.TempDir(), "vocals.aiff")
+	if err := exportTrack("vocals", toAIFF, exportOptions{Dither: ditherNone}); err != nil {
+		t.Fatalf("Export to AIFF failed: %v", err)
+	}
+	if _, _, err := readAIFFFile(toAIFF); err != nil {
+		t.Errorf("Expected an AIFF export, got %v", err)
+	}
+
+	if err := recordTrack("stem", ditherNone, recordingMetadata{}); err == nil {
+		t.Error("Expected recording over an AIFF track to fail")
+	}
+}
+
+func TestAIFFTracks_UpperCaseSuffix(t *testing.T) {
+	isolateProjects(t)
+	projectRoot = t.TempDir()
+	if err := ensureTracksDir(); err != nil {
+		t.Fatal(err)
+	}
+	wfx := newPCMFormat(1, 44100, 16)
+	data, _ := encodeSamples([]float64{0.5, -0.5}, wfx, nil)
+	stemPath := filepath.Join(tracksDir(), "Bass.AIF")
+	if err := saveAudioFile(stemPath, data, wfx); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := getTrackPath("Bass"); got != stemPath {
+		t.Errorf("Expected the AIFF track at %s, got %s", stemPath, got)
+	}
+	names, err := recordedTrackNames()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(names, []string{"Bass"}) {
+		t.Errorf("Expected the upper-case stem listed, got %v", names)
+	}
+	if _, got, err := readAudioFile(getTrackPath("Bass")); err != nil || !bytes.Equal(got, data) {
+		t.Errorf("Expected the stem to read back, got %v", err)
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import "fmt"
+
+const (
+	backendWASAPI = "wasapi"
+	backendFile   = "file"
+)
+
+type AudioDevice struct {
+	Name         string `json:"Name"`
+	Manufacturer string `json:"Manufacturer"`
+}
+
+// AudioBackend is the platform audio layer muxic records from and plays to.
+type AudioBackend interface {
+	// Devices lists the capture devices available to record from.
+	Devices() ([]AudioDevice, error)
+	// OpenCapture opens the named capture device, or the backend's default
+	// device when deviceName is empty.
+	OpenCapture(deviceName string) (CaptureStream, error)
+	// OpenPlayback opens an output stream that accepts audio in the given
+	// format. The stream's Format reports what it actually expects.
+	OpenPlayback(deviceName string, format *WaveFormat) (PlaybackStream, error)
+	Close() error
+}
+
+// CaptureStream delivers raw sample data from an input device.
+type CaptureStream interface {
+	DeviceName() string
+	Format() *WaveFormat
+	Start() error
+	// Read returns the next block of whole frames. An empty block means no
+	// data is ready yet; io.EOF means the source has nothing more to give.
+	Read() ([]byte, error)
+	Stop() error
+	Close() error
+}
+
+// PlaybackStream accepts raw sample data for an output device.
+type PlaybackStream interface {
+	DeviceName() string
+	Format() *WaveFormat
+	// Write blocks until the whole block has been queued for output.
+	Write(data []byte) error
+	// Close waits for queued audio to finish playing and releases the device.
+	Close() error
+}
+
+// openBackend returns the backend selected in the config, falling back to the
+// platform default.
+func openBackend(config Config) (AudioBackend, error) {
+	name := defaultBackend
+	if config.Backend != nil {
+		name = *config.Backend
+	}
+
+	switch name {
+	case backendWASAPI:
+		return newWASAPIBackend()
+	case backendFile:
+		backend := &FileBackend{}
+		if config.VirtualInput != nil {
+			backend.InputPath = *config.VirtualInput
+		}
+		if config.VirtualOutput != nil {
+			backend.OutputPath = *config.VirtualOutput
+		}
+		return backend, nil
+	default:
+		return nil, fmt.Errorf("unknown audio backend '%s'", name)
+	}
+}
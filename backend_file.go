@@ -0,0 +1,140 @@
+package main
+
+import (
+	"errors"
+	"io"
+)
+
+// FileBackend is a virtual audio backend for machines without sound hardware.
+// Capture devices are WAV files that are read back as if they were a
+// microphone, and playback is written to OutputPath, or discarded when no
+// output path is set.
+type FileBackend struct {
+	InputPath  string
+	OutputPath string
+}
+
+func (b *FileBackend) Devices() ([]AudioDevice, error) {
+	if b.InputPath == "" {
+		return nil, nil
+	}
+	return []AudioDevice{{Name: b.InputPath, Manufacturer: "muxic virtual input"}}, nil
+}
+
+// OpenCapture treats deviceName as the path of the WAV file to read.
+func (b *FileBackend) OpenCapture(deviceName string) (CaptureStream, error) {
+	path := deviceName
+	if path == "" {
+		path = b.InputPath
+	}
+	if path == "" {
+		return nil, errors.New("file backend has no input file; set virtual_input in the config")
+	}
+
+	wfx, audioData, err := readAudioFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	// Hand out roughly 10ms per read, the same granularity as a device period
+	chunkFrames := int(wfx.SampleRate) / 100
+	if chunkFrames == 0 {
+		chunkFrames = 1
+	}
+	return &fileCaptureStream{
+		path:      path,
+		format:    wfx,
+		data:      audioData,
+		chunkSize: chunkFrames * int(wfx.BlockAlign),
+	}, nil
+}
+
+// OpenPlayback accepts any format as-is; the file records exactly what is played.
+func (b *FileBackend) OpenPlayback(deviceName string, format *WaveFormat) (PlaybackStream, error) {
+	path := deviceName
+	if path == "" {
+		path = b.OutputPath
+	}
+	stream := &filePlaybackStream{path: path, format: format}
+	if path != "" {
+		writer, err := createLongWavWriter(path, format)
+		if err != nil {
+			return nil, err
+		}
+		stream.writer = writer
+	}
+	return stream, nil
+}
+
+func (b *FileBackend) Close() error {
+	return nil
+}
+
+type fileCaptureStream struct {
+	path      string
+	format    *WaveFormat
+	data      []byte
+	pos       int
+	chunkSize int
+	started   bool
+}
+
+func (s *fileCaptureStream) DeviceName() string  { return s.path }
+func (s *fileCaptureStream) Format() *WaveFormat { return s.format }
+
+func (s *fileCaptureStream) Start() error {
+	s.started = true
+	return nil
+}
+
+func (s *fileCaptureStream) Read() ([]byte, error) {
+	if !s.started {
+		return nil, nil
+	}
+	if s.pos >= len(s.data) {
+		return nil, io.EOF
+	}
+	end := min(s.pos+s.chunkSize, len(s.data))
+	chunk := s.data[s.pos:end]
+	s.pos = end
+	return chunk, nil
+}
+
+func (s *fileCaptureStream) Stop() error {
+	s.started = false
+	return nil
+}
+
+func (s *fileCaptureStream) Close() error {
+	return nil
+}
+
+type filePlaybackStream struct {
+	path   string
+	format *WaveFormat
+	writer *WavWriter
+}
+
+func (s *filePlaybackStream) DeviceName() string {
+	if s.path == "" {
+		return "null output"
+	}
+	return s.path
+}
+
+func (s *filePlaybackStream) Format() *WaveFormat { return s.format }
+
+func (s *filePlaybackStream) Write(data []byte) error {
+	if s.writer == nil {
+		return nil
+	}
+	_, err := s.writer.Write(data)
+	return err
+}
+
+func (s *filePlaybackStream) Close() error {
+	if s.writer == nil {
+		return nil
+	}
+	return s.writer.Close()
+}
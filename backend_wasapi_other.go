@@ -0,0 +1,11 @@
+//go:build !windows
+
+package main
+
+import "errors"
+
+const defaultBackend = backendFile
+
+func newWASAPIBackend() (AudioBackend, error) {
+	return nil, errors.New("the wasapi backend is only available on Windows")
+}
@@ -0,0 +1,394 @@
+//go:build windows
+
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"time"
+	"unsafe"
+
+	"github.com/go-ole/go-ole"
+
+	"github.com/moutend/go-wca/pkg/wca"
+)
+
+const defaultBackend = backendWASAPI
+
+// WASAPIBackend talks to the Windows Audio Session API in shared mode.
+type WASAPIBackend struct {
+	enumerator *wca.IMMDeviceEnumerator
+}
+
+func newWASAPIBackend() (AudioBackend, error) {
+	if err := ole.CoInitialize(0); err != nil {
+		return nil, err
+	}
+
+	var mmde *wca.IMMDeviceEnumerator
+	if err := wca.CoCreateInstance(wca.CLSID_MMDeviceEnumerator, 0, wca.CLSCTX_ALL, wca.IID_IMMDeviceEnumerator, &mmde); err != nil {
+		ole.CoUninitialize()
+		return nil, err
+	}
+	return &WASAPIBackend{enumerator: mmde}, nil
+}
+
+func (b *WASAPIBackend) Close() error {
+	b.enumerator.Release()
+	ole.CoUninitialize()
+	return nil
+}
+
+func (b *WASAPIBackend) Devices() ([]AudioDevice, error) {
+	var devices []AudioDevice
+	err := b.visitDevices(wca.ECapture, func(name string, device *wca.IMMDevice) bool {
+		devices = append(devices, AudioDevice{Name: name})
+		return false
+	})
+	return devices, err
+}
+
+func (b *WASAPIBackend) OpenCapture(deviceName string) (CaptureStream, error) {
+	device, name, err := b.findDevice(wca.ECapture, deviceName)
+	if err != nil {
+		return nil, err
+	}
+
+	var audioClient *wca.IAudioClient
+	if err := device.Activate(wca.IID_IAudioClient, wca.CLSCTX_ALL, nil, &audioClient); err != nil {
+		device.Release()
+		return nil, err
+	}
+
+	var wfx *wca.WAVEFORMATEX
+	if err := audioClient.GetMixFormat(&wfx); err != nil {
+		audioClient.Release()
+		device.Release()
+		return nil, err
+	}
+	defer ole.CoTaskMemFree(uintptr(unsafe.Pointer(wfx)))
+
+	// Initialize in Shared Mode
+	if err := audioClient.Initialize(wca.AUDCLNT_SHAREMODE_SHARED, 0, 10000000, 0, wfx, nil); err != nil {
+		audioClient.Release()
+		device.Release()
+		return nil, err
+	}
+
+	var captureClient *wca.IAudioCaptureClient
+	if err := audioClient.GetService(wca.IID_IAudioCaptureClient, &captureClient); err != nil {
+		audioClient.Release()
+		device.Release()
+		return nil, err
+	}
+
+	return &wasapiCaptureStream{
+		name:          name,
+		format:        waveFormatFromWCA(wfx),
+		device:        device,
+		audioClient:   audioClient,
+		captureClient: captureClient,
+	}, nil
+}
+
+// OpenPlayback asks the audio engine to convert from the requested PCM or
+// float format, so tracks play at their own rate and channel count.
+func (b *WASAPIBackend) OpenPlayback(deviceName string, format *WaveFormat) (PlaybackStream, error) {
+	var device *wca.IMMDevice
+	var name string
+	if deviceName == "" {
+		if err := b.enumerator.GetDefaultAudioEndpoint(wca.ERender, wca.EConsole, &device); err != nil {
+			return nil, err
+		}
+		name, _ = deviceFriendlyName(device)
+	} else {
+		var err error
+		if device, name, err = b.findDevice(wca.ERender, deviceName); err != nil {
+			return nil, err
+		}
+	}
+
+	var audioClient *wca.IAudioClient
+	if err := device.Activate(wca.IID_IAudioClient, wca.CLSCTX_ALL, nil, &audioClient); err != nil {
+		device.Release()
+		return nil, err
+	}
+
+	wfx := waveFormatToWCA(format)
+	flags := uint32(wca.AUDCLNT_STREAMFLAGS_AUTOCONVERTPCM | wca.AUDCLNT_STREAMFLAGS_SRC_DEFAULT_QUALITY)
+	if err := audioClient.Initialize(wca.AUDCLNT_SHAREMODE_SHARED, flags, 2000000, 0, wfx, nil); err != nil {
+		audioClient.Release()
+		device.Release()
+		return nil, err
+	}
+
+	var bufferFrames uint32
+	if err := audioClient.GetBufferSize(&bufferFrames); err != nil {
+		audioClient.Release()
+		device.Release()
+		return nil, err
+	}
+
+	var renderClient *wca.IAudioRenderClient
+	if err := audioClient.GetService(wca.IID_IAudioRenderClient, &renderClient); err != nil {
+		audioClient.Release()
+		device.Release()
+		return nil, err
+	}
+
+	return &wasapiPlaybackStream{
+		name:         name,
+		format:       format,
+		device:       device,
+		audioClient:  audioClient,
+		renderClient: renderClient,
+		bufferFrames: bufferFrames,
+	}, nil
+}
+
+// visitDevices calls visit with every active endpoint for the data flow. The
+// endpoint is released afterwards unless visit returns true, which hands
+// ownership to the caller and stops the walk.
+func (b *WASAPIBackend) visitDevices(dataFlow uint32, visit func(name string, device *wca.IMMDevice) bool) error {
+	var pCollection *wca.IMMDeviceCollection
+	if err := b.enumerator.EnumAudioEndpoints(dataFlow, wca.DEVICE_STATE_ACTIVE, &pCollection); err != nil {
+		return err
+	}
+	defer pCollection.Release()
+
+	var count uint32
+	if err := pCollection.GetCount(&count); err != nil {
+		return err
+	}
+
+	for i := uint32(0); i < count; i++ {
+		var pEndpoint *wca.IMMDevice
+		if err := pCollection.Item(i, &pEndpoint); err != nil {
+			continue
+		}
+
+		name, err := deviceFriendlyName(pEndpoint)
+		if err != nil {
+			pEndpoint.Release()
+			continue
+		}
+
+		if visit(name, pEndpoint) {
+			return nil
+		}
+		pEndpoint.Release()
+	}
+	return nil
+}
+
+// findDevice returns the endpoint with the given friendly name, or the first
+// active endpoint when name is empty.
+func (b *WASAPIBackend) findDevice(dataFlow uint32, name string) (*wca.IMMDevice, string, error) {
+	var device *wca.IMMDevice
+	var deviceName string
+	err := b.visitDevices(dataFlow, func(n string, d *wca.IMMDevice) bool {
+		if name == "" || n == name {
+			device = d
+			deviceName = n
+			return true
+		}
+		return false
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	if device == nil {
+		return nil, "", fmt.Errorf("device not found")
+	}
+	return device, deviceName, nil
+}
+
+func deviceFriendlyName(device *wca.IMMDevice) (string, error) {
+	var pProps *wca.IPropertyStore
+	if err := device.OpenPropertyStore(wca.STGM_READ, &pProps); err != nil {
+		return "", err
+	}
+	defer pProps.Release()
+
+	var pv wca.PROPVARIANT
+	if err := pProps.GetValue(&wca.PKEY_Device_FriendlyName, &pv); err != nil {
+		return "", err
+	}
+	return pv.String(), nil
+}
+
+// waveFormatFromWCA copies a device format, including the
+// WAVEFORMATEXTENSIBLE fields that follow the 18-byte WAVEFORMATEX header in
+// COM memory.
+func waveFormatFromWCA(wfx *wca.WAVEFORMATEX) *WaveFormat {
+	format := &WaveFormat{
+		FormatTag:     wfx.WFormatTag,
+		Channels:      wfx.NChannels,
+		SampleRate:    wfx.NSamplesPerSec,
+		ByteRate:      wfx.NAvgBytesPerSec,
+		BlockAlign:    wfx.NBlockAlign,
+		BitsPerSample: wfx.WBitsPerSample,
+	}
+	if wfx.WFormatTag == waveFormatExtensible && wfx.CbSize >= extensibleSize {
+		// The Go struct is padded to 20 bytes, so the extension starts at
+		// the C offset rather than unsafe.Sizeof(*wfx).
+		extension := unsafe.Slice((*byte)(unsafe.Add(unsafe.Pointer(wfx), 18)), extensibleSize)
+		format.ValidBitsPerSample = binary.LittleEndian.Uint16(extension[0:2])
+		format.ChannelMask = binary.LittleEndian.Uint32(extension[2:6])
+		copy(format.SubFormat[:], extension[6:22])
+	}
+	return format
+}
+
+// waveFormatToWCA lays out a format as the WAVEFORMATEX COM expects, with
+// the WAVEFORMATEXTENSIBLE fields after it for EXTENSIBLE formats. The
+// buffer is built by hand for the same reason waveFormatFromWCA reads one
+// that way; it is allocated as words so the fields stay aligned.
+func waveFormatToWCA(format *WaveFormat) *wca.WAVEFORMATEX {
+	size, cbSize := 18, uint16(0)
+	if format.FormatTag == waveFormatExtensible {
+		size += extensibleSize
+		cbSize = extensibleSize
+	}
+	words := make([]uint32, (size+3)/4)
+	buf := unsafe.Slice((*byte)(unsafe.Pointer(&words[0])), size)
+	binary.LittleEndian.PutUint16(buf[0:2], format.FormatTag)
+	binary.LittleEndian.PutUint16(buf[2:4], format.Channels)
+	binary.LittleEndian.PutUint32(buf[4:8], format.SampleRate)
+	binary.LittleEndian.PutUint32(buf[8:12], format.ByteRate)
+	binary.LittleEndian.PutUint16(buf[12:14], format.BlockAlign)
+	binary.LittleEndian.PutUint16(buf[14:16], format.BitsPerSample)
+	binary.LittleEndian.PutUint16(buf[16:18], cbSize)
+	if cbSize != 0 {
+		binary.LittleEndian.PutUint16(buf[18:20], format.ValidBitsPerSample)
+		binary.LittleEndian.PutUint32(buf[20:24], format.ChannelMask)
+		copy(buf[24:40], format.SubFormat[:])
+	}
+	return (*wca.WAVEFORMATEX)(unsafe.Pointer(&words[0]))
+}
+
+type wasapiCaptureStream struct {
+	name          string
+	format        *WaveFormat
+	device        *wca.IMMDevice
+	audioClient   *wca.IAudioClient
+	captureClient *wca.IAudioCaptureClient
+}
+
+func (s *wasapiCaptureStream) DeviceName() string  { return s.name }
+func (s *wasapiCaptureStream) Format() *WaveFormat { return s.format }
+
+func (s *wasapiCaptureStream) Start() error {
+	return s.audioClient.Start()
+}
+
+func (s *wasapiCaptureStream) Read() ([]byte, error) {
+	var buffer *byte
+	var framesAvailable uint32
+	var flags uint32
+	var devicePosition uint64
+	var qpcPosition uint64
+
+	// AUDCLNT_S_BUFFER_EMPTY surfaces as an error too; either way there is
+	// nothing to hand out yet.
+	if err := s.captureClient.GetBuffer(&buffer, &framesAvailable, &flags, &devicePosition, &qpcPosition); err != nil {
+		return nil, nil
+	}
+
+	var chunk []byte
+	if framesAvailable > 0 {
+		bytesToCopy := int(framesAvailable) * int(s.format.BlockAlign)
+		chunk = make([]byte, bytesToCopy)
+
+		// Safety: buffer is valid until ReleaseBuffer
+		if flags&wca.AUDCLNT_BUFFERFLAGS_SILENT == 0 {
+			copy(chunk, unsafe.Slice(buffer, bytesToCopy))
+		}
+	}
+
+	if err := s.captureClient.ReleaseBuffer(framesAvailable); err != nil {
+		return nil, err
+	}
+	return chunk, nil
+}
+
+func (s *wasapiCaptureStream) Stop() error {
+	return s.audioClient.Stop()
+}
+
+func (s *wasapiCaptureStream) Close() error {
+	s.captureClient.Release()
+	s.audioClient.Release()
+	s.device.Release()
+	return nil
+}
+
+type wasapiPlaybackStream struct {
+	name         string
+	format       *WaveFormat
+	device       *wca.IMMDevice
+	audioClient  *wca.IAudioClient
+	renderClient *wca.IAudioRenderClient
+	bufferFrames uint32
+	started      bool
+}
+
+func (s *wasapiPlaybackStream) DeviceName() string  { return s.name }
+func (s *wasapiPlaybackStream) Format() *WaveFormat { return s.format }
+
+func (s *wasapiPlaybackStream) Write(data []byte) error {
+	blockAlign := int(s.format.BlockAlign)
+	for len(data) >= blockAlign {
+		var padding uint32
+		if err := s.audioClient.GetCurrentPadding(&padding); err != nil {
+			return err
+		}
+		available := int(s.bufferFrames - padding)
+		if available == 0 {
+			time.Sleep(5 * time.Millisecond)
+			continue
+		}
+
+		frames := min(available, len(data)/blockAlign)
+		var buffer *byte
+		if err := s.renderClient.GetBuffer(uint32(frames), &buffer); err != nil {
+			return err
+		}
+		n := frames * blockAlign
+		copy(unsafe.Slice(buffer, n), data[:n])
+		if err := s.renderClient.ReleaseBuffer(uint32(frames), 0); err != nil {
+			return err
+		}
+		data = data[n:]
+
+		if !s.started {
+			if err := s.audioClient.Start(); err != nil {
+				return err
+			}
+			s.started = true
+		}
+	}
+	return nil
+}
+
+func (s *wasapiPlaybackStream) Close() error {
+	defer s.device.Release()
+	defer s.audioClient.Release()
+	defer s.renderClient.Release()
+
+	if !s.started {
+		return nil
+	}
+	// Let the engine drain what is already queued before stopping
+	for {
+		var padding uint32
+		if err := s.audioClient.GetCurrentPadding(&padding); err != nil {
+			return err
+		}
+		if padding == 0 {
+			break
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	return s.audioClient.Stop()
+}
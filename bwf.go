@@ -0,0 +1,609 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Broadcast WAV metadata: the bext chunk of EBU Tech 3285 and the iXML chunk
+// used by location recorders. Recordings get both when they are made; `muxic
+// tag` edits them afterwards without touching the samples.
+
+// bextSize is the fixed part of a bext chunk, before the coding history.
+const bextSize = 602
+
+// bextVersion 1 adds the UMID to version 0; muxic leaves it and the version 2
+// loudness fields zeroed.
+const bextVersion = 1
+
+const bextOriginator = "muxic"
+
+// BroadcastInfo is the content of a bext chunk.
+type BroadcastInfo struct {
+	Description         string
+	Originator          string
+	OriginatorReference string
+	OriginationDate     string // yyyy-mm-dd
+	OriginationTime     string // hh:mm:ss
+	TimeReference       uint64 // samples since midnight at the first sample
+	CodingHistory       string // one CR/LF terminated line per processing step
+}
+
+// IXMLInfo is the part of an iXML chunk muxic knows about. Elements it does
+// not know are kept as they are when the chunk is rewritten.
+type IXMLInfo struct {
+	Project string
+	Scene   string
+	Take    string
+	Tracks  []string // one name per channel
+	other   []ixmlElement
+}
+
+// TrackMetadata is the broadcast metadata carried by a WAV track. Either part
+// may be nil.
+type TrackMetadata struct {
+	Broadcast *BroadcastInfo
+	IXML      *IXMLInfo
+}
+
+// bext field sizes, in order
+var bextFields = []int{256, 32, 32, 10, 8}
+
+func encodeBext(info *BroadcastInfo) []byte {
+	body := make([]byte, 0, bextSize+len(info.CodingHistory))
+	values := []string{info.Description, info.Originator, info.OriginatorReference, info.OriginationDate, info.OriginationTime}
+	for i, size := range bextFields {
+		field := make([]byte, size)
+		copy(field, values[i])
+		body = append(body, field...)
+	}
+	body = binary.LittleEndian.AppendUint64(body, info.TimeReference)
+	body = binary.LittleEndian.AppendUint16(body, bextVersion)
+	body = append(body, make([]byte, bextSize-len(body))...) // UMID, loudness and reserved
+	return append(body, info.CodingHistory...)
+}
+
+func parseBext(body []byte) (*BroadcastInfo, error) {
+	if len(body) < bextSize {
+		return nil, fmt.Errorf("bext chunk is %d bytes, expected at least %d", len(body), bextSize)
+	}
+	var values []string
+	offset := 0
+	for _, size := range bextFields {
+		values = append(values, bextString(body[offset:offset+size]))
+		offset += size
+	}
+	return &BroadcastInfo{
+		Description:         values[0],
+		Originator:          values[1],
+		OriginatorReference: values[2],
+		OriginationDate:     values[3],
+		OriginationTime:     values[4],
+		TimeReference:       binary.LittleEndian.Uint64(body[offset : offset+8]),
+		CodingHistory:       bextString(body[bextSize:]),
+	}, nil
+}
+
+// bextString reads a NUL-padded text field.
+func bextString(field []byte) string {
+	if i := bytes.IndexByte(field, 0); i >= 0 {
+		field = field[:i]
+	}
+	return strings.TrimRight(string(field), " ")
+}
+
+// codingHistoryLine describes a recording in the EBU R 98 format.
+func codingHistoryLine(wfx *WaveFormat) string {
+	bits := wfx.BitsPerSample
+	if wfx.FormatTag == waveFormatExtensible && wfx.ValidBitsPerSample != 0 {
+		bits = wfx.ValidBitsPerSample
+	}
+	mode := "multitrack"
+	switch wfx.Channels {
+	case 1:
+		mode = "mono"
+	case 2:
+		mode = "stereo"
+	}
+	return fmt.Sprintf("A=PCM,F=%d,W=%d,M=%s,T=%s\r\n", wfx.SampleRate, bits, mode, bextOriginator)
+}
+
+// newRecordingInfo fills in the bext fields for a take whose first sample
+// was captured at start.
+func newRecordingInfo(description string, wfx *WaveFormat, start time.Time) *BroadcastInfo {
+	midnight := time.Date(start.Year(), start.Month(), start.Day(), 0, 0, 0, 0, start.Location())
+	return &BroadcastInfo{
+		Description:     description,
+		Originator:      bextOriginator,
+		OriginationDate: start.Format("2006-01-02"),
+		OriginationTime: start.Format("15:04:05"),
+		TimeReference:   uint64(start.Sub(midnight).Seconds() * float64(wfx.SampleRate)),
+		CodingHistory:   codingHistoryLine(wfx),
+	}
+}
+
+// The iXML document, as far as muxic reads and writes it.
+type ixmlDocument struct {
+	XMLName   xml.Name       `xml:"BWFXML"`
+	Version   string         `xml:"IXML_VERSION"`
+	Project   string         `xml:"PROJECT,omitempty"`
+	Scene     string         `xml:"SCENE,omitempty"`
+	Take      string         `xml:"TAKE,omitempty"`
+	TrackList *ixmlTrackList `xml:"TRACK_LIST,omitempty"`
+	Other     []ixmlElement  `xml:",any"`
+}
+
+type ixmlTrackList struct {
+	Count  int         `xml:"TRACK_COUNT"`
+	Tracks []ixmlTrack `xml:"TRACK"`
+}
+
+type ixmlTrack struct {
+	ChannelIndex    int    `xml:"CHANNEL_INDEX"`
+	InterleaveIndex int    `xml:"INTERLEAVE_INDEX"`
+	Name            string `xml:"NAME"`
+}
+
+type ixmlElement struct {
+	XMLName xml.Name
+	Inner   string `xml:",innerxml"`
+}
+
+const ixmlVersion = "1.61"
+
+func encodeIXML(info *IXMLInfo) ([]byte, error) {
+	doc := ixmlDocument{Version: ixmlVersion, Project: info.Project, Scene: info.Scene, Take: info.Take, Other: info.other}
+	if len(info.Tracks) > 0 {
+		doc.TrackList = &ixmlTrackList{Count: len(info.Tracks)}
+		for i, name := range info.Tracks {
+			doc.TrackList.Tracks = append(doc.TrackList.Tracks, ixmlTrack{ChannelIndex: i + 1, InterleaveIndex: i + 1, Name: name})
+		}
+	}
+	body, err := xml.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), body...), nil
+}
+
+func parseIXML(body []byte) (*IXMLInfo, error) {
+	var doc ixmlDocument
+	// Some writers pad the chunk with NULs
+	if err := xml.Unmarshal(bytes.TrimRight(body, "\x00"), &doc); err != nil {
+		return nil, fmt.Errorf("invalid iXML chunk: %v", err)
+	}
+	info := &IXMLInfo{Project: doc.Project, Scene: doc.Scene, Take: doc.Take, other: doc.Other}
+	if doc.TrackList != nil {
+		tracks := slices.Clone(doc.TrackList.Tracks)
+		slices.SortFunc(tracks, func(a, b ixmlTrack) int { return a.InterleaveIndex - b.InterleaveIndex })
+		for _, track := range tracks {
+			info.Tracks = append(info.Tracks, track.Name)
+		}
+	}
+	return info, nil
+}
+
+// empty reports whether the iXML carries nothing worth writing.
+func (info *IXMLInfo) empty() bool {
+	return info.Project == "" && info.Scene == "" && info.Take == "" && len(info.Tracks) == 0 && len(info.other) == 0
+}
+
+// ixmlTrackNames names each channel of a track after it, the way recorders
+// label their tracks.
+func ixmlTrackNames(trackName string, channels int) []string {
+	if channels == 1 {
+		return []string{trackName}
+	}
+	names := make([]string, channels)
+	for c := range names {
+		names[c] = trackName + " " + channelLabel(c, channels)
+	}
+	return names
+}
+
+// encodeMetadataChunks returns the bext and iXML chunks for the metadata,
+// ready to be written into a WAV file.
+func encodeMetadataChunks(meta *TrackMetadata) ([]byte, error) {
+	var chunks []byte
+	if meta.Broadcast != nil {
+		chunks = appendRIFFChunk(chunks, "bext", encodeBext(meta.Broadcast))
+	}
+	if meta.IXML != nil && !meta.IXML.empty() {
+		body, err := encodeIXML(meta.IXML)
+		if err != nil {
+			return nil, err
+		}
+		chunks = appendRIFFChunk(chunks, "iXML", body)
+	}
+	return chunks, nil
+}
+
+func appendRIFFChunk(buf []byte, id string, body []byte) []byte {
+	buf = append(buf, id...)
+	buf = binary.LittleEndian.AppendUint32(buf, uint32(len(body)))
+	buf = append(buf, body...)
+	if len(body)%2 == 1 {
+		buf = append(buf, 0)
+	}
+	return buf
+}
+
+// wavChunk is where a top-level chunk sits in a WAV file.
+type wavChunk struct {
+	ID     string
+	Offset int64 // of the chunk header
+	Size   int64
+}
+
+// listWavChunks walks the top-level chunks of a WAV or RF64 file, taking
+// the data chunk's size from ds64 where needed. A truncated last chunk ends
+// the walk.
+func listWavChunks(f io.ReadSeeker) ([]wavChunk, error) {
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	rf64, err := readRIFFHeader(f)
+	if err != nil {
+		return nil, err
+	}
+
+	var chunks []wavChunk
+	var sizes *ds64
+	offset := int64(12)
+	chunkHeader := make([]byte, 8)
+	for {
+		if _, err := io.ReadFull(f, chunkHeader); err != nil {
+			return chunks, nil
+		}
+		chunkID := string(chunkHeader[0:4])
+		chunkSize, err := rf64ChunkSize(chunkID, int64(binary.LittleEndian.Uint32(chunkHeader[4:8])), sizes)
+		if err != nil {
+			return nil, err
+		}
+		if chunkID == "ds64" && rf64 {
+			body, err := readChunkBody(f, chunkID, chunkSize)
+			if err != nil {
+				return nil, err
+			}
+			if sizes, err = parseDS64Chunk(body); err != nil {
+				return nil, err
+			}
+		}
+		chunks = append(chunks, wavChunk{ID: chunkID, Offset: offset, Size: chunkSize})
+
+		offset += 8 + chunkSize + chunkSize%2
+		if _, err := f.Seek(offset, io.SeekStart); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// readWavChunk returns the body of a chunk found by listWavChunks.
+func readWavChunk(f io.ReadSeeker, chunk wavChunk) ([]byte, error) {
+	if _, err := f.Seek(chunk.Offset+8, io.SeekStart); err != nil {
+		return nil, err
+	}
+	return readChunkBody(f, chunk.ID, chunk.Size)
+}
+
+// isWavFile reports whether a file is WAV, RF64 or BW64 by its leading
+// bytes.
+func isWavFile(path string) (bool, error) {
+	magic, err := sniffAudioFile(path)
+	if err != nil {
+		return false, err
+	}
+	return magic == "RIFF" || magic == "RF64" || magic == "BW64", nil
+}
+
+// readTrackMetadata returns the bext and iXML metadata of a WAV file. Files
+// without either, and files that are not WAV at all, give empty metadata.
+func readTrackMetadata(path string) (*TrackMetadata, error) {
+	meta := &TrackMetadata{}
+	if ok, err := isWavFile(path); err != nil || !ok {
+		return meta, err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	chunks, err := listWavChunks(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	for _, chunk := range chunks {
+		if chunk.ID != "bext" && chunk.ID != "iXML" {
+			continue
+		}
+		body, err := readWavChunk(f, chunk)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+		if chunk.ID == "bext" {
+			meta.Broadcast, err = parseBext(body)
+		} else {
+			meta.IXML, err = parseIXML(body)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+	}
+	return meta, nil
+}
+
+// carriedMetadata returns the bext and iXML chunks and the tags of a file,
+// encoded for a new WAV file with the same audio, so rewriting a track does
+// not lose them. edit, when not nil, adjusts the metadata for the new file
+// first.
+func carriedMetadata(path string, edit func(*TrackMetadata)) ([]byte, error) {
+	meta, err := readTrackMetadata(path)
+	if err != nil {
+		return nil, err
+	}
+	if edit != nil {
+		edit(meta)
+	}
+	chunks, err := encodeMetadataChunks(meta)
+	if err != nil {
+		return nil, err
+	}
+	tags, err := readAudioTags(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(tags) > 0 {
+		chunks = append(chunks, encodeTagChunks(tags)...)
+	}
+	return chunks, nil
+}
+
+// writeTrackMetadata replaces the bext and iXML chunks of a WAV file.
+func writeTrackMetadata(path string, meta *TrackMetadata) error {
+	chunks, err := encodeMetadataChunks(meta)
+	if err != nil {
+		return err
+	}
+	isMetadata := func(id string, _ []byte) bool { return id == "bext" || id == "iXML" }
+	return rewriteWavChunks(path, isMetadata, chunks)
+}
+
+// rewriteWavChunks replaces every chunk that replace picks by the new chunks
+// without moving the sample data. replace sees each chunk's ID and up to
+// the first four bytes of its body, enough to tell LIST types apart. Old
+// chunks ahead of the data are renamed to JUNK, since they cannot change
+// size in place; the new ones go after the data, where the file is free to
+// grow.
+func rewriteWavChunks(path string, replace func(id string, head []byte) bool, newChunks []byte) error {
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	chunks, err := listWavChunks(f)
+	if err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+	data := slices.IndexFunc(chunks, func(c wavChunk) bool { return c.ID == "data" })
+	if data < 0 {
+		return fmt.Errorf("%s: missing data chunk", path)
+	}
+
+	replaced := func(chunk wavChunk) (bool, error) {
+		head := make([]byte, min(chunk.Size, 4))
+		if _, err := f.ReadAt(head, chunk.Offset+8); err != nil {
+			return false, err
+		}
+		return replace(chunk.ID, head), nil
+	}
+	for _, chunk := range chunks[:data] {
+		if ok, err := replaced(chunk); err != nil {
+			return err
+		} else if ok {
+			if _, err := f.WriteAt([]byte("JUNK"), chunk.Offset); err != nil {
+				return err
+			}
+		}
+	}
+
+	// Keep whatever else follows the data
+	dataChunk := chunks[data]
+	dataEnd := dataChunk.Offset + 8 + dataChunk.Size + dataChunk.Size%2
+	var trailer []byte
+	for _, chunk := range chunks[data+1:] {
+		if ok, err := replaced(chunk); err != nil {
+			return err
+		} else if ok {
+			continue
+		}
+		body, err := readWavChunk(f, chunk)
+		if err != nil {
+			return fmt.Errorf("%s: %v", path, err)
+		}
+		trailer = appendRIFFChunk(trailer, chunk.ID, body)
+	}
+	trailer = append(trailer, newChunks...)
+
+	if err := f.Truncate(dataEnd); err != nil {
+		return err
+	}
+	if dataChunk.Size%2 == 1 {
+		if _, err := f.WriteAt([]byte{0}, dataEnd-1); err != nil {
+			return err
+		}
+	}
+	if _, err := f.WriteAt(trailer, dataEnd); err != nil {
+		return err
+	}
+
+	riffSize := dataEnd + int64(len(trailer)) - 8
+	if ds64 := slices.IndexFunc(chunks, func(c wavChunk) bool { return c.ID == "ds64" }); ds64 >= 0 {
+		_, err = f.WriteAt(binary.LittleEndian.AppendUint64(nil, uint64(riffSize)), chunks[ds64].Offset+8)
+	} else if riffSize > math.MaxUint32 {
+		return fmt.Errorf("%s: metadata would take the file past the 4 GB WAV limit", path)
+	} else {
+		_, err = f.WriteAt(binary.LittleEndian.AppendUint32(nil, uint32(riffSize)), 4)
+	}
+	if err != nil {
+		return err
+	}
+	return f.Sync()
+}
+
+const tagUsage = `Usage:
+  muxic tag <track-name>                   Show a track's broadcast metadata
+  muxic tag <track-name> <key>=<value>...  Set description, project, scene, take
+                                           or tracks (comma-separated channel names)`
+
+// tagKeys are the metadata fields `muxic tag` can set.
+var tagKeys = []string{"description", "project", "scene", "take", "tracks"}
+
+// tagCommand shows or edits the bext and iXML metadata of a track.
+func tagCommand(args []string) error {
+	if len(args) < 1 {
+		return errors.New("track name required\n" + tagUsage)
+	}
+	trackName := args[0]
+	trackPath := getTrackPath(trackName)
+	if _, err := os.Stat(trackPath); os.IsNotExist(err) {
+		return fmt.Errorf("Track '%s' not found", trackName)
+	}
+	if ok, err := isWavFile(trackPath); err != nil {
+		return err
+	} else if !ok {
+		return fmt.Errorf("Track '%s' is not a WAV file; only WAV tracks carry broadcast metadata", trackName)
+	}
+
+	meta, err := readTrackMetadata(trackPath)
+	if err != nil {
+		return err
+	}
+	if len(args) == 1 {
+		fmt.Printf("[TAG] %s\n", trackName)
+		if !printTrackMetadata(meta) {
+			fmt.Println("  No broadcast metadata")
+		}
+		return nil
+	}
+
+	for _, arg := range args[1:] {
+		key, value, ok := strings.Cut(arg, "=")
+		if !ok || !slices.Contains(tagKeys, key) {
+			return fmt.Errorf("invalid tag '%s' (use %s=<value>)\n%s", arg, strings.Join(tagKeys, "|"), tagUsage)
+		}
+		if key == "description" {
+			if meta.Broadcast == nil {
+				meta.Broadcast = &BroadcastInfo{Originator: bextOriginator}
+			}
+			meta.Broadcast.Description = value
+			continue
+		}
+		if meta.IXML == nil {
+			meta.IXML = &IXMLInfo{}
+		}
+		switch key {
+		case "project":
+			meta.IXML.Project = value
+		case "scene":
+			meta.IXML.Scene = value
+		case "take":
+			meta.IXML.Take = value
+		case "tracks":
+			meta.IXML.Tracks = nil
+			if value != "" {
+				meta.IXML.Tracks = strings.Split(value, ",")
+			}
+		}
+	}
+
+	if err := writeTrackMetadata(trackPath, meta); err != nil {
+		return err
+	}
+	fmt.Printf("[OK] Updated metadata of '%s'\n", trackName)
+	return nil
+}
+
+// printTrackMetadata prints the metadata fields that are set, in the layout
+// of `muxic info`, and reports whether there were any.
+func printTrackMetadata(meta *TrackMetadata) bool {
+	printed := false
+	field := func(label, value string) {
+		if value != "" {
+			fmt.Printf("  %-12s %s\n", label+":", value)
+			printed = true
+		}
+	}
+	if b := meta.Broadcast; b != nil {
+		field("Description", b.Description)
+		field("Originator", strings.TrimSpace(b.Originator+" "+b.OriginatorReference))
+		field("Originated", strings.TrimSpace(b.OriginationDate+" "+b.OriginationTime))
+		if b.TimeReference != 0 {
+			field("Time Ref", fmt.Sprintf("%d samples", b.TimeReference))
+		}
+		for i, line := range strings.Split(strings.TrimSpace(b.CodingHistory), "\n") {
+			if i == 0 {
+				field("History", strings.TrimSpace(line))
+			} else {
+				fmt.Printf("  %-12s %s\n", "", strings.TrimSpace(line))
+			}
+		}
+	}
+	if x := meta.IXML; x != nil {
+		field("Project", x.Project)
+		field("Scene", x.Scene)
+		field("Take", x.Take)
+		field("Tracks", strings.Join(x.Tracks, ", "))
+	}
+	return printed
+}
+
+// recordingMetadata is what recordTrack asks to be embedded in a take. The
+// time-dependent bext fields are filled in when recording starts.
+type recordingMetadata struct {
+	Description string
+	Project     string
+	Scene       string
+	Take        string
+}
+
+// stamp builds the metadata for a take of trackName starting now.
+func (r *recordingMetadata) stamp(trackName string, wfx *WaveFormat, start time.Time) *TrackMetadata {
+	meta := &TrackMetadata{Broadcast: newRecordingInfo(r.Description, wfx, start)}
+	if r.Project != "" || r.Scene != "" || r.Take != "" {
+		meta.IXML = &IXMLInfo{
+			Project: r.Project,
+			Scene:   r.Scene,
+			Take:    r.Take,
+			Tracks:  ixmlTrackNames(trackName, int(wfx.Channels)),
+		}
+	}
+	return meta
+}
+
+// extractRecordingFlags pulls --description, --scene and --take out of the
+// record command's arguments.
+func extractRecordingFlags(args []string) ([]string, recordingMetadata, error) {
+	var meta recordingMetadata
+	var err error
+	if args, meta.Description, err = extractFlag(args, "description"); err != nil {
+		return nil, meta, err
+	}
+	if args, meta.Scene, err = extractFlag(args, "scene"); err != nil {
+		return nil, meta, err
+	}
+	if args, meta.Take, err = extractFlag(args, "take"); err != nil {
+		return nil, meta, err
+	}
+	return args, meta, nil
+}
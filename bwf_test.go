@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBextRoundTrip(t *testing.T) {
+	wfx := newPCMFormat(2, 48000, 24)
+	start := time.Date(2024, 3, 9, 1, 2, 3, 500_000_000, time.Local)
+	info := newRecordingInfo("Lead vocal, verse", wfx, start)
+
+	body := encodeBext(info)
+	if len(body) != bextSize+len(info.CodingHistory) {
+		t.Fatalf("Expected %d bytes, got %d", bextSize+len(info.CodingHistory), len(body))
+	}
+	parsed, err := parseBext(body)
+	if err != nil {
+		t.Fatalf("parseBext failed: %v", err)
+	}
+	if *parsed != *info {
+		t.Errorf("Expected %+v, got %+v", info, parsed)
+	}
+	if parsed.OriginationDate != "2024-03-09" || parsed.OriginationTime != "01:02:03" {
+		t.Errorf("Unexpected origination %s %s", parsed.OriginationDate, parsed.OriginationTime)
+	}
+	if parsed.TimeReference != 3723*48000+24000 {
+		t.Errorf("Expected the time reference to count samples since midnight, got %d", parsed.TimeReference)
+	}
+	if parsed.CodingHistory != "A=PCM,F=48000,W=24,M=stereo,T=muxic\r\n" {
+		t.Errorf("Unexpected coding history %q", parsed.CodingHistory)
+	}
+
+	if _, err := parseBext(body[:100]); err == nil {
+		t.Error("Expected a short bext chunk to fail")
+	}
+}
+
+func TestIXMLRoundTrip(t *testing.T) {
+	body := []byte(`<?xml version="1.0"?><BWFXML><IXML_VERSION>1.5</IXML_VERSION><PROJECT>Film</PROJECT>` +
+		`<SCENE>12A</SCENE><TAKE>3</TAKE><NOTE>Plane overhead</NOTE><TRACK_LIST><TRACK_COUNT>2</TRACK_COUNT>` +
+		`<TRACK><CHANNEL_INDEX>2</CHANNEL_INDEX><INTERLEAVE_INDEX>2</INTERLEAVE_INDEX><NAME>Boom</NAME></TRACK>` +
+		`<TRACK><CHANNEL_INDEX>1</CHANNEL_INDEX><INTERLEAVE_INDEX>1</INTERLEAVE_INDEX><NAME>Lav</NAME></TRACK>` +
+		"</TRACK_LIST></BWFXML>\x00\x00")
+	info, err := parseIXML(body)
+	if err != nil {
+		t.Fatalf("parseIXML failed: %v", err)
+	}
+	if info.Project != "Film" || info.Scene != "12A" || info.Take != "3" || !slices.Equal(info.Tracks, []string{"Lav", "Boom"}) {
+		t.Errorf("Unexpected iXML %+v", info)
+	}
+
+	info.Take = "4"
+	encoded, err := encodeIXML(info)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(encoded, []byte("<NOTE>Plane overhead</NOTE>")) {
+		t.Errorf("Expected unknown elements to be kept, got %s", encoded)
+	}
+	again, err := parseIXML(encoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again.Take != "4" || again.Scene != "12A" || !slices.Equal(again.Tracks, info.Tracks) {
+		t.Errorf("Unexpected iXML after round trip %+v", again)
+	}
+}
+
+func TestRecordFromBackend_Metadata(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "mic.wav")
+	trackPath := filepath.Join(dir, "vocals.wav")
+	writeTestTrack(t, input, 2, []int16{100, -100, 200, -200})
+
+	backend := &FileBackend{InputPath: input}
+	meta := &recordingMetadata{Description: "Verse", Project: "album", Take: "2"}
+	if err := recordFromBackend(backend, "", trackPath, storagePCM24, defaultDither, meta, strings.NewReader("\n")); err != nil {
+		t.Fatalf("recordFromBackend failed: %v", err)
+	}
+
+	got, err := readTrackMetadata(trackPath)
+	if err != nil {
+		t.Fatalf("readTrackMetadata failed: %v", err)
+	}
+	if got.Broadcast == nil || got.Broadcast.Description != "Verse" || got.Broadcast.Originator != "muxic" || got.Broadcast.OriginationDate == "" {
+		t.Fatalf("Unexpected bext %+v", got.Broadcast)
+	}
+	if !strings.HasPrefix(got.Broadcast.CodingHistory, "A=PCM,F=44100,W=24,M=stereo") {
+		t.Errorf("Unexpected coding history %q", got.Broadcast.CodingHistory)
+	}
+	if got.IXML == nil || got.IXML.Project != "album" || got.IXML.Take != "2" || !slices.Equal(got.IXML.Tracks, []string{"vocals L", "vocals R"}) {
+		t.Errorf("Unexpected iXML %+v", got.IXML)
+	}
+
+	if _, data, err := readWavFile(trackPath); err != nil || len(data) != 2*2*3 {
+		t.Errorf("Expected the take to read back as 24-bit audio, got %d bytes, %v", len(data), err)
+	}
+}
+
+func TestTagCommand(t *testing.T) {
+	isolateProjects(t)
+	projectRoot = t.TempDir()
+	if err := ensureTracksDir(); err != nil {
+		t.Fatal(err)
+	}
+	trackPath := getTrackPath("guitar")
+	wfx := newPCMFormat(1, 44100, 16)
+	meta, _ := encodeMetadataChunks(&TrackMetadata{Broadcast: newRecordingInfo("Old", wfx, time.Now())})
+	w, err := createLongWavWriter(trackPath, wfx, meta)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write([]byte{1, 2, 3, 4, 5, 6})
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	before, _ := os.ReadFile(trackPath)
+	dataOffset := w.layout.dataOffset
+
+	if err := tagCommand([]string{"guitar", "description=Rhythm", "scene=2", "tracks=Neck"}); err != nil {
+		t.Fatalf("tagCommand failed: %v", err)
+	}
+	after, _ := os.ReadFile(trackPath)
+	if !bytes.Equal(after[dataOffset:dataOffset+6], before[dataOffset:dataOffset+6]) {
+		t.Error("Expected the samples to stay where they were")
+	}
+
+	got, err := readTrackMetadata(trackPath)
+	if err != nil {
+		t.Fatalf("readTrackMetadata failed: %v", err)
+	}
+	if got.Broadcast.Description != "Rhythm" || got.Broadcast.OriginationDate == "" {
+		t.Errorf("Expected the description changed and the rest kept, got %+v", got.Broadcast)
+	}
+	if got.IXML == nil || got.IXML.Scene != "2" || !slices.Equal(got.IXML.Tracks, []string{"Neck"}) {
+		t.Errorf("Unexpected iXML %+v", got.IXML)
+	}
+	if _, data, err := readWavFile(trackPath); err != nil || !bytes.Equal(data, []byte{1, 2, 3, 4, 5, 6}) {
+		t.Errorf("Expected the audio unchanged, got %v, %v", data, err)
+	}
+
+	// Editing again replaces the trailing chunks rather than piling them up
+	if err := tagCommand([]string{"guitar", "description=Rhythm", "scene=2", "tracks=Neck"}); err != nil {
+		t.Fatal(err)
+	}
+	again, _ := os.ReadFile(trackPath)
+	if len(again) != len(after) {
+		t.Errorf("Expected the file to stay %d bytes, got %d", len(after), len(again))
+	}
+
+	if err := tagCommand([]string{"guitar", "colour=red"}); err == nil {
+		t.Error("Expected an unknown key to fail")
+	}
+	if err := tagCommand([]string{"missing"}); err == nil {
+		t.Error("Expected a missing track to fail")
+	}
+}
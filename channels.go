@@ -0,0 +1,281 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/bits"
+	"os"
+)
+
+// foldDownGain is the level side and centre channels join the front pair at
+// when folding multichannel audio down to stereo (ITU-R BS.775, -3 dB).
+var foldDownGain = math.Sqrt(0.5)
+
+// speakerLayout returns the speaker position of each channel, in WAV channel
+// order, for the usual layout of a channel count.
+func speakerLayout(channels int) ([]uint32, error) {
+	mask := defaultChannelMask(uint16(channels))
+	if bits.OnesCount32(mask) != channels {
+		return nil, fmt.Errorf("no known speaker layout for %d channels", channels)
+	}
+	var layout []uint32
+	for speaker := uint32(1); speaker != 0; speaker <<= 1 {
+		if mask&speaker != 0 {
+			layout = append(layout, speaker)
+		}
+	}
+	return layout, nil
+}
+
+// stereoContribution returns how much a speaker feeds the left and right
+// channels of a stereo fold-down. The LFE is dropped.
+func stereoContribution(speaker uint32) (left, right float64) {
+	switch speaker {
+	case speakerFrontLeft:
+		return 1, 0
+	case speakerFrontRight:
+		return 0, 1
+	case speakerFrontCenter:
+		return foldDownGain, foldDownGain
+	case speakerBackLeft, speakerSideLeft:
+		return foldDownGain, 0
+	case speakerBackRight, speakerSideRight:
+		return 0, foldDownGain
+	default:
+		return 0, 0
+	}
+}
+
+// toChannels returns the buffer's samples laid out for the given channel
+// count. Mono is placed in the centre of stereo under the pan law panLawDB,
+// so a 0 dB law copies it to both sides at full level. Stereo is downmixed
+// to mono by averaging, and multichannel audio is folded down to stereo
+// with the surrounds and centre at -3 dB. Going to more than two channels
+// fills the front pair, or the centre for mono, and leaves the rest silent.
+func (b *AudioBuffer) toChannels(channels int, panLawDB float64) ([]float64, error) {
+	if b.Channels == channels {
+		return b.Samples, nil
+	}
+	if channels < 1 {
+		return nil, fmt.Errorf("cannot map %d channels to %d", b.Channels, channels)
+	}
+
+	// Everything goes through stereo, the one layout every count maps to
+	stereo, err := b.toStereo(panLawDB)
+	if err != nil {
+		return nil, err
+	}
+	frames := len(stereo) / 2
+
+	switch {
+	case channels == 2:
+		return stereo, nil
+	case channels == 1:
+		out := make([]float64, frames)
+		for f := range out {
+			out[f] = (stereo[f*2] + stereo[f*2+1]) / 2
+		}
+		return out, nil
+	}
+
+	layout, err := speakerLayout(channels)
+	if err != nil {
+		return nil, fmt.Errorf("cannot map %d channels to %d: %v", b.Channels, channels, err)
+	}
+	out := make([]float64, frames*channels)
+	for c, speaker := range layout {
+		for f := 0; f < frames; f++ {
+			switch {
+			case b.Channels == 1 && speaker == speakerFrontCenter:
+				out[f*channels+c] = b.Samples[f]
+			case b.Channels == 1:
+			case speaker == speakerFrontLeft:
+				out[f*channels+c] = stereo[f*2]
+			case speaker == speakerFrontRight:
+				out[f*channels+c] = stereo[f*2+1]
+			}
+		}
+	}
+	return out, nil
+}
+
+// toStereo returns the buffer's samples as stereo, centring mono under the
+// given pan law.
+func (b *AudioBuffer) toStereo(panLawDB float64) ([]float64, error) {
+	frames := b.Frames()
+	switch b.Channels {
+	case 2:
+		return b.Samples, nil
+	case 1:
+		left, right := panLawGains(0, panLawDB)
+		out := make([]float64, frames*2)
+		for f, s := range b.Samples {
+			out[f*2] = s * left
+			out[f*2+1] = s * right
+		}
+		return out, nil
+	}
+
+	layout, err := speakerLayout(b.Channels)
+	if err != nil {
+		return nil, fmt.Errorf("cannot fold down: %v", err)
+	}
+	out := make([]float64, frames*2)
+	for c, speaker := range layout {
+		left, right := stereoContribution(speaker)
+		for f := 0; f < frames; f++ {
+			s := b.Samples[f*b.Channels+c]
+			out[f*2] += s * left
+			out[f*2+1] += s * right
+		}
+	}
+	return out, nil
+}
+
+// formatLike returns a format with the same sample encoding as wfx but the
+// given channel count and rate.
+func formatLike(wfx *WaveFormat, channels uint16, sampleRate uint32) *WaveFormat {
+	if isFloatFormat(wfx) {
+		return newFloatFormat(channels, sampleRate)
+	}
+	return newPCMFormat(channels, sampleRate, wfx.BitsPerSample)
+}
+
+// splitTrack writes each channel of a stereo track to its own mono track.
+// The samples are copied untouched.
+func splitTrack(trackName, leftName, rightName string) error {
+	if leftName == "" {
+		leftName = trackName + "_L"
+	}
+	if rightName == "" {
+		rightName = trackName + "_R"
+	}
+
+	trackPath := getTrackPath(trackName)
+	wfx, audioData, err := readAudioFile(trackPath)
+	if os.IsNotExist(err) {
+		return fmt.Errorf("Track '%s' not found", trackName)
+	}
+	if err != nil {
+		return err
+	}
+	if wfx.Channels != 2 {
+		return fmt.Errorf("Track '%s' has %d channels; only stereo tracks can be split", trackName, wfx.Channels)
+	}
+
+	for _, name := range []string{leftName, rightName} {
+		if _, err := os.Stat(getTrackPath(name)); err == nil {
+			return fmt.Errorf("Track '%s' already exists", name)
+		}
+	}
+
+	monoFormat := formatLike(wfx, 1, wfx.SampleRate)
+	for c, name := range []string{leftName, rightName} {
+		// Each half keeps the metadata, naming only its own channel
+		meta, err := carriedMetadata(trackPath, func(meta *TrackMetadata) {
+			if meta.IXML != nil && len(meta.IXML.Tracks) == 2 {
+				meta.IXML.Tracks = meta.IXML.Tracks[c : c+1]
+			}
+		})
+		if err != nil {
+			return err
+		}
+		if err := saveWavFile(getTrackPath(name), extractChannel(audioData, wfx, c), monoFormat, meta); err != nil {
+			return err
+		}
+	}
+
+	fmt.Printf("[OK] Split '%s' into '%s' and '%s'\n", trackName, leftName, rightName)
+	return nil
+}
+
+// mergeTracks interleaves two mono tracks into one stereo track. Both must
+// share a sample rate and format; the shorter one is padded with silence.
+func mergeTracks(leftName, rightName, outputName string) error {
+	var formats [2]*WaveFormat
+	var data [2][]byte
+	for i, name := range []string{leftName, rightName} {
+		wfx, audioData, err := readAudioFile(getTrackPath(name))
+		if os.IsNotExist(err) {
+			return fmt.Errorf("Track '%s' not found", name)
+		}
+		if err != nil {
+			return err
+		}
+		if wfx.Channels != 1 {
+			return fmt.Errorf("Track '%s' has %d channels; only mono tracks can be merged", name, wfx.Channels)
+		}
+		formats[i], data[i] = wfx, audioData
+	}
+
+	left, right := formats[0], formats[1]
+	if left.SampleRate != right.SampleRate {
+		return fmt.Errorf("'%s' is %d Hz but '%s' is %d Hz", leftName, left.SampleRate, rightName, right.SampleRate)
+	}
+	if left.Encoding() != right.Encoding() || left.BitsPerSample != right.BitsPerSample {
+		return errors.New("both tracks must be stored in the same format to merge")
+	}
+
+	outputPath := getTrackPath(outputName)
+	if _, err := os.Stat(outputPath); err == nil {
+		return fmt.Errorf("Track '%s' already exists", outputName)
+	}
+
+	// The left track's metadata goes with the merge, naming both channels
+	rightMeta, err := readTrackMetadata(getTrackPath(rightName))
+	if err != nil {
+		return err
+	}
+	meta, err := carriedMetadata(getTrackPath(leftName), func(meta *TrackMetadata) {
+		if meta.IXML != nil && len(meta.IXML.Tracks) == 1 && rightMeta.IXML != nil && len(rightMeta.IXML.Tracks) == 1 {
+			meta.IXML.Tracks = append(meta.IXML.Tracks, rightMeta.IXML.Tracks[0])
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	stereoFormat := formatLike(left, 2, left.SampleRate)
+	if err := saveWavFile(outputPath, interleaveChannels(data[0], data[1], left), stereoFormat, meta); err != nil {
+		return err
+	}
+	fmt.Printf("[OK] Merged '%s' and '%s' into '%s'\n", leftName, rightName, outputName)
+	return nil
+}
+
+// extractChannel returns the raw samples of one channel.
+func extractChannel(data []byte, wfx *WaveFormat, channel int) []byte {
+	sampleSize := int(wfx.BlockAlign) / int(wfx.Channels)
+	frames := len(data) / int(wfx.BlockAlign)
+	out := make([]byte, frames*sampleSize)
+	for f := 0; f < frames; f++ {
+		start := f*int(wfx.BlockAlign) + channel*sampleSize
+		copy(out[f*sampleSize:], data[start:start+sampleSize])
+	}
+	return out
+}
+
+// interleaveChannels combines two mono sample streams of the given format
+// into stereo frames, padding the shorter with silence.
+func interleaveChannels(left, right []byte, wfx *WaveFormat) []byte {
+	sampleSize := int(wfx.BlockAlign)
+	frames := max(len(left), len(right)) / sampleSize
+	out := make([]byte, frames*2*sampleSize)
+	if wfx.BitsPerSample == 8 {
+		// 8-bit WAV is unsigned, so silence is the midpoint
+		for i := range out {
+			out[i] = 128
+		}
+	}
+	for f := 0; f < frames; f++ {
+		start := f * sampleSize
+		if start < len(left) {
+			copy(out[f*2*sampleSize:], left[start:start+sampleSize])
+		}
+		if start < len(right) {
+			copy(out[(f*2+1)*sampleSize:], right[start:start+sampleSize])
+		}
+	}
+	return out
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"bytes"
+	"math"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func samplesClose(got, want []float64) bool {
+	if len(got) != len(want) {
+		return false
+	}
+	for i := range got {
+		if math.Abs(got[i]-want[i]) > 1e-9 {
+			return false
+		}
+	}
+	return true
+}
+
+func TestToChannels(t *testing.T) {
+	g := foldDownGain
+	surround := []float64{0.1, 0.2, 0.3, 0.9, 0.4, 0.5} // L R C LFE Ls Rs
+	tests := []struct {
+		name     string
+		in       *AudioBuffer
+		channels int
+		want     []float64
+	}{
+		{"mono to stereo", &AudioBuffer{Channels: 1, Samples: []float64{0.5, -0.25}}, 2, []float64{0.5, 0.5, -0.25, -0.25}},
+		{"stereo to mono", &AudioBuffer{Channels: 2, Samples: []float64{0.5, 0.25}}, 1, []float64{0.375}},
+		{"5.1 to stereo", &AudioBuffer{Channels: 6, Samples: surround}, 2, []float64{0.1 + 0.3*g + 0.4*g, 0.2 + 0.3*g + 0.5*g}},
+		{"5.1 to mono", &AudioBuffer{Channels: 6, Samples: surround}, 1, []float64{(0.3 + 0.6*g + 0.9*g) / 2}},
+		{"stereo to 5.1", &AudioBuffer{Channels: 2, Samples: []float64{0.5, 0.25}}, 6, []float64{0.5, 0.25, 0, 0, 0, 0}},
+		{"mono to 5.1", &AudioBuffer{Channels: 1, Samples: []float64{0.5}}, 6, []float64{0, 0, 0.5, 0, 0, 0}},
+	}
+	for _, tt := range tests {
+		got, err := tt.in.toChannels(tt.channels, 0)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.name, err)
+			continue
+		}
+		if !samplesClose(got, tt.want) {
+			t.Errorf("%s: expected %v, got %v", tt.name, tt.want, got)
+		}
+	}
+
+	// A pan law turns a centred mono source down on both sides
+	mono := &AudioBuffer{Channels: 1, Samples: []float64{0.5}}
+	for law, gain := range map[float64]float64{-3: math.Sqrt(0.5), -6: 0.5} {
+		got, err := mono.toChannels(2, law)
+		if err != nil || !samplesClose(got, []float64{0.5 * gain, 0.5 * gain}) {
+			t.Errorf("%g dB law: expected both sides at %g, got %v, %v", law, 0.5*gain, got, err)
+		}
+	}
+
+	if _, err := (&AudioBuffer{Channels: 7, Samples: make([]float64, 7)}).toChannels(2, 0); err == nil {
+		t.Error("Expected an error folding down a layout with no known speakers")
+	}
+}
+
+func TestSplitAndMerge(t *testing.T) {
+	isolateProjects(t)
+	projectRoot = t.TempDir()
+	if err := ensureTracksDir(); err != nil {
+		t.Fatal(err)
+	}
+	writeTestTrack(t, getTrackPath("overheads"), 2, []int16{-32768, 32767, 1, -1, 100, 200})
+
+	if err := splitTrack("overheads", "", ""); err != nil {
+		t.Fatalf("splitTrack failed: %v", err)
+	}
+	wfx, left, err := readWavFile(getTrackPath("overheads_L"))
+	if err != nil {
+		t.Fatalf("Failed to read left track: %v", err)
+	}
+	if wfx.Channels != 1 || wfx.BitsPerSample != 16 {
+		t.Errorf("Unexpected left track format: %+v", wfx)
+	}
+	if want := []byte{0x00, 0x80, 0x01, 0x00, 0x64, 0x00}; !bytes.Equal(left, want) {
+		t.Errorf("Expected left samples %v copied untouched, got %v", want, left)
+	}
+
+	if err := mergeTracks("overheads_L", "overheads_R", "rejoined"); err != nil {
+		t.Fatalf("mergeTracks failed: %v", err)
+	}
+	_, original, _ := readWavFile(getTrackPath("overheads"))
+	_, rejoined, err := readWavFile(getTrackPath("rejoined"))
+	if err != nil {
+		t.Fatalf("Failed to read merged track: %v", err)
+	}
+	if !bytes.Equal(rejoined, original) {
+		t.Errorf("Expected split and merge to round-trip exactly, got %v from %v", rejoined, original)
+	}
+
+	if err := mergeTracks("overheads", "overheads_R", "bad"); err == nil {
+		t.Error("Expected merging a stereo track to fail")
+	}
+	if err := splitTrack("overheads", "", ""); err == nil {
+		t.Error("Expected splitting over existing tracks to fail")
+	}
+}
+
+func TestConvertTrackFile_Channels(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "stereo.wav")
+	output := filepath.Join(dir, "mono.wav")
+	writeTestTrack(t, input, 2, []int16{16384, 0, 8192, 8192})
+
+	if err := convertTrackFile(input, output, exportOptions{Channels: 1, Dither: ditherNone}); err != nil {
+		t.Fatalf("convertTrackFile failed: %v", err)
+	}
+	track, err := loadTrack(output)
+	if err != nil {
+		t.Fatalf("Failed to read converted track: %v", err)
+	}
+	if track.Channels != 1 || !samplesClose(track.Samples, []float64{8192.0 / 32768, 8192.0 / 32768}) {
+		t.Errorf("Expected a two-frame mono downmix, got %d channels %v", track.Channels, track.Samples)
+	}
+}
+
+func TestExportTrack_MonoToStereoPanLaw(t *testing.T) {
+	isolateProjects(t)
+	projectRoot = t.TempDir()
+	if err := ensureTracksDir(); err != nil {
+		t.Fatal(err)
+	}
+	law := -6.0
+	if err := (Project{PanLaw: &law}).Save(); err != nil {
+		t.Fatal(err)
+	}
+	writeTestTrack(t, getTrackPath("vocals"), 1, []int16{16384, -8192})
+
+	output := filepath.Join(t.TempDir(), "vocals.wav")
+	if err := exportTrack("vocals", output, exportOptions{Channels: 2, Dither: ditherNone}); err != nil {
+		t.Fatalf("exportTrack failed: %v", err)
+	}
+	track, err := loadTrack(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []float64{0.25, 0.25, -0.125, -0.125}; track.Channels != 2 || !samplesClose(track.Samples, want) {
+		t.Errorf("Expected the -6 dB pan law on both sides %v, got %v", want, track.Samples)
+	}
+}
+
+func TestSplitAndMerge_Metadata(t *testing.T) {
+	isolateProjects(t)
+	projectRoot = t.TempDir()
+	if err := ensureTracksDir(); err != nil {
+		t.Fatal(err)
+	}
+	wfx := newPCMFormat(2, 44100, 16)
+	meta, err := encodeMetadataChunks(&TrackMetadata{
+		Broadcast: &BroadcastInfo{Description: "Room"},
+		IXML:      &IXMLInfo{Tracks: []string{"Left", "Right"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := saveWavFile(getTrackPath("overheads"), []byte{1, 0, 2, 0}, wfx, meta); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := splitTrack("overheads", "", ""); err != nil {
+		t.Fatalf("splitTrack failed: %v", err)
+	}
+	for name, channel := range map[string]string{"overheads_L": "Left", "overheads_R": "Right"} {
+		got, err := readTrackMetadata(getTrackPath(name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Broadcast == nil || got.Broadcast.Description != "Room" || got.IXML == nil || !slices.Equal(got.IXML.Tracks, []string{channel}) {
+			t.Errorf("%s: unexpected metadata %+v %+v", name, got.Broadcast, got.IXML)
+		}
+	}
+
+	if err := mergeTracks("overheads_L", "overheads_R", "rejoined"); err != nil {
+		t.Fatalf("mergeTracks failed: %v", err)
+	}
+	got, err := readTrackMetadata(getTrackPath("rejoined"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Broadcast == nil || got.Broadcast.Description != "Room" || got.IXML == nil || !slices.Equal(got.IXML.Tracks, []string{"Left", "Right"}) {
+		t.Errorf("Unexpected merged metadata %+v %+v", got.Broadcast, got.IXML)
+	}
+}
@@ -3,17 +3,50 @@ package main
 import (
 	"encoding/json"
 	"os"
+	"path/filepath"
 )
 
 type Config struct {
 	DefaultDevice *string `json:"default_device,omitempty"`
+	// Backend selects the audio layer ("wasapi" or "file"); the platform
+	// default is used when unset.
+	Backend *string `json:"backend,omitempty"`
+	// VirtualInput and VirtualOutput are the WAV files the file backend
+	// records from and plays to.
+	VirtualInput  *string `json:"virtual_input,omitempty"`
+	VirtualOutput *string `json:"virtual_output,omitempty"`
+	// StorageFormat is how new tracks and mixes are stored: "pcm16",
+	// "pcm24" or "float32". Projects can override it.
+	StorageFormat *string `json:"storage_format,omitempty"`
+	// ActiveProject is the root directory commands work in when no
+	// --project flag is given. Unset means the current directory.
+	ActiveProject *string `json:"active_project,omitempty"`
+	// Projects maps project names to their root directories.
+	Projects map[string]string `json:"projects,omitempty"`
 }
 
 const configFileName = "muxic_config.json"
 
+// configPath returns where the user config lives. MUXIC_CONFIG overrides the
+// per-user location, which is handy for portable installs and tests.
+func configPath() string {
+	if path := os.Getenv("MUXIC_CONFIG"); path != "" {
+		return path
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return configFileName
+	}
+	return filepath.Join(dir, "muxic", configFileName)
+}
+
 func LoadConfig() (Config, error) {
 	var config Config
-	data, err := os.ReadFile(configFileName)
+	data, err := os.ReadFile(configPath())
+	if os.IsNotExist(err) {
+		// Older versions kept the config in the working directory
+		data, err = os.ReadFile(configFileName)
+	}
 	if os.IsNotExist(err) {
 		return config, nil
 	}
@@ -29,5 +62,9 @@ func (c Config) Save() error {
 	if err != nil {
 		return err
 	}
-	return os.WriteFile(configFileName, data, 0644)
+	path := configPath()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
 }
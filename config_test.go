@@ -2,21 +2,13 @@ package main
 
 import (
 	"os"
+	"path/filepath"
 	"testing"
 )
 
 func TestConfig_SaveAndLoad(t *testing.T) {
-	// Setup: Remove existing config file if present (backup could be handled, but for test simplicity we just clean up)
-	// In a real scenario, we might want to use a temp dir or mock the filesystem, but LoadConfig hardcodes "muxic_config.json"
-	// For this test, we will rename the real config if it exists, and restore it after.
-
-	if _, err := os.Stat(configFileName); err == nil {
-		os.Rename(configFileName, configFileName+".bak")
-		defer os.Rename(configFileName+".bak", configFileName)
-	} else {
-		// If it didn't exist, ensure we clean up the test file
-		defer os.Remove(configFileName)
-	}
+	// Point the user config at a temp dir so the real one is left alone
+	t.Setenv("MUXIC_CONFIG", filepath.Join(t.TempDir(), "muxic", configFileName))
 
 	deviceID := "{0.0.0.00000000}.{some-guid}"
 	cfg := Config{
@@ -30,7 +22,7 @@ func TestConfig_SaveAndLoad(t *testing.T) {
 	}
 
 	// Verify file exists
-	if _, err := os.Stat(configFileName); os.IsNotExist(err) {
+	if _, err := os.Stat(configPath()); os.IsNotExist(err) {
 		t.Errorf("Config file was not created")
 	}
 
@@ -49,7 +41,8 @@ func TestConfig_SaveAndLoad(t *testing.T) {
 }
 
 func TestLoadConfig_NoFile(t *testing.T) {
-	// Ensure no config file exists
+	// Ensure no config file exists, including a legacy one in the working directory
+	t.Setenv("MUXIC_CONFIG", filepath.Join(t.TempDir(), configFileName))
 	if _, err := os.Stat(configFileName); err == nil {
 		os.Rename(configFileName, configFileName+".bak")
 		defer os.Rename(configFileName+".bak", configFileName)
@@ -63,3 +56,24 @@ func TestLoadConfig_NoFile(t *testing.T) {
 		t.Error("Expected nil DefaultDevice when no config file exists")
 	}
 }
+
+func TestLoadConfig_LegacyFile(t *testing.T) {
+	t.Setenv("MUXIC_CONFIG", filepath.Join(t.TempDir(), configFileName))
+	if _, err := os.Stat(configFileName); err == nil {
+		os.Rename(configFileName, configFileName+".bak")
+		defer os.Rename(configFileName+".bak", configFileName)
+	}
+	defer os.Remove(configFileName)
+
+	if err := os.WriteFile(configFileName, []byte(`{"default_device": "Legacy Mic"}`), 0644); err != nil {
+		t.Fatalf("Failed to write legacy config: %v", err)
+	}
+
+	cfg, err := LoadConfig()
+	if err != nil {
+		t.Fatalf("LoadConfig failed: %v", err)
+	}
+	if cfg.DefaultDevice == nil || *cfg.DefaultDevice != "Legacy Mic" {
+		t.Errorf("Expected legacy config to be read, got %+v", cfg)
+	}
+}
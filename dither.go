@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/rand/v2"
+)
+
+// Dither modes for reducing word length.
+const (
+	ditherNone   = "none"
+	ditherTPDF   = "tpdf"
+	ditherShaped = "shaped"
+)
+
+const defaultDither = ditherTPDF
+
+// defaultDitherSeed seeds the dither noise. A fixed seed keeps renders of the
+// same material bit-identical from run to run.
+const defaultDitherSeed = 0x6d7578
+
+// Ditherer quantizes samples to a smaller word length. TPDF dither adds
+// triangular noise of one LSB peak to decorrelate the quantization error from
+// the signal; shaped dither additionally feeds the error back through a
+// first-order highpass, moving the noise towards frequencies the ear is less
+// sensitive to. State is kept per channel so a stream can be quantized in
+// chunks.
+type Ditherer struct {
+	mode  string
+	rng   *rand.Rand
+	error []float64
+}
+
+func newDitherer(mode string, channels int, seed uint64) (*Ditherer, error) {
+	switch mode {
+	case ditherNone, ditherTPDF, ditherShaped:
+	default:
+		return nil, fmt.Errorf("unknown dither '%s' (use %s, %s or %s)", mode, ditherNone, ditherTPDF, ditherShaped)
+	}
+	return &Ditherer{
+		mode:  mode,
+		rng:   rand.New(rand.NewPCG(seed, seed)),
+		error: make([]float64, channels),
+	}, nil
+}
+
+// quantize rounds a sample already scaled to integer steps, returning the
+// integer it becomes. Clamping to the target range is left to the caller.
+func (d *Ditherer) quantize(value float64, channel int) float64 {
+	if d == nil || d.mode == ditherNone {
+		return math.Round(value)
+	}
+
+	if d.mode == ditherShaped {
+		value -= d.error[channel]
+	}
+	noise := d.rng.Float64() - d.rng.Float64()
+	quantized := math.Round(value + noise)
+	if d.mode == ditherShaped {
+		d.error[channel] = quantized - value
+	}
+	return quantized
+}
+
+// reducesWordLength reports whether converting between two formats loses
+// resolution, which is when dither is worth adding.
+func reducesWordLength(from, to *WaveFormat) bool {
+	if isFloatFormat(to) {
+		return false
+	}
+	return isFloatFormat(from) || from.BitsPerSample > to.BitsPerSample
+}
+
+// ditherFor returns a ditherer for converting between two formats, or nil
+// when the conversion keeps every bit and plain rounding is exact.
+func ditherFor(from, to *WaveFormat, mode string) (*Ditherer, error) {
+	if !reducesWordLength(from, to) {
+		return nil, nil
+	}
+	return newDitherer(mode, int(to.Channels), defaultDitherSeed)
+}
+
+// describeDither renders the dither used for a conversion for status output,
+// e.g. " with tpdf dither", or nothing when none is applied.
+func describeDither(from, to *WaveFormat, mode string) string {
+	if !reducesWordLength(from, to) || mode == ditherNone {
+		return ""
+	}
+	return fmt.Sprintf(" with %s dither", mode)
+}
+
+// extractDitherFlag pulls a --dither flag out of the arguments, defaulting to
+// TPDF dither.
+func extractDitherFlag(args []string) ([]string, string, error) {
+	rest, mode, err := extractFlag(args, "dither")
+	if err != nil {
+		return nil, "", err
+	}
+	if mode == "" {
+		mode = defaultDither
+	}
+	if _, err := newDitherer(mode, 0, 0); err != nil {
+		return nil, "", err
+	}
+	return rest, mode, nil
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"math"
+	"path/filepath"
+	"testing"
+)
+
+// quantizeAll runs a constant value through a fresh ditherer and returns the
+// quantization error of each sample.
+func quantizeAll(t *testing.T, mode string, value float64, n int) []float64 {
+	t.Helper()
+	d, err := newDitherer(mode, 1, 42)
+	if err != nil {
+		t.Fatalf("newDitherer failed: %v", err)
+	}
+	errs := make([]float64, n)
+	for i := range errs {
+		errs[i] = d.quantize(value, 0) - value
+	}
+	return errs
+}
+
+func TestDitherer_TPDFTracksSubLSBSignal(t *testing.T) {
+	// A quarter of an LSB rounds to silence without dither, but its average
+	// survives with it
+	if errs := quantizeAll(t, ditherNone, 0.25, 1000); meanOf(errs) != -0.25 {
+		t.Errorf("Expected undithered error of -0.25, got %f", meanOf(errs))
+	}
+
+	errs := quantizeAll(t, ditherTPDF, 0.25, 100000)
+	if mean := meanOf(errs); math.Abs(mean) > 0.01 {
+		t.Errorf("Expected dithered error to average out, got %f", mean)
+	}
+	for i, e := range errs {
+		if math.Abs(e) > 1.5 {
+			t.Fatalf("Sample %d: error %f exceeds 1.5 LSB", i, e)
+		}
+	}
+}
+
+func TestDitherer_ShapedPushesNoiseHigh(t *testing.T) {
+	// Highpass-shaped noise alternates sign from sample to sample, so its
+	// lag-1 autocorrelation is strongly negative; plain TPDF is white
+	autocorrelation := func(errs []float64) float64 {
+		var lag0, lag1 float64
+		for i := 1; i < len(errs); i++ {
+			lag0 += errs[i] * errs[i]
+			lag1 += errs[i] * errs[i-1]
+		}
+		return lag1 / lag0
+	}
+
+	if r := autocorrelation(quantizeAll(t, ditherTPDF, 0.3, 50000)); math.Abs(r) > 0.05 {
+		t.Errorf("Expected white TPDF noise, got lag-1 autocorrelation %f", r)
+	}
+	if r := autocorrelation(quantizeAll(t, ditherShaped, 0.3, 50000)); r > -0.3 {
+		t.Errorf("Expected shaped noise to be highpass, got lag-1 autocorrelation %f", r)
+	}
+}
+
+func TestDitherer_Deterministic(t *testing.T) {
+	first := quantizeAll(t, ditherShaped, 0.7, 100)
+	second := quantizeAll(t, ditherShaped, 0.7, 100)
+	for i := range first {
+		if first[i] != second[i] {
+			t.Fatalf("Sample %d differs between runs with the same seed", i)
+		}
+	}
+}
+
+func TestNewDitherer_UnknownMode(t *testing.T) {
+	if _, err := newDitherer("rpdf", 2, 1); err == nil {
+		t.Error("Expected an error for an unknown dither mode")
+	}
+	if _, _, err := extractDitherFlag([]string{"mix", "--dither", "rpdf"}); err == nil {
+		t.Error("Expected --dither to reject an unknown mode")
+	}
+}
+
+func TestConvertTrackFile_DithersTo16Bit(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "float.wav")
+	output := filepath.Join(dir, "pcm16.wav")
+
+	// A quiet float signal well below one 16-bit LSB
+	samples := make([]float64, 4096)
+	for i := range samples {
+		samples[i] = 0.3 / 32768
+	}
+	wfx := newFloatFormat(1, 44100)
+	data, err := encodeSamples(samples, wfx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := saveWavFile(input, data, wfx); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := convertTrackFile(input, output, exportOptions{Format: storagePCM16, Dither: ditherTPDF}); err != nil {
+		t.Fatalf("convertTrackFile failed: %v", err)
+	}
+	track, err := loadTrack(output)
+	if err != nil {
+		t.Fatalf("Failed to read converted track: %v", err)
+	}
+	if track.Peak() == 0 {
+		t.Error("Expected dither to keep the quiet signal from rounding to silence")
+	}
+}
@@ -0,0 +1,1316 @@
+package main
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/bits"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// FLAC encoding and decoding. The encoder writes fixed-size blocks of
+// constant, verbatim, fixed-polynomial or LPC subframes with partitioned Rice
+// residuals, trying left/side, side/right and mid/side decorrelation on
+// stereo material. The decoder reads any stream the format allows.
+
+const (
+	flacMagic              = "fLaC"
+	flacBlockStreamInfo    = 0
+	flacBlockVorbisComment = 4
+	flacStreamInfoSize     = 34
+	defaultFLACCompression = 5
+	maxFLACCompression     = 8
+)
+
+// flacLevel is the encoder effort for one compression level, modelled on
+// the reference encoder's presets.
+type flacLevel struct {
+	blockSize         int
+	maxLPCOrder       int  // 0 uses fixed predictors only
+	maxPartitionOrder int  // finest Rice partitioning tried
+	stereo            bool // try inter-channel decorrelation
+	searchLPCOrders   bool // try every LPC order instead of the estimated best
+}
+
+var flacLevels = [maxFLACCompression + 1]flacLevel{
+	{blockSize: 1152, maxLPCOrder: 0, maxPartitionOrder: 3},
+	{blockSize: 1152, maxLPCOrder: 0, maxPartitionOrder: 3, stereo: true},
+	{blockSize: 1152, maxLPCOrder: 0, maxPartitionOrder: 4, stereo: true},
+	{blockSize: 4096, maxLPCOrder: 6, maxPartitionOrder: 4, stereo: true},
+	{blockSize: 4096, maxLPCOrder: 8, maxPartitionOrder: 4, stereo: true},
+	{blockSize: 4096, maxLPCOrder: 8, maxPartitionOrder: 5, stereo: true},
+	{blockSize: 4096, maxLPCOrder: 8, maxPartitionOrder: 6, stereo: true, searchLPCOrders: true},
+	{blockSize: 4096, maxLPCOrder: 12, maxPartitionOrder: 6, stereo: true, searchLPCOrders: true},
+	{blockSize: 4096, maxLPCOrder: 12, maxPartitionOrder: 8, stereo: true, searchLPCOrders: true},
+}
+
+// Channel assignments in the frame header. Values below flacLeftSide are
+// the channel count minus one, coded independently.
+const (
+	flacLeftSide  = 8
+	flacSideRight = 9
+	flacMidSide   = 10
+)
+
+// Subframe types as coded in the subframe header.
+const (
+	flacSubframeConstant = 0
+	flacSubframeVerbatim = 1
+	flacSubframeFixed    = 8  // plus the order, 0 to 4
+	flacSubframeLPC      = 32 // plus the order minus one, 1 to 32
+)
+
+// Residual coding methods and their escape codes.
+const (
+	flacRice       = 0
+	flacRice2      = 1
+	flacRiceEscape = 15
+	flacRice2Esc   = 31
+)
+
+const flacMaxFixedOrder = 4
+
+// extractCompressionFlag pulls a --compression flag out of the arguments,
+// defaulting to level 5.
+func extractCompressionFlag(args []string) ([]string, int, error) {
+	rest, value, err := extractFlag(args, "compression")
+	if err != nil {
+		return nil, 0, err
+	}
+	if value == "" {
+		return rest, defaultFLACCompression, nil
+	}
+	level, err := strconv.Atoi(value)
+	if err != nil || level < 0 || level > maxFLACCompression {
+		return nil, 0, fmt.Errorf("invalid compression level '%s' (use 0 to %d)", value, maxFLACCompression)
+	}
+	return rest, level, nil
+}
+
+func isFLACPath(path string) bool {
+	return strings.EqualFold(filepath.Ext(path), ".flac")
+}
+
+// saveFLACFile writes integer PCM sample data, laid out as in a WAV data
+// chunk, as a FLAC file at the given compression level.
+func saveFLACFile(path string, audioData []byte, wfx *WaveFormat, level int) error {
+	encoded, err := encodeFLAC(audioData, wfx, level)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, encoded, 0644)
+}
+
+// readFLACFile decodes a FLAC file into a format and sample data laid out as
+// in a WAV data chunk.
+func readFLACFile(path string) (*WaveFormat, []byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	wfx, audioData, err := decodeFLAC(data)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return wfx, audioData, nil
+}
+
+// fitsFLAC reports whether FLAC can hold a format's samples as they are,
+// which rules out float and integers of more than 24 bits.
+func fitsFLAC(wfx *WaveFormat) bool {
+	if isFloatFormat(wfx) {
+		return false
+	}
+	bits := wfx.BitsPerSample
+	if wfx.FormatTag == waveFormatExtensible && wfx.ValidBitsPerSample != 0 {
+		bits = wfx.ValidBitsPerSample
+	}
+	return bits <= 24
+}
+
+// flacBitsPerSample returns the sample resolution FLAC should store for a
+// WAV format, or an error for formats FLAC cannot hold.
+func flacBitsPerSample(wfx *WaveFormat) (int, error) {
+	if wfx.Encoding() != waveFormatPCM {
+		return 0, errors.New("FLAC only stores integer PCM; export with --format pcm16 or pcm24")
+	}
+	bps := int(wfx.BitsPerSample)
+	if wfx.FormatTag == waveFormatExtensible && wfx.ValidBitsPerSample != 0 {
+		bps = int(wfx.ValidBitsPerSample)
+	}
+	if bps < 4 || bps > 24 || wfx.BitsPerSample%8 != 0 {
+		return 0, fmt.Errorf("cannot encode %d-bit audio as FLAC", bps)
+	}
+	if wfx.Channels == 0 || wfx.Channels > 8 {
+		return 0, fmt.Errorf("FLAC holds 1 to 8 channels, not %d", wfx.Channels)
+	}
+	if wfx.SampleRate == 0 || wfx.SampleRate >= 1<<20 {
+		return 0, fmt.Errorf("cannot encode a sample rate of %d Hz as FLAC", wfx.SampleRate)
+	}
+	return bps, nil
+}
+
+// encodeFLAC compresses WAV sample data into a complete FLAC stream.
+func encodeFLAC(audioData []byte, wfx *WaveFormat, level int) ([]byte, error) {
+	if level < 0 || level > maxFLACCompression {
+		return nil, fmt.Errorf("invalid FLAC compression level %d (use 0 to %d)", level, maxFLACCompression)
+	}
+	bps, err := flacBitsPerSample(wfx)
+	if err != nil {
+		return nil, err
+	}
+	settings := flacLevels[level]
+	channels := int(wfx.Channels)
+	pcm := pcmToInt32(audioData, wfx, bps)
+	frames := len(pcm) / channels
+
+	var out bytes.Buffer
+	out.WriteString(flacMagic)
+	streamInfoOffset := out.Len() + 4
+	out.Write([]byte{0x80 | flacBlockStreamInfo, 0, 0, flacStreamInfoSize})
+	out.Write(make([]byte, flacStreamInfoSize))
+
+	minFrame, maxFrame := math.MaxInt, 0
+	block := make([][]int32, channels)
+	for c := range block {
+		block[c] = make([]int32, settings.blockSize)
+	}
+	for frame, start := 0, 0; start < frames; frame, start = frame+1, start+settings.blockSize {
+		n := min(settings.blockSize, frames-start)
+		for c := range block {
+			block[c] = block[c][:n]
+			for i := range block[c] {
+				block[c][i] = pcm[(start+i)*channels+c]
+			}
+		}
+		encoded := encodeFLACFrame(block, frame, bps, wfx.SampleRate, settings)
+		minFrame = min(minFrame, len(encoded))
+		maxFrame = max(maxFrame, len(encoded))
+		out.Write(encoded)
+	}
+	if frames == 0 {
+		minFrame = 0
+	}
+
+	// Fill in the stream info now the frame sizes are known
+	var w bitWriter
+	w.writeBits(uint64(settings.blockSize), 16)
+	w.writeBits(uint64(settings.blockSize), 16)
+	w.writeBits(uint64(minFrame), 24)
+	w.writeBits(uint64(maxFrame), 24)
+	w.writeBits(uint64(wfx.SampleRate), 20)
+	w.writeBits(uint64(channels-1), 3)
+	w.writeBits(uint64(bps-1), 5)
+	w.writeBits(uint64(frames), 36)
+	sum := flacMD5(pcm, bps)
+	info := append(w.bytes(), sum[:]...)
+	encoded := out.Bytes()
+	copy(encoded[streamInfoOffset:], info)
+	return encoded, nil
+}
+
+// pcmToInt32 unpacks interleaved WAV sample data into signed integers at the
+// given resolution, dropping the padding bits of left-justified samples.
+func pcmToInt32(data []byte, wfx *WaveFormat, bps int) []int32 {
+	bytesPerSample := int(wfx.BitsPerSample) / 8
+	shift := int(wfx.BitsPerSample) - bps
+	pcm := make([]int32, len(data)/bytesPerSample)
+	for i := range pcm {
+		b := data[i*bytesPerSample:]
+		var v int32
+		switch bytesPerSample {
+		case 1:
+			v = int32(b[0]) - 128
+		case 2:
+			v = int32(int16(binary.LittleEndian.Uint16(b)))
+		case 3:
+			v = int32(uint32(b[0])<<8|uint32(b[1])<<16|uint32(b[2])<<24) >> 8
+		case 4:
+			v = int32(binary.LittleEndian.Uint32(b))
+		}
+		pcm[i] = v >> shift
+	}
+	return pcm
+}
+
+// int32ToPCM packs signed samples at the given resolution into WAV sample
+// data of the given container size, left-justified.
+func int32ToPCM(pcm []int32, bps, containerBits int) []byte {
+	bytesPerSample := containerBits / 8
+	shift := containerBits - bps
+	data := make([]byte, len(pcm)*bytesPerSample)
+	for i, s := range pcm {
+		v := s << shift
+		b := data[i*bytesPerSample:]
+		switch bytesPerSample {
+		case 1:
+			b[0] = byte(v + 128)
+		case 2:
+			binary.LittleEndian.PutUint16(b, uint16(v))
+		case 3:
+			b[0], b[1], b[2] = byte(v), byte(v>>8), byte(v>>16)
+		case 4:
+			binary.LittleEndian.PutUint32(b, uint32(v))
+		}
+	}
+	return data
+}
+
+// flacMD5 is the signature of the unencoded audio that STREAMINFO carries:
+// interleaved little-endian samples in the fewest whole bytes.
+func flacMD5(pcm []int32, bps int) [16]byte {
+	bytesPerSample := (bps + 7) / 8
+	data := make([]byte, len(pcm)*bytesPerSample)
+	for i, s := range pcm {
+		for j := 0; j < bytesPerSample; j++ {
+			data[i*bytesPerSample+j] = byte(s >> (8 * j))
+		}
+	}
+	return md5.Sum(data)
+}
+
+// encodeFLACFrame codes one block of per-channel samples.
+func encodeFLACFrame(block [][]int32, frameNumber, bps int, sampleRate uint32, settings flacLevel) []byte {
+	assignment := len(block) - 1
+	subframes := make([]*flacSubframe, len(block))
+	for c, samples := range block {
+		subframes[c] = encodeFLACSubframe(samples, bps, settings)
+	}
+
+	if len(block) == 2 && settings.stereo {
+		left, right := block[0], block[1]
+		mid := make([]int32, len(left))
+		side := make([]int32, len(left))
+		for i := range left {
+			mid[i] = int32((int64(left[i]) + int64(right[i])) >> 1)
+			side[i] = left[i] - right[i]
+		}
+		midFrame := encodeFLACSubframe(mid, bps, settings)
+		sideFrame := encodeFLACSubframe(side, bps+1, settings)
+
+		best := subframes[0].bits + subframes[1].bits
+		if size := subframes[0].bits + sideFrame.bits; size < best {
+			best, assignment = size, flacLeftSide
+		}
+		if size := sideFrame.bits + subframes[1].bits; size < best {
+			best, assignment = size, flacSideRight
+		}
+		if size := midFrame.bits + sideFrame.bits; size < best {
+			assignment = flacMidSide
+		}
+		switch assignment {
+		case flacLeftSide:
+			subframes[1] = sideFrame
+		case flacSideRight:
+			subframes[0] = sideFrame
+		case flacMidSide:
+			subframes[0], subframes[1] = midFrame, sideFrame
+		}
+	}
+
+	var w bitWriter
+	w.writeBits(0xFFF8, 16) // sync code, fixed block size
+	blockSizeCode, blockSizeExtra := flacBlockSizeCode(len(block[0]))
+	rateCode := flacSampleRateCode(sampleRate)
+	w.writeBits(uint64(blockSizeCode), 4)
+	w.writeBits(uint64(rateCode), 4)
+	w.writeBits(uint64(assignment), 4)
+	w.writeBits(uint64(flacSampleSizeCode(bps)), 3)
+	w.writeBits(0, 1)
+	for _, b := range flacCodedNumber(uint64(frameNumber)) {
+		w.writeBits(uint64(b), 8)
+	}
+	if blockSizeExtra > 0 {
+		w.writeBits(uint64(len(block[0])-1), blockSizeExtra)
+	}
+	w.writeBits(uint64(crc8(w.bytes())), 8)
+
+	for _, sub := range subframes {
+		sub.write(&w)
+	}
+	w.align()
+	frame := w.bytes()
+	crc := crc16(frame)
+	return append(frame, byte(crc>>8), byte(crc))
+}
+
+// flacBlockSizeCode returns the header code for a block size and how many
+// bits of explicit size follow the header, if any.
+func flacBlockSizeCode(size int) (code int, extraBits uint) {
+	switch size {
+	case 192:
+		return 1, 0
+	case 576, 1152, 2304, 4608:
+		return 2 + bits.TrailingZeros(uint(size/576)), 0
+	case 256, 512, 1024, 2048, 4096, 8192, 16384, 32768:
+		return 8 + bits.TrailingZeros(uint(size/256)), 0
+	}
+	if size <= 256 {
+		return 6, 8
+	}
+	return 7, 16
+}
+
+// flacSampleRates are the rates the frame header can name directly.
+var flacSampleRates = map[uint32]int{
+	88200: 1, 176400: 2, 192000: 3, 8000: 4, 16000: 5, 22050: 6,
+	24000: 7, 32000: 8, 44100: 9, 48000: 10, 96000: 11,
+}
+
+// flacSampleRateCode names the rate in the frame header, falling back to
+// the STREAMINFO rate for uncommon ones.
+func flacSampleRateCode(rate uint32) int {
+	return flacSampleRates[rate]
+}
+
+var flacSampleSizes = map[int]int{8: 1, 12: 2, 16: 4, 20: 5, 24: 6, 32: 7}
+
+func flacSampleSizeCode(bps int) int {
+	return flacSampleSizes[bps]
+}
+
+// flacCodedNumber encodes a frame number the way UTF-8 encodes a code
+// point, extended to 36 bits.
+func flacCodedNumber(v uint64) []byte {
+	if v < 0x80 {
+		return []byte{byte(v)}
+	}
+	var tail []byte
+	limit := uint64(0x40) // payload bits that fit in the first byte, halving as tail bytes are added
+	for v >= limit {
+		tail = append([]byte{0x80 | byte(v&0x3F)}, tail...)
+		v >>= 6
+		limit >>= 1
+	}
+	lead := byte(0xFF<<(7-len(tail))) | byte(v)
+	return append([]byte{lead}, tail...)
+}
+
+// flacSubframe is one channel of a frame, encoded and ready to write.
+type flacSubframe struct {
+	kind      int
+	order     int
+	bps       int // after removing wasted bits
+	wasted    int
+	samples   []int32 // warmup samples, or every sample when verbatim
+	coefs     []int32
+	precision int
+	shift     int
+	residual  flacResidual
+	bits      int // total size in bits
+}
+
+// encodeFLACSubframe picks the smallest coding for one channel.
+func encodeFLACSubframe(samples []int32, bps int, settings flacLevel) *flacSubframe {
+	const headerBits = 8
+
+	constant := true
+	var union int32
+	for _, s := range samples {
+		constant = constant && s == samples[0]
+		union |= s
+	}
+	if constant {
+		return &flacSubframe{kind: flacSubframeConstant, bps: bps, samples: samples[:1], bits: headerBits + bps}
+	}
+
+	// Low bits that are zero throughout are stripped and restored by the
+	// decoder, as with 16-bit audio stored in a 24-bit container
+	wasted := bits.TrailingZeros32(uint32(union))
+	if wasted > 0 {
+		shifted := make([]int32, len(samples))
+		for i, s := range samples {
+			shifted[i] = s >> wasted
+		}
+		samples = shifted
+	}
+	effective := bps - wasted
+	header := headerBits + wasted
+
+	best := &flacSubframe{kind: flacSubframeVerbatim, bps: effective, wasted: wasted, samples: samples, bits: header + len(samples)*effective}
+
+	for order := 0; order <= flacMaxFixedOrder && order < len(samples); order++ {
+		residual, ok := fixedResidual(samples, order)
+		if !ok {
+			continue
+		}
+		coded := encodeResidual(residual, order, len(samples), settings.maxPartitionOrder)
+		size := header + order*effective + coded.bits
+		if size < best.bits {
+			best = &flacSubframe{kind: flacSubframeFixed, order: order, bps: effective, wasted: wasted, samples: samples[:order], residual: coded, bits: size}
+		}
+	}
+
+	maxOrder := min(settings.maxLPCOrder, len(samples)-1)
+	if maxOrder < 1 {
+		return best
+	}
+	lpc, errs := lpcCoefficients(samples, maxOrder)
+	precision := lpcPrecision(len(samples), effective)
+	orders := []int{estimateLPCOrder(errs, len(samples), precision+effective)}
+	if settings.searchLPCOrders {
+		orders = orders[:0]
+		for order := 1; order <= maxOrder; order++ {
+			orders = append(orders, order)
+		}
+	}
+	for _, order := range orders {
+		coefs, shift, ok := quantizeLPC(lpc[order-1], precision)
+		if !ok {
+			continue
+		}
+		residual, ok := lpcResidual(samples, coefs, shift)
+		if !ok {
+			continue
+		}
+		coded := encodeResidual(residual, order, len(samples), settings.maxPartitionOrder)
+		size := header + order*effective + 4 + 5 + order*precision + coded.bits
+		if size < best.bits {
+			best = &flacSubframe{kind: flacSubframeLPC, order: order, bps: effective, wasted: wasted, samples: samples[:order], coefs: coefs, precision: precision, shift: shift, residual: coded, bits: size}
+		}
+	}
+	return best
+}
+
+func (s *flacSubframe) write(w *bitWriter) {
+	w.writeBits(0, 1)
+	switch s.kind {
+	case flacSubframeConstant, flacSubframeVerbatim:
+		w.writeBits(uint64(s.kind), 6)
+	case flacSubframeFixed:
+		w.writeBits(uint64(flacSubframeFixed+s.order), 6)
+	case flacSubframeLPC:
+		w.writeBits(uint64(flacSubframeLPC+s.order-1), 6)
+	}
+	if s.wasted > 0 {
+		w.writeBits(1, 1)
+		w.writeUnary(uint32(s.wasted - 1))
+	} else {
+		w.writeBits(0, 1)
+	}
+
+	for _, v := range s.samples {
+		w.writeSigned(int64(v), uint(s.bps))
+	}
+	if s.kind == flacSubframeLPC {
+		w.writeBits(uint64(s.precision-1), 4)
+		w.writeSigned(int64(s.shift), 5)
+		for _, c := range s.coefs {
+			w.writeSigned(int64(c), uint(s.precision))
+		}
+	}
+	if s.kind == flacSubframeFixed || s.kind == flacSubframeLPC {
+		s.residual.write(w)
+	}
+}
+
+// fixedCoefficients are the polynomial predictors of orders 0 to 4.
+var fixedCoefficients = [flacMaxFixedOrder + 1][]int64{
+	{},
+	{1},
+	{2, -1},
+	{3, -3, 1},
+	{4, -6, 4, -1},
+}
+
+// fixedResidual returns the prediction error of a fixed polynomial, or false
+// if it overflows 32 bits.
+func fixedResidual(samples []int32, order int) ([]int32, bool) {
+	coefs := fixedCoefficients[order]
+	residual := make([]int32, len(samples)-order)
+	for i := order; i < len(samples); i++ {
+		var prediction int64
+		for j, c := range coefs {
+			prediction += c * int64(samples[i-j-1])
+		}
+		r := int64(samples[i]) - prediction
+		if r < math.MinInt32 || r > math.MaxInt32 {
+			return nil, false
+		}
+		residual[i-order] = int32(r)
+	}
+	return residual, true
+}
+
+// lpcResidual returns the prediction error of quantized LPC coefficients, or
+// false if it overflows 32 bits.
+func lpcResidual(samples []int32, coefs []int32, shift int) ([]int32, bool) {
+	order := len(coefs)
+	residual := make([]int32, len(samples)-order)
+	for i := order; i < len(samples); i++ {
+		var sum int64
+		for j, c := range coefs {
+			sum += int64(c) * int64(samples[i-j-1])
+		}
+		r := int64(samples[i]) - sum>>shift
+		if r < math.MinInt32 || r > math.MaxInt32 {
+			return nil, false
+		}
+		residual[i-order] = int32(r)
+	}
+	return residual, true
+}
+
+// lpcCoefficients windows the samples, takes their autocorrelation and runs
+// Levinson-Durbin recursion, returning the predictor for every order up to
+// maxOrder along with its prediction error.
+func lpcCoefficients(samples []int32, maxOrder int) ([][]float64, []float64) {
+	n := len(samples)
+	windowed := make([]float64, n)
+	for i, s := range samples {
+		windowed[i] = float64(s) * tukey(i, n, 0.5)
+	}
+	autoc := make([]float64, maxOrder+1)
+	for lag := range autoc {
+		var sum float64
+		for i := lag; i < n; i++ {
+			sum += windowed[i] * windowed[i-lag]
+		}
+		autoc[lag] = sum
+	}
+
+	lpc := make([][]float64, maxOrder)
+	errs := make([]float64, maxOrder)
+	current := make([]float64, maxOrder)
+	err := autoc[0]
+	for order := 0; order < maxOrder; order++ {
+		if err > 0 {
+			// Reflection coefficient for this order, then update the
+			// lower-order coefficients from their previous values
+			k := autoc[order+1]
+			for j := 0; j < order; j++ {
+				k -= current[j] * autoc[order-j]
+			}
+			k /= err
+
+			previous := append([]float64(nil), current[:order]...)
+			current[order] = k
+			for j := 0; j < order; j++ {
+				current[j] = previous[j] - k*previous[order-1-j]
+			}
+			err *= 1 - k*k
+		}
+		// Once the signal is predicted perfectly, higher orders add nothing
+		lpc[order] = append([]float64(nil), current[:order+1]...)
+		errs[order] = max(err, 0)
+	}
+	return lpc, errs
+}
+
+func tukey(i, n int, p float64) float64 {
+	taper := int(p / 2 * float64(n))
+	switch {
+	case taper == 0:
+		return 1
+	case i < taper:
+		return 0.5 - 0.5*math.Cos(math.Pi*float64(i)/float64(taper))
+	case i >= n-taper:
+		return 0.5 - 0.5*math.Cos(math.Pi*float64(n-1-i)/float64(taper))
+	default:
+		return 1
+	}
+}
+
+// estimateLPCOrder guesses the cheapest LPC order from the prediction error
+// of each, counting the residual and the coefficients.
+func estimateLPCOrder(errs []float64, n, bitsPerCoefficient int) int {
+	best, bestBits := 1, math.Inf(1)
+	for i, err := range errs {
+		order := i + 1
+		perSample := 0.0
+		if err > 0 {
+			perSample = math.Max(0, 0.5*math.Log2(0.5*err/float64(n)))
+		}
+		total := perSample*float64(n-order) + float64(order*bitsPerCoefficient)
+		if total < bestBits {
+			best, bestBits = order, total
+		}
+	}
+	return best
+}
+
+// lpcPrecision picks the coefficient resolution, finer for longer blocks
+// and deeper samples as the reference encoder does.
+func lpcPrecision(blockSize, bps int) int {
+	precision := 13
+	switch {
+	case blockSize <= 192:
+		precision = 7
+	case blockSize <= 384:
+		precision = 8
+	case blockSize <= 576:
+		precision = 9
+	case blockSize <= 1152:
+		precision = 10
+	case blockSize <= 2304:
+		precision = 11
+	case blockSize <= 4608:
+		precision = 12
+	}
+	if bps > 16 {
+		precision += 2
+	}
+	return min(precision, 15)
+}
+
+// quantizeLPC rounds coefficients to signed integers of the given precision
+// scaled by 2^shift, carrying each rounding error into the next.
+func quantizeLPC(lpc []float64, precision int) ([]int32, int, bool) {
+	var cmax float64
+	for _, c := range lpc {
+		cmax = math.Max(cmax, math.Abs(c))
+	}
+	if cmax == 0 {
+		return nil, 0, false
+	}
+	_, exp := math.Frexp(cmax)
+	shift := precision - 1 - exp
+	if shift < 0 {
+		return nil, 0, false
+	}
+	shift = min(shift, 15)
+
+	qmax := int32(1)<<(precision-1) - 1
+	qmin := -qmax - 1
+	coefs := make([]int32, len(lpc))
+	var carry float64
+	for i, c := range lpc {
+		scaled := c*float64(int64(1)<<shift) + carry
+		q := int32(math.Round(scaled))
+		q = max(qmin, min(qmax, q))
+		carry = scaled - float64(q)
+		coefs[i] = q
+	}
+	return coefs, shift, true
+}
+
+// flacResidual is a partitioned Rice coding of prediction errors.
+type flacResidual struct {
+	method         int
+	partitionOrder int
+	predictorOrder int // the first partition is short by this many values
+	params         []int
+	values         []uint32 // zig-zag folded residual
+	bits           int
+}
+
+// encodeResidual finds the partition order and Rice parameters that code
+// the residual in the fewest bits.
+func encodeResidual(residual []int32, order, blockSize, maxPartitionOrder int) flacResidual {
+	values := make([]uint32, len(residual))
+	for i, r := range residual {
+		values[i] = uint32(r<<1) ^ uint32(r>>31)
+	}
+
+	best := flacResidual{bits: math.MaxInt}
+	for p := 0; p <= maxPartitionOrder; p++ {
+		partitions := 1 << p
+		if blockSize%partitions != 0 || blockSize/partitions <= order {
+			break
+		}
+		params := make([]int, partitions)
+		total := 2 + 4
+		method := flacRice
+		start := 0
+		for i := range params {
+			count := blockSize / partitions
+			if i == 0 {
+				count -= order
+			}
+			k, size := bestRiceParameter(values[start : start+count])
+			params[i] = k
+			total += size
+			if k > 14 {
+				method = flacRice2
+			}
+			start += count
+		}
+		paramBits := 4
+		if method == flacRice2 {
+			paramBits = 5
+		}
+		total += partitions * paramBits
+		if total < best.bits {
+			best = flacResidual{method: method, partitionOrder: p, predictorOrder: order, params: params, values: values, bits: total}
+		}
+	}
+	return best
+}
+
+// bestRiceParameter returns the Rice parameter that codes the values in the
+// fewest bits, and that size.
+func bestRiceParameter(values []uint32) (int, int) {
+	var sum uint64
+	for _, v := range values {
+		sum += uint64(v)
+	}
+	guess := 0
+	if len(values) > 0 && sum > uint64(len(values)) {
+		guess = bits.Len64(sum/uint64(len(values))) - 1
+	}
+
+	bestK, bestSize := 0, math.MaxInt
+	for k := max(0, guess-1); k <= min(30, guess+1); k++ {
+		size := len(values) * (k + 1)
+		for _, v := range values {
+			size += int(v >> k)
+		}
+		if size < bestSize {
+			bestK, bestSize = k, size
+		}
+	}
+	return bestK, bestSize
+}
+
+func (r *flacResidual) write(w *bitWriter) {
+	w.writeBits(uint64(r.method), 2)
+	w.writeBits(uint64(r.partitionOrder), 4)
+	paramBits := uint(4)
+	if r.method == flacRice2 {
+		paramBits = 5
+	}
+	perPartition := (len(r.values) + r.predictorOrder) / len(r.params)
+	start := 0
+	for i, k := range r.params {
+		count := perPartition
+		if i == 0 {
+			count -= r.predictorOrder
+		}
+		w.writeBits(uint64(k), paramBits)
+		for _, v := range r.values[start : start+count] {
+			w.writeUnary(v >> k)
+			w.writeBits(uint64(v)&(1<<k-1), uint(k))
+		}
+		start += count
+	}
+}
+
+// decodeFLAC decodes a complete FLAC stream into a WAV format and sample
+// data. Samples whose resolution is not a whole number of bytes are stored
+// left-justified, with the valid bits recorded in the format.
+func decodeFLAC(data []byte) (*WaveFormat, []byte, error) {
+	if len(data) < 4 || string(data[:4]) != flacMagic {
+		return nil, nil, errors.New("not a FLAC file")
+	}
+
+	blocks, pos, err := splitFLACMetadata(data)
+	if err != nil {
+		return nil, nil, err
+	}
+	var info *flacStreamInfo
+	for _, block := range blocks {
+		if block.blockType == flacBlockStreamInfo {
+			if info, err = parseFLACStreamInfo(block.body); err != nil {
+				return nil, nil, err
+			}
+		}
+	}
+	if info == nil {
+		return nil, nil, errors.New("missing STREAMINFO")
+	}
+
+	// The header's sample count is only trusted as far as the file could
+	// hold it uncompressed; past that the slice grows as frames decode
+	capacity := uint64(len(data)) * 8 / uint64(info.bps)
+	if info.totalSamples > 0 {
+		capacity = min(capacity, info.totalSamples*uint64(info.channels))
+	}
+	pcm := make([]int32, 0, capacity)
+	r := &bitReader{data: data, pos: pos * 8}
+	for r.pos/8+2 <= len(data) {
+		if info.totalSamples > 0 && uint64(len(pcm)/info.channels) >= info.totalSamples {
+			break // anything after the last frame, such as an ID3v1 tag, is ignored
+		}
+		var err error
+		if pcm, err = decodeFLACFrame(r, info, pcm); err != nil {
+			return nil, nil, fmt.Errorf("frame at byte %d: %v", r.pos/8, err)
+		}
+	}
+	if info.totalSamples > 0 && uint64(len(pcm)/info.channels) != info.totalSamples {
+		return nil, nil, fmt.Errorf("expected %d samples per channel, decoded %d", info.totalSamples, len(pcm)/info.channels)
+	}
+	if info.md5 != [16]byte{} && flacMD5(pcm, info.bps) != info.md5 {
+		return nil, nil, errors.New("MD5 signature mismatch, the audio is corrupt")
+	}
+
+	containerBits := (info.bps + 7) / 8 * 8
+	wfx := newPCMFormat(uint16(info.channels), info.sampleRate, uint16(containerBits))
+	if containerBits != info.bps {
+		wfx.FormatTag = waveFormatExtensible
+		wfx.ValidBitsPerSample = uint16(info.bps)
+		wfx.ChannelMask = defaultChannelMask(wfx.Channels)
+		wfx.SubFormat = subFormatPCM
+	}
+	return wfx, int32ToPCM(pcm, info.bps, containerBits), nil
+}
+
+// flacBlock is one metadata block of a FLAC stream.
+type flacBlock struct {
+	blockType byte
+	body      []byte
+}
+
+// splitFLACMetadata returns the metadata blocks of a FLAC stream and the
+// offset of its first frame.
+func splitFLACMetadata(data []byte) ([]flacBlock, int, error) {
+	if len(data) < 4 || string(data[:4]) != flacMagic {
+		return nil, 0, errors.New("not a FLAC file")
+	}
+	var blocks []flacBlock
+	pos := 4
+	for last := false; !last; {
+		if pos+4 > len(data) {
+			return nil, 0, errors.New("truncated metadata")
+		}
+		last = data[pos]&0x80 != 0
+		length := int(data[pos+1])<<16 | int(data[pos+2])<<8 | int(data[pos+3])
+		if pos+4+length > len(data) {
+			return nil, 0, errors.New("truncated metadata")
+		}
+		blocks = append(blocks, flacBlock{blockType: data[pos] & 0x7F, body: data[pos+4 : pos+4+length]})
+		pos += 4 + length
+	}
+	return blocks, pos, nil
+}
+
+type flacStreamInfo struct {
+	sampleRate   uint32
+	channels     int
+	bps          int
+	totalSamples uint64
+	md5          [16]byte
+}
+
+func parseFLACStreamInfo(body []byte) (*flacStreamInfo, error) {
+	if len(body) < flacStreamInfoSize {
+		return nil, errors.New("STREAMINFO is too short")
+	}
+	r := &bitReader{data: body}
+	r.readBits(16 + 16 + 24 + 24) // block and frame size bounds
+	info := &flacStreamInfo{
+		sampleRate:   uint32(r.readBits(20)),
+		channels:     int(r.readBits(3)) + 1,
+		bps:          int(r.readBits(5)) + 1,
+		totalSamples: r.readBits(36),
+	}
+	copy(info.md5[:], body[18:34])
+	if info.sampleRate == 0 {
+		return nil, errors.New("invalid sample rate 0")
+	}
+	if info.bps < 4 {
+		return nil, fmt.Errorf("unsupported bit depth %d", info.bps)
+	}
+	return info, nil
+}
+
+// decodeFLACFrame decodes one frame and appends its interleaved samples.
+func decodeFLACFrame(r *bitReader, info *flacStreamInfo, pcm []int32) ([]int32, error) {
+	start := r.pos / 8
+	if sync := r.readBits(15); sync != 0xFFF8>>1 {
+		return nil, errors.New("lost frame sync")
+	}
+	r.readBits(1) // blocking strategy; frame and sample numbers are not needed
+	blockSizeCode := int(r.readBits(4))
+	rateCode := int(r.readBits(4))
+	assignment := int(r.readBits(4))
+	sizeCode := int(r.readBits(3))
+	r.readBits(1)
+	if err := r.skipCodedNumber(); err != nil {
+		return nil, err
+	}
+
+	var blockSize int
+	switch {
+	case blockSizeCode == 0:
+		return nil, errors.New("reserved block size")
+	case blockSizeCode == 1:
+		blockSize = 192
+	case blockSizeCode <= 5:
+		blockSize = 576 << (blockSizeCode - 2)
+	case blockSizeCode == 6:
+		blockSize = int(r.readBits(8)) + 1
+	case blockSizeCode == 7:
+		blockSize = int(r.readBits(16)) + 1
+	default:
+		blockSize = 256 << (blockSizeCode - 8)
+	}
+	switch rateCode {
+	case 12:
+		r.readBits(8)
+	case 13, 14:
+		r.readBits(16)
+	case 15:
+		return nil, errors.New("invalid sample rate code")
+	}
+
+	bps := info.bps
+	if sizeCode != 0 {
+		for size, code := range flacSampleSizes {
+			if code == sizeCode {
+				bps = size
+			}
+		}
+	}
+	if bps != info.bps {
+		return nil, fmt.Errorf("frame is %d-bit but the stream is %d-bit", bps, info.bps)
+	}
+
+	channels := assignment + 1
+	if assignment >= flacLeftSide {
+		if assignment > flacMidSide {
+			return nil, errors.New("reserved channel assignment")
+		}
+		channels = 2
+	}
+	if channels != info.channels {
+		return nil, fmt.Errorf("frame has %d channels but the stream has %d", channels, info.channels)
+	}
+
+	header := r.data[start : r.pos/8]
+	if crc := uint8(r.readBits(8)); r.err == nil && crc != crc8(header) {
+		return nil, errors.New("header CRC mismatch")
+	}
+	if r.err != nil {
+		return nil, r.err
+	}
+
+	decoded := make([][]int32, channels)
+	for c := range decoded {
+		subframeBPS := bps
+		if (assignment == flacLeftSide || assignment == flacMidSide) && c == 1 || assignment == flacSideRight && c == 0 {
+			subframeBPS++ // the side channel needs an extra bit
+		}
+		var err error
+		if decoded[c], err = decodeFLACSubframe(r, blockSize, subframeBPS); err != nil {
+			return nil, fmt.Errorf("channel %d: %v", c, err)
+		}
+	}
+
+	r.align()
+	frame := r.data[start : r.pos/8]
+	if crc := uint16(r.readBits(16)); r.err == nil && crc != crc16(frame) {
+		return nil, errors.New("frame CRC mismatch")
+	}
+	if r.err != nil {
+		return nil, r.err
+	}
+
+	switch assignment {
+	case flacLeftSide:
+		for i, side := range decoded[1] {
+			decoded[1][i] = decoded[0][i] - side
+		}
+	case flacSideRight:
+		for i, side := range decoded[0] {
+			decoded[0][i] = side + decoded[1][i]
+		}
+	case flacMidSide:
+		for i, side := range decoded[1] {
+			mid := int64(decoded[0][i])<<1 | int64(side)&1
+			decoded[0][i] = int32((mid + int64(side)) >> 1)
+			decoded[1][i] = int32((mid - int64(side)) >> 1)
+		}
+	}
+
+	for i := 0; i < blockSize; i++ {
+		for c := range decoded {
+			pcm = append(pcm, decoded[c][i])
+		}
+	}
+	return pcm, nil
+}
+
+func decodeFLACSubframe(r *bitReader, blockSize, bps int) ([]int32, error) {
+	if r.readBits(1) != 0 {
+		return nil, errors.New("invalid subframe padding")
+	}
+	kind := int(r.readBits(6))
+	wasted := 0
+	if r.readBits(1) == 1 {
+		wasted = int(r.readUnary()) + 1
+	}
+	if wasted >= bps {
+		return nil, fmt.Errorf("%d wasted bits in a %d-bit subframe", wasted, bps)
+	}
+	bps -= wasted
+
+	samples := make([]int32, blockSize)
+	switch {
+	case kind == flacSubframeConstant:
+		v := int32(r.readSigned(uint(bps)))
+		for i := range samples {
+			samples[i] = v
+		}
+	case kind == flacSubframeVerbatim:
+		for i := range samples {
+			samples[i] = int32(r.readSigned(uint(bps)))
+		}
+	case kind >= flacSubframeFixed && kind <= flacSubframeFixed+flacMaxFixedOrder:
+		order := kind - flacSubframeFixed
+		if order > blockSize {
+			return nil, errors.New("predictor order exceeds the block size")
+		}
+		for i := 0; i < order; i++ {
+			samples[i] = int32(r.readSigned(uint(bps)))
+		}
+		if err := decodeResidual(r, samples, order); err != nil {
+			return nil, err
+		}
+		coefs := fixedCoefficients[order]
+		for i := order; i < blockSize; i++ {
+			var prediction int64
+			for j, c := range coefs {
+				prediction += c * int64(samples[i-j-1])
+			}
+			samples[i] = int32(int64(samples[i]) + prediction)
+		}
+	case kind >= flacSubframeLPC:
+		order := kind - flacSubframeLPC + 1
+		if order > blockSize {
+			return nil, errors.New("predictor order exceeds the block size")
+		}
+		for i := 0; i < order; i++ {
+			samples[i] = int32(r.readSigned(uint(bps)))
+		}
+		precision := int(r.readBits(4)) + 1
+		if precision == 16 {
+			return nil, errors.New("invalid LPC precision")
+		}
+		shift := int(r.readSigned(5))
+		if shift < 0 {
+			return nil, errors.New("negative LPC shift")
+		}
+		coefs := make([]int64, order)
+		for i := range coefs {
+			coefs[i] = r.readSigned(uint(precision))
+		}
+		if err := decodeResidual(r, samples, order); err != nil {
+			return nil, err
+		}
+		for i := order; i < blockSize; i++ {
+			var sum int64
+			for j, c := range coefs {
+				sum += c * int64(samples[i-j-1])
+			}
+			samples[i] = int32(int64(samples[i]) + sum>>shift)
+		}
+	default:
+		return nil, fmt.Errorf("reserved subframe type %d", kind)
+	}
+	if r.err != nil {
+		return nil, r.err
+	}
+
+	if wasted > 0 {
+		for i := range samples {
+			samples[i] <<= wasted
+		}
+	}
+	return samples, nil
+}
+
+// decodeResidual reads the partitioned Rice residual into samples[order:].
+func decodeResidual(r *bitReader, samples []int32, order int) error {
+	method := int(r.readBits(2))
+	if method > flacRice2 {
+		return errors.New("reserved residual coding method")
+	}
+	paramBits, escape := uint(4), uint64(flacRiceEscape)
+	if method == flacRice2 {
+		paramBits, escape = 5, flacRice2Esc
+	}
+	partitionOrder := int(r.readBits(4))
+	partitions := 1 << partitionOrder
+	if len(samples)%partitions != 0 || len(samples)/partitions < order {
+		return errors.New("invalid residual partitioning")
+	}
+
+	i := order
+	for p := 0; p < partitions; p++ {
+		end := (p + 1) * len(samples) / partitions
+		k := r.readBits(paramBits)
+		if k == escape {
+			width := uint(r.readBits(5))
+			for ; i < end; i++ {
+				samples[i] = int32(r.readSigned(width))
+			}
+			continue
+		}
+		for ; i < end; i++ {
+			u := r.readUnary()<<k | uint32(r.readBits(uint(k)))
+			samples[i] = int32(u>>1) ^ -int32(u&1)
+		}
+		if r.err != nil {
+			return r.err
+		}
+	}
+	return r.err
+}
+
+// bitWriter packs values most significant bit first.
+type bitWriter struct {
+	buf []byte
+	acc uint64
+	n   uint // pending bits in acc, always fewer than 8 between calls
+}
+
+func (w *bitWriter) writeBits(v uint64, n uint) {
+	for n > 32 {
+		w.writeBits(v>>32, n-32)
+		v, n = v&0xFFFFFFFF, 32
+	}
+	w.acc = w.acc<<n | v&(1<<n-1)
+	w.n += n
+	for w.n >= 8 {
+		w.n -= 8
+		w.buf = append(w.buf, byte(w.acc>>w.n))
+	}
+	w.acc &= 1<<w.n - 1
+}
+
+func (w *bitWriter) writeSigned(v int64, n uint) {
+	w.writeBits(uint64(v), n)
+}
+
+// writeUnary writes q zero bits followed by a one.
+func (w *bitWriter) writeUnary(q uint32) {
+	for q >= 32 {
+		w.writeBits(0, 32)
+		q -= 32
+	}
+	w.writeBits(1, uint(q)+1)
+}
+
+// align pads with zero bits to the next byte boundary.
+func (w *bitWriter) align() {
+	if w.n > 0 {
+		w.writeBits(0, 8-w.n)
+	}
+}
+
+// bytes returns the whole bytes written so far.
+func (w *bitWriter) bytes() []byte {
+	return w.buf
+}
+
+// bitReader reads values most significant bit first. Reading past the end
+// sets err and yields zeros, so callers can check once per unit of work.
+type bitReader struct {
+	data []byte
+	pos  int // in bits
+	err  error
+}
+
+func (r *bitReader) readBits(n uint) uint64 {
+	var v uint64
+	for n > 0 {
+		if r.pos/8 >= len(r.data) {
+			r.err = io.ErrUnexpectedEOF
+			return 0
+		}
+		avail := 8 - uint(r.pos%8)
+		take := min(n, avail)
+		chunk := uint64(r.data[r.pos/8]) >> (avail - take) & (1<<take - 1)
+		v = v<<take | chunk
+		r.pos += int(take)
+		n -= take
+	}
+	return v
+}
+
+// readSigned reads an n-bit two's complement value.
+func (r *bitReader) readSigned(n uint) int64 {
+	if n == 0 {
+		return 0
+	}
+	v := r.readBits(n)
+	return int64(v<<(64-n)) >> (64 - n)
+}
+
+// readUnary counts zero bits up to the next one bit.
+func (r *bitReader) readUnary() uint32 {
+	var q uint32
+	for {
+		if r.pos/8 >= len(r.data) {
+			r.err = io.ErrUnexpectedEOF
+			return 0
+		}
+		b := r.data[r.pos/8] << (r.pos % 8)
+		if b == 0 {
+			q += uint32(8 - r.pos%8)
+			r.pos += 8 - r.pos%8
+			continue
+		}
+		zeros := bits.LeadingZeros8(b)
+		q += uint32(zeros)
+		r.pos += zeros + 1
+		return q
+	}
+}
+
+func (r *bitReader) align() {
+	r.pos = (r.pos + 7) / 8 * 8
+}
+
+// skipCodedNumber skips a UTF-8 style frame or sample number.
+func (r *bitReader) skipCodedNumber() error {
+	lead := uint8(r.readBits(8))
+	extra := bits.LeadingZeros8(^lead)
+	if extra == 1 || extra > 7 {
+		return errors.New("invalid frame number")
+	}
+	for i := 1; i < extra; i++ {
+		if r.readBits(8)&0xC0 != 0x80 {
+			return errors.New("invalid frame number")
+		}
+	}
+	return r.err
+}
+
+var crc8Table, crc16Table = flacCRCTables()
+
+func flacCRCTables() (t8 [256]uint8, t16 [256]uint16) {
+	for i := range t8 {
+		c8 := uint8(i)
+		c16 := uint16(i) << 8
+		for j := 0; j < 8; j++ {
+			if c8&0x80 != 0 {
+				c8 = c8<<1 ^ 0x07
+			} else {
+				c8 <<= 1
+			}
+			if c16&0x8000 != 0 {
+				c16 = c16<<1 ^ 0x8005
+			} else {
+				c16 <<= 1
+			}
+		}
+		t8[i], t16[i] = c8, c16
+	}
+	return t8, t16
+}
+
+// crc8 is the frame header checksum, polynomial x^8 + x^2 + x + 1.
+func crc8(data []byte) uint8 {
+	var crc uint8
+	for _, b := range data {
+		crc = crc8Table[crc^b]
+	}
+	return crc
+}
+
+// crc16 is the frame checksum, polynomial x^16 + x^15 + x^2 + 1.
+func crc16(data []byte) uint16 {
+	var crc uint16
+	for _, b := range data {
+		crc = crc<<8 ^ crc16Table[byte(crc>>8)^b]
+	}
+	return crc
+}
@@ -0,0 +1,324 @@
+package main
+
+import (
+	"bytes"
+	"math"
+	"math/rand/v2"
+	"path/filepath"
+	"testing"
+)
+
+// testPCM returns interleaved integer samples of a sine per channel with a
+// little noise, at the given resolution.
+func testPCM(frames, channels, bps int, seed uint64) []int32 {
+	rng := rand.New(rand.NewPCG(seed, seed))
+	full := float64(int64(1)<<(bps-1) - 1)
+	pcm := make([]int32, frames*channels)
+	for f := 0; f < frames; f++ {
+		for c := 0; c < channels; c++ {
+			s := 0.6*math.Sin(2*math.Pi*float64(f)*float64(220*(c+1))/44100) + 0.01*(rng.Float64()-0.5)
+			pcm[f*channels+c] = int32(math.Round(s * full))
+		}
+	}
+	return pcm
+}
+
+func TestFLACRoundTrip(t *testing.T) {
+	tests := []struct {
+		name     string
+		channels int
+		bits     int
+		frames   int
+	}{
+		{"mono 16-bit", 1, 16, 10000},
+		{"stereo 16-bit", 2, 16, 20000},
+		{"stereo 24-bit", 2, 24, 9000},
+		{"5.1 24-bit", 6, 24, 5000},
+		{"stereo 8-bit", 2, 8, 3000},
+		{"short block", 2, 16, 7},
+		{"empty", 2, 16, 0},
+	}
+	for _, tt := range tests {
+		wfx := newPCMFormat(uint16(tt.channels), 44100, uint16(tt.bits))
+		original := int32ToPCM(testPCM(tt.frames, tt.channels, tt.bits, 1), tt.bits, tt.bits)
+		for level := 0; level <= maxFLACCompression; level++ {
+			encoded, err := encodeFLAC(original, wfx, level)
+			if err != nil {
+				t.Fatalf("%s level %d: encode failed: %v", tt.name, level, err)
+			}
+			decodedFormat, decoded, err := decodeFLAC(encoded)
+			if err != nil {
+				t.Fatalf("%s level %d: decode failed: %v", tt.name, level, err)
+			}
+			if decodedFormat.Channels != wfx.Channels || decodedFormat.SampleRate != 44100 || decodedFormat.BitsPerSample != wfx.BitsPerSample {
+				t.Errorf("%s level %d: unexpected format %+v", tt.name, level, decodedFormat)
+			}
+			if !bytes.Equal(decoded, original) {
+				t.Errorf("%s level %d: decoded audio differs from the original", tt.name, level)
+			}
+		}
+	}
+}
+
+func TestFLACRoundTrip_Extremes(t *testing.T) {
+	// Full-scale square waves, silence, a DC offset and white noise stress
+	// the side channel, constant subframes and verbatim fallback
+	const frames = 5000
+	rng := rand.New(rand.NewPCG(2, 2))
+	signals := map[string]func(f, c int) int32{
+		"square":  func(f, c int) int32 { return [2]int32{32767, -32768}[(f/50+c)%2] },
+		"silence": func(f, c int) int32 { return 0 },
+		"dc":      func(f, c int) int32 { return -1234 },
+		"noise":   func(f, c int) int32 { return int32(rng.IntN(65536) - 32768) },
+	}
+	wfx := newPCMFormat(2, 48000, 16)
+	for name, signal := range signals {
+		pcm := make([]int32, frames*2)
+		for f := 0; f < frames; f++ {
+			for c := 0; c < 2; c++ {
+				pcm[f*2+c] = signal(f, c)
+			}
+		}
+		original := int32ToPCM(pcm, 16, 16)
+		encoded, err := encodeFLAC(original, wfx, defaultFLACCompression)
+		if err != nil {
+			t.Fatalf("%s: encode failed: %v", name, err)
+		}
+		if _, decoded, err := decodeFLAC(encoded); err != nil || !bytes.Equal(decoded, original) {
+			t.Errorf("%s: round trip failed (err %v)", name, err)
+		}
+	}
+}
+
+func TestFLACCompresses(t *testing.T) {
+	wfx := newPCMFormat(2, 44100, 16)
+	original := int32ToPCM(testPCM(44100, 2, 16, 3), 16, 16)
+
+	fastest, _ := encodeFLAC(original, wfx, 0)
+	best, _ := encodeFLAC(original, wfx, maxFLACCompression)
+	if len(fastest) >= len(original) {
+		t.Errorf("Expected level 0 to compress, got %d bytes from %d", len(fastest), len(original))
+	}
+	if len(best) > len(fastest) {
+		t.Errorf("Expected level %d (%d bytes) to be no larger than level 0 (%d bytes)", maxFLACCompression, len(best), len(fastest))
+	}
+}
+
+func TestFLACValidBits(t *testing.T) {
+	// 20-bit audio in a 24-bit container keeps its resolution
+	wfx := newPCMFormat(2, 48000, 24)
+	wfx.ValidBitsPerSample = 20
+	original := int32ToPCM(testPCM(2000, 2, 20, 4), 20, 24)
+
+	encoded, err := encodeFLAC(original, wfx, defaultFLACCompression)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decodedFormat, decoded, err := decodeFLAC(encoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decodedFormat.BitsPerSample != 24 || decodedFormat.ValidBitsPerSample != 20 {
+		t.Errorf("Expected 20 valid bits in 24, got %d in %d", decodedFormat.ValidBitsPerSample, decodedFormat.BitsPerSample)
+	}
+	if !bytes.Equal(decoded, original) {
+		t.Error("Decoded audio differs from the original")
+	}
+}
+
+func TestEncodeFLAC_RejectsFloat(t *testing.T) {
+	if _, err := encodeFLAC(make([]byte, 8), newFloatFormat(2, 48000), defaultFLACCompression); err == nil {
+		t.Error("Expected float audio to be rejected")
+	}
+	if _, err := encodeFLAC(nil, newPCMFormat(2, 48000, 16), 9); err == nil {
+		t.Error("Expected compression level 9 to be rejected")
+	}
+}
+
+func TestDecodeFLAC_DetectsCorruption(t *testing.T) {
+	wfx := newPCMFormat(1, 44100, 16)
+	encoded, err := encodeFLAC(int32ToPCM(testPCM(8192, 1, 16, 5), 16, 16), wfx, defaultFLACCompression)
+	if err != nil {
+		t.Fatal(err)
+	}
+	corrupt := append([]byte(nil), encoded...)
+	corrupt[len(corrupt)-100] ^= 0x10
+	if _, _, err := decodeFLAC(corrupt); err == nil {
+		t.Error("Expected a flipped bit to be detected")
+	}
+	if _, _, err := decodeFLAC(encoded[:len(encoded)-10]); err == nil {
+		t.Error("Expected a truncated file to be rejected")
+	}
+	if _, _, err := decodeFLAC([]byte("RIFF")); err == nil {
+		t.Error("Expected a non-FLAC file to be rejected")
+	}
+
+	// A sample count of 2^36-1 in STREAMINFO must not be allocated up front
+	huge := append([]byte(nil), encoded...)
+	huge[8+13] |= 0x0F
+	copy(huge[8+14:8+18], []byte{0xFF, 0xFF, 0xFF, 0xFF})
+	if _, _, err := decodeFLAC(huge); err == nil {
+		t.Error("Expected an impossible sample count to be rejected")
+	}
+}
+
+func TestDecodeFLACSubframe_EscapedResidual(t *testing.T) {
+	// The encoder never escapes a partition, so build one by hand: a fixed
+	// order 1 subframe whose residual is stored as raw 5-bit values
+	var w bitWriter
+	w.writeBits(0, 1)
+	w.writeBits(flacSubframeFixed+1, 6)
+	w.writeBits(0, 1)
+	w.writeSigned(100, 16) // warmup
+	w.writeBits(flacRice, 2)
+	w.writeBits(0, 4)
+	w.writeBits(flacRiceEscape, 4)
+	w.writeBits(5, 5)
+	for _, r := range []int64{3, -4, 15} {
+		w.writeSigned(r, 5)
+	}
+	w.align()
+
+	samples, err := decodeFLACSubframe(&bitReader{data: w.bytes()}, 4, 16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []int32{100, 103, 99, 114}
+	for i := range want {
+		if samples[i] != want[i] {
+			t.Fatalf("Expected %v, got %v", want, samples)
+		}
+	}
+}
+
+func TestFLACCodedNumber(t *testing.T) {
+	tests := []struct {
+		n    uint64
+		want []byte
+	}{
+		{0, []byte{0x00}},
+		{0x7F, []byte{0x7F}},
+		{0x80, []byte{0xC2, 0x80}},
+		{0x7FF, []byte{0xDF, 0xBF}},
+		{0x800, []byte{0xE0, 0xA0, 0x80}},
+		{0x10000, []byte{0xF0, 0x90, 0x80, 0x80}},
+	}
+	for _, tt := range tests {
+		got := flacCodedNumber(tt.n)
+		if !bytes.Equal(got, tt.want) {
+			t.Errorf("flacCodedNumber(%#x) = % x, expected % x", tt.n, got, tt.want)
+		}
+		r := &bitReader{data: got}
+		if err := r.skipCodedNumber(); err != nil || r.pos != len(got)*8 {
+			t.Errorf("Failed to skip % x: %v", got, err)
+		}
+	}
+}
+
+func TestFLACCRCs(t *testing.T) {
+	// Check values of CRC-8/SMBUS and CRC-16/UMTS for "123456789"
+	if got := crc8([]byte("123456789")); got != 0xF4 {
+		t.Errorf("crc8 = %#x, expected 0xf4", got)
+	}
+	if got := crc16([]byte("123456789")); got != 0xFEE8 {
+		t.Errorf("crc16 = %#x, expected 0xfee8", got)
+	}
+}
+
+func TestSaveFLACFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "take.flac")
+	wfx := newPCMFormat(2, 48000, 24)
+	original := int32ToPCM(testPCM(4800, 2, 24, 6), 24, 24)
+	if err := saveFLACFile(path, original, wfx, defaultFLACCompression); err != nil {
+		t.Fatal(err)
+	}
+	_, decoded, err := readFLACFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(decoded, original) {
+		t.Error("Decoded audio differs from the original")
+	}
+}
+
+func TestExportAndImportFLAC(t *testing.T) {
+	isolateProjects(t)
+	projectRoot = t.TempDir()
+	if err := ensureTracksDir(); err != nil {
+		t.Fatal(err)
+	}
+	writeTestTrack(t, getTrackPath("vocals"), 2, []int16{1000, -1000, 2000, -2000, 3000, -3000})
+
+	exported := filepath.Join(t.TempDir(), "vocals.flac")
+	if err := exportTrack("vocals", exported, exportOptions{Compression: defaultFLACCompression, Dither: ditherNone}); err != nil {
+		t.Fatalf("Export to FLAC failed: %v", err)
+	}
+	if err := importTrack(exported, "vocals_copy"); err != nil {
+		t.Fatalf("Import failed: %v", err)
+	}
+
+	_, original, _ := readWavFile(getTrackPath("vocals"))
+	wfx, imported, err := readWavFile(getTrackPath("vocals_copy"))
+	if err != nil {
+		t.Fatalf("Imported track is not a readable WAV: %v", err)
+	}
+	if wfx.Channels != 2 || wfx.BitsPerSample != 16 || !bytes.Equal(imported, original) {
+		t.Errorf("Expected the imported track to match the original, got %+v", wfx)
+	}
+
+	if err := importTrack(exported, "vocals_copy"); err == nil {
+		t.Error("Expected importing over an existing track to fail")
+	}
+}
+
+func TestExportFLAC_FloatTrack(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "mix.wav")
+	output := filepath.Join(dir, "mix.flac")
+	wfx := newFloatFormat(2, 48000)
+	data, _ := encodeSamples([]float64{0.5, -0.5, 0.25, -0.25}, wfx, nil)
+	if err := saveWavFile(input, data, wfx); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := convertTrackFile(input, output, exportOptions{Compression: defaultFLACCompression, Dither: ditherNone}); err != nil {
+		t.Fatalf("convertTrackFile failed: %v", err)
+	}
+	flacFormat, flacData, err := readAudioFile(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if flacFormat.BitsPerSample != 24 {
+		t.Errorf("Expected float audio to be stored as 24-bit, got %d-bit", flacFormat.BitsPerSample)
+	}
+	samples, _ := decodeSamples(flacData, flacFormat)
+	if math.Abs(samples[0]-0.5) > 1e-6 || math.Abs(samples[3]+0.25) > 1e-6 {
+		t.Errorf("Unexpected samples %v", samples)
+	}
+}
+
+func TestExportFLAC_Int32Track(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "mix.wav")
+	output := filepath.Join(dir, "mix.flac")
+	wfx := newPCMFormat(2, 48000, 32)
+	data, _ := encodeSamples([]float64{0.5, -0.5, 0.25, -0.25}, wfx, nil)
+	if err := saveWavFile(input, data, wfx); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := convertTrackFile(input, output, exportOptions{Compression: defaultFLACCompression, Dither: ditherTPDF}); err != nil {
+		t.Fatalf("convertTrackFile failed: %v", err)
+	}
+	flacFormat, flacData, err := readAudioFile(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if flacFormat.BitsPerSample != 24 {
+		t.Errorf("Expected 32-bit audio to be stored as 24-bit, got %d-bit", flacFormat.BitsPerSample)
+	}
+	samples, _ := decodeSamples(flacData, flacFormat)
+	if math.Abs(samples[0]-0.5) > 1e-6 || math.Abs(samples[3]+0.25) > 1e-6 {
+		t.Errorf("Unexpected samples %v", samples)
+	}
+}
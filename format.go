@@ -0,0 +1,148 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Storage formats tracks can be recorded and mixed in. The device's sample
+// rate and channel count are always kept; only the sample encoding changes.
+const (
+	storagePCM16   = "pcm16"
+	storagePCM24   = "pcm24"
+	storageFloat32 = "float32"
+)
+
+const defaultStorageFormat = storagePCM16
+
+// storageFormatName returns the storage format to use. A project setting
+// wins over the user config, and 16-bit PCM is used when neither sets one.
+func storageFormatName(config Config, project Project) string {
+	if project.StorageFormat != "" {
+		return project.StorageFormat
+	}
+	if config.StorageFormat != nil {
+		return *config.StorageFormat
+	}
+	return defaultStorageFormat
+}
+
+// storageFormat returns the file format for a storage format name.
+func storageFormat(name string, channels uint16, sampleRate uint32) (*WaveFormat, error) {
+	switch name {
+	case storagePCM16:
+		return newPCMFormat(channels, sampleRate, 16), nil
+	case storagePCM24:
+		return newPCMFormat(channels, sampleRate, 24), nil
+	case storageFloat32:
+		return newFloatFormat(channels, sampleRate), nil
+	default:
+		return nil, fmt.Errorf("unknown storage format '%s' (use %s, %s or %s)", name, storagePCM16, storagePCM24, storageFloat32)
+	}
+}
+
+// describeEncoding renders a format's sample encoding, e.g. "24-bit PCM".
+func describeEncoding(wfx *WaveFormat) string {
+	if isFloatFormat(wfx) {
+		return fmt.Sprintf("%d-bit float", wfx.BitsPerSample)
+	}
+	return fmt.Sprintf("%d-bit PCM", wfx.BitsPerSample)
+}
+
+// newSampleConverter returns a function that re-encodes chunks of samples
+// from one format to another with the same channel layout, or nil when the
+// encodings already match. Dither is applied when the conversion loses
+// resolution.
+func newSampleConverter(from, to *WaveFormat, dither string) (func([]byte) ([]byte, error), error) {
+	if from.Encoding() == to.Encoding() && from.BitsPerSample == to.BitsPerSample {
+		return nil, nil
+	}
+	ditherer, err := ditherFor(from, to, dither)
+	if err != nil {
+		return nil, err
+	}
+	return func(chunk []byte) ([]byte, error) {
+		samples, err := decodeSamples(chunk, from)
+		if err != nil {
+			return nil, err
+		}
+		return encodeSamples(samples, to, ditherer)
+	}, nil
+}
+
+// sniffAudioFile returns the first four bytes of a file, which tell WAV,
+// AIFF and FLAC apart.
+func sniffAudioFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	magic := make([]byte, 4)
+	if _, err := io.ReadFull(f, magic); err != nil {
+		return "", fmt.Errorf("%s: not an audio file", path)
+	}
+	return string(magic), nil
+}
+
+// readAudioFile reads a WAV, AIFF or FLAC file, telling them apart by their
+// leading bytes, and returns the format and sample data laid out as in a WAV
+// data chunk.
+func readAudioFile(path string) (*WaveFormat, []byte, error) {
+	magic, err := sniffAudioFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	switch magic {
+	case flacMagic:
+		return readFLACFile(path)
+	case "RIFF", "RF64", "BW64":
+		return readWavFile(path)
+	case "FORM":
+		return readAIFFFile(path)
+	default:
+		return nil, nil, fmt.Errorf("%s: unsupported file type (expected WAV, AIFF or FLAC)", path)
+	}
+}
+
+// readAudioHeader returns the format and sample data size of a WAV or AIFF
+// file without reading the samples.
+func readAudioHeader(path string) (*WaveFormat, int64, error) {
+	magic, err := sniffAudioFile(path)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	switch magic {
+	case "RIFF", "RF64", "BW64":
+		return readWavHeader(path)
+	case "FORM":
+		return readAIFFHeader(path)
+	default:
+		return nil, 0, fmt.Errorf("%s: unsupported file type (expected WAV or AIFF)", path)
+	}
+}
+
+// saveAudioFile writes sample data as WAV, or as AIFF when the path ends in
+// .aif, .aiff or .aifc.
+func saveAudioFile(path string, audioData []byte, wfx *WaveFormat) error {
+	return saveAudioFileAs(path, filepath.Ext(path), audioData, wfx)
+}
+
+// saveAudioFileAs writes sample data in the format that goes with the given
+// extension, for temporary files whose own extension says nothing. Extra
+// RIFF chunks are only written to WAV files; AIFF has nowhere to keep them.
+func saveAudioFileAs(path, ext string, audioData []byte, wfx *WaveFormat, extra ...[]byte) error {
+	switch strings.ToLower(ext) {
+	case ".aif", ".aiff":
+		return saveAIFFFile(path, audioData, wfx, false)
+	case ".aifc":
+		return saveAIFFFile(path, audioData, wfx, true)
+	default:
+		return saveWavFile(path, audioData, wfx, extra...)
+	}
+}
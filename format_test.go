@@ -0,0 +1,90 @@
+package main
+
+import (
+	"encoding/binary"
+	"math"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestStorageFormatName(t *testing.T) {
+	if got := storageFormatName(Config{}, Project{}); got != storagePCM16 {
+		t.Errorf("Expected the default to be %s, got %s", storagePCM16, got)
+	}
+	float := storageFloat32
+	config := Config{StorageFormat: &float}
+	if got := storageFormatName(config, Project{}); got != storageFloat32 {
+		t.Errorf("Expected the config setting, got %s", got)
+	}
+	if got := storageFormatName(config, Project{StorageFormat: storagePCM24}); got != storagePCM24 {
+		t.Errorf("Expected the project to override the config, got %s", got)
+	}
+	if _, err := storageFormat("pcm8", 2, 44100); err == nil {
+		t.Error("Expected an error for an unknown storage format")
+	}
+}
+
+func TestSampleConverter_FloatToPCM(t *testing.T) {
+	var floats []byte
+	for _, f := range []float32{0, 1.0, -1.0, 2.0, 0.5} {
+		floats = binary.LittleEndian.AppendUint32(floats, math.Float32bits(f))
+	}
+	from := newFloatFormat(1, 48000)
+
+	convert, err := newSampleConverter(from, newPCMFormat(1, 48000, 16), ditherNone)
+	if err != nil {
+		t.Fatalf("newSampleConverter failed: %v", err)
+	}
+	pcm, err := convert(floats)
+	if err != nil {
+		t.Fatalf("Conversion to 16-bit failed: %v", err)
+	}
+	expected16 := []int16{0, 32767, -32767, 32767, 16384}
+	for i, want := range expected16 {
+		if got := int16(binary.LittleEndian.Uint16(pcm[i*2:])); got != want {
+			t.Errorf("16-bit sample %d: expected %d, got %d", i, want, got)
+		}
+	}
+
+	convert, err = newSampleConverter(from, newPCMFormat(1, 48000, 24), ditherNone)
+	if err != nil {
+		t.Fatalf("newSampleConverter failed: %v", err)
+	}
+	pcm, err = convert(floats)
+	if err != nil {
+		t.Fatalf("Conversion to 24-bit failed: %v", err)
+	}
+	if len(pcm) != 15 || pcm[3] != 0xFF || pcm[4] != 0xFF || pcm[5] != 0x7F {
+		t.Errorf("Unexpected 24-bit data %v", pcm)
+	}
+
+	if convert, _ := newSampleConverter(from, newFloatFormat(1, 48000), ditherTPDF); convert != nil {
+		t.Error("Expected no converter between identical encodings")
+	}
+}
+
+func TestRecordFromBackend_StorageFormats(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "mic.wav")
+	writeTestTrack(t, input, 2, []int16{16384, -16384, 8192, -8192})
+
+	for _, storage := range []string{storagePCM24, storageFloat32} {
+		trackPath := filepath.Join(dir, storage+".wav")
+		backend := &FileBackend{InputPath: input}
+		if err := recordFromBackend(backend, "", trackPath, storage, defaultDither, nil, strings.NewReader("\n")); err != nil {
+			t.Fatalf("%s: recordFromBackend failed: %v", storage, err)
+		}
+
+		track, err := loadTrack(trackPath)
+		if err != nil {
+			t.Fatalf("%s: failed to read the take: %v", storage, err)
+		}
+		expected := []float64{0.5, -0.5, 0.25, -0.25}
+		for i, want := range expected {
+			if math.Abs(track.Samples[i]-want) > 1e-6 {
+				t.Errorf("%s: sample %d expected %g, got %g", storage, i, want, track.Samples[i])
+			}
+		}
+	}
+}
@@ -3,7 +3,7 @@ module muxic
 go 1.25.5
 
 require (
-	github.com/go-ole/go-ole v1.3.0 // indirect
-	github.com/moutend/go-wca v0.3.0 // indirect
-	golang.org/x/sys v0.1.0 // indirect
+	github.com/go-ole/go-ole v1.3.0
+	github.com/moutend/go-wca v0.3.0
+	golang.org/x/sys v0.1.0
 )
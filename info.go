@@ -0,0 +1,164 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"time"
+)
+
+// clipThreshold is how close to full scale a sample must be to count as
+// clipped. It is the largest positive 16-bit value, so a 16-bit track that
+// hit the rails is caught on either side.
+const clipThreshold = 32767.0 / 32768.0
+
+// ChannelStats describes the signal on one channel. Levels are linear.
+type ChannelStats struct {
+	Peak     float64
+	RMS      float64
+	DCOffset float64
+	Clipped  int
+}
+
+// analyzeChannels measures peak, RMS, DC offset and clipping per channel.
+// It works on decoded samples rather than raw bytes, so it covers every bit
+// depth, float format and byte order that decodeSamples understands.
+func analyzeChannels(buf *AudioBuffer) []ChannelStats {
+	stats := make([]ChannelStats, buf.Channels)
+	sums := make([]float64, buf.Channels)
+	squares := make([]float64, buf.Channels)
+
+	for i, s := range buf.Samples {
+		c := i % buf.Channels
+		abs := math.Abs(s)
+		if abs > stats[c].Peak {
+			stats[c].Peak = abs
+		}
+		if abs >= clipThreshold {
+			stats[c].Clipped++
+		}
+		sums[c] += s
+		squares[c] += s * s
+	}
+
+	if frames := buf.Frames(); frames > 0 {
+		for c := range stats {
+			stats[c].DCOffset = sums[c] / float64(frames)
+			stats[c].RMS = math.Sqrt(squares[c] / float64(frames))
+		}
+	}
+	return stats
+}
+
+// infoTrack describes a track, or an audio file such as an export when no
+// track has that name.
+func infoTrack(trackName string) error {
+	trackPath := getTrackPath(trackName)
+	if _, err := os.Stat(trackPath); os.IsNotExist(err) {
+		if info, err := os.Stat(trackName); err != nil || info.IsDir() {
+			return fmt.Errorf("Track '%s' not found", trackName)
+		}
+		trackPath = trackName
+	}
+
+	wfx, audioData, err := readAudioFile(trackPath)
+	if err != nil {
+		return err
+	}
+	samples, err := decodeSamples(audioData, wfx)
+	if err != nil {
+		return err
+	}
+	buf := &AudioBuffer{SampleRate: wfx.SampleRate, Channels: int(wfx.Channels), Samples: samples}
+
+	fmt.Printf("[INFO] %s\n", trackName)
+	fmt.Println("==================")
+	fmt.Printf("  File:        %s\n", trackPath)
+	fmt.Printf("  Format:      %s (tag 0x%04X)\n", formatTagName(wfx), wfx.FormatTag)
+	fmt.Printf("  Sample Rate: %d Hz\n", wfx.SampleRate)
+	fmt.Printf("  Channels:    %d\n", wfx.Channels)
+	if wfx.FormatTag == waveFormatExtensible && wfx.ValidBitsPerSample != 0 && wfx.ValidBitsPerSample != wfx.BitsPerSample {
+		fmt.Printf("  Bit Depth:   %d-bit (%d valid)\n", wfx.BitsPerSample, wfx.ValidBitsPerSample)
+	} else {
+		fmt.Printf("  Bit Depth:   %d-bit\n", wfx.BitsPerSample)
+	}
+	if wfx.FormatTag == waveFormatExtensible {
+		fmt.Printf("  Speakers:    0x%X\n", wfx.ChannelMask)
+	}
+	fmt.Printf("  Duration:    %s (%d frames)\n", formatTimestamp(buf.Duration()), buf.Frames())
+
+	meta, err := readTrackMetadata(trackPath)
+	if err != nil {
+		return err
+	}
+	if meta.Broadcast != nil || meta.IXML != nil {
+		fmt.Println()
+		printTrackMetadata(meta)
+	}
+	tags, err := readAudioTags(trackPath)
+	if err != nil {
+		return err
+	}
+	if len(tags) > 0 {
+		fmt.Println()
+		printTags(tags)
+	}
+
+	fmt.Println()
+	fmt.Println("  Channel  Peak dBFS  RMS dBFS  DC Offset  Clipped")
+	for c, stats := range analyzeChannels(buf) {
+		fmt.Printf("  %-7d  %9s  %8s  %+9.5f  %7d\n", c+1, formatDB(stats.Peak), formatDB(stats.RMS), stats.DCOffset, stats.Clipped)
+	}
+
+	fmt.Println()
+	fmt.Printf("  Integrated Loudness: %s\n", formatLUFS(integratedLoudness(buf)))
+	return nil
+}
+
+// describeTrackFormat summarises a track's format and duration from its
+// header alone, for the long listing.
+func describeTrackFormat(trackPath string) (string, error) {
+	wfx, dataSize, err := readAudioHeader(trackPath)
+	if err != nil {
+		return "", err
+	}
+	frames := dataSize / int64(wfx.BlockAlign)
+	duration := time.Duration(frames) * time.Second / time.Duration(wfx.SampleRate)
+	return fmt.Sprintf("%s, %d Hz, %d ch, %d-bit %s",
+		formatTimestamp(duration), wfx.SampleRate, wfx.Channels, wfx.BitsPerSample, formatTagName(wfx)), nil
+}
+
+func formatTagName(wfx *WaveFormat) string {
+	var name string
+	switch wfx.Encoding() {
+	case waveFormatPCM:
+		name = "PCM"
+	case waveFormatIEEEFloat:
+		name = "IEEE Float"
+	default:
+		name = "Unknown"
+	}
+	if wfx.FormatTag == waveFormatExtensible {
+		name += " (Extensible)"
+	}
+	return name
+}
+
+func gainToDB(gain float64) float64 {
+	return 20 * math.Log10(gain)
+}
+
+// formatDB renders a linear level in dBFS, showing silence as -inf.
+func formatDB(level float64) string {
+	if level <= 0 {
+		return "-inf"
+	}
+	return fmt.Sprintf("%.1f", gainToDB(level))
+}
+
+func formatLUFS(lufs float64) string {
+	if math.IsInf(lufs, -1) {
+		return "-inf LUFS"
+	}
+	return fmt.Sprintf("%.1f LUFS", lufs)
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestAnalyzeChannels(t *testing.T) {
+	// Left: DC at 0.25. Right: alternating full scale, every sample clipped.
+	buf := &AudioBuffer{SampleRate: 44100, Channels: 2, Samples: []float64{
+		0.25, 1.0,
+		0.25, -1.0,
+		0.25, 1.0,
+		0.25, -1.0,
+	}}
+
+	stats := analyzeChannels(buf)
+	left, right := stats[0], stats[1]
+
+	if left.Peak != 0.25 || left.RMS != 0.25 || left.DCOffset != 0.25 || left.Clipped != 0 {
+		t.Errorf("Unexpected left stats: %+v", left)
+	}
+	if right.Peak != 1.0 || right.RMS != 1.0 || right.DCOffset != 0 || right.Clipped != 4 {
+		t.Errorf("Unexpected right stats: %+v", right)
+	}
+}
+
+func TestReadWavHeader(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "header.wav")
+	writeTestTrack(t, path, 2, make([]int16, 88200))
+
+	wfx, dataSize, err := readWavHeader(path)
+	if err != nil {
+		t.Fatalf("readWavHeader failed: %v", err)
+	}
+	if wfx.Channels != 2 || wfx.SampleRate != 44100 || dataSize != 176400 {
+		t.Errorf("Unexpected header: %+v, %d bytes", wfx, dataSize)
+	}
+
+	description, err := describeTrackFormat(path)
+	if err != nil {
+		t.Fatalf("describeTrackFormat failed: %v", err)
+	}
+	if expected := "0:01.0, 44100 Hz, 2 ch, 16-bit PCM"; description != expected {
+		t.Errorf("Expected %q, got %q", expected, description)
+	}
+}
+
+func TestFormatDB(t *testing.T) {
+	if got := formatDB(0); got != "-inf" {
+		t.Errorf("Expected -inf for silence, got %s", got)
+	}
+	if got := formatDB(0.5); got != "-6.0" {
+		t.Errorf("Expected -6.0 for half scale, got %s", got)
+	}
+}
@@ -0,0 +1,536 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"strings"
+)
+
+// Loudness measurement per ITU-R BS.1770 and EBU R128: K-weighting, 400ms
+// gating blocks with 75% overlap, an absolute gate at -70 LUFS and a relative
+// gate 10 LU below the ungated level. Momentary loudness uses the same 400ms
+// window and short-term loudness a 3s one, both updated every 100ms. The
+// loudness range (EBU Tech 3342) is the spread between the 10th and 95th
+// percentiles of the gated short-term values, and true peak is measured on a
+// 4x oversampled signal.
+
+const (
+	loudnessBlock       = 0.4  // seconds per gating block
+	loudnessOverlap     = 0.75 // fraction of each block shared with the next
+	shortTermBlock      = 3.0  // seconds per short-term window
+	absoluteGateLUFS    = -70.0
+	relativeGateLU      = -10.0
+	rangeRelativeGateLU = -20.0
+	rangeLowPercentile  = 0.10
+	rangeHighPercentile = 0.95
+	loudnessOffsetLUFS  = -0.691
+	surroundChannelGain = 1.41
+)
+
+// silenceLoudnessLUFS is reported for silence or too little material to gate.
+var silenceLoudnessLUFS = math.Inf(-1)
+
+// biquad is a direct form I second-order IIR filter section.
+type biquad struct {
+	b0, b1, b2 float64
+	a1, a2     float64
+	x1, x2     float64
+	y1, y2     float64
+}
+
+func (f *biquad) process(x float64) float64 {
+	y := f.b0*x + f.b1*f.x1 + f.b2*f.x2 - f.a1*f.y1 - f.a2*f.y2
+	f.x2, f.x1 = f.x1, x
+	f.y2, f.y1 = f.y1, y
+	return y
+}
+
+// kWeightingFilters returns the BS.1770 pre-filter (a high shelf modelling the
+// head) and RLB high-pass, with coefficients derived for the sample rate.
+func kWeightingFilters(sampleRate uint32) (biquad, biquad) {
+	rate := float64(sampleRate)
+
+	f0 := 1681.974450955533
+	gain := 3.999843853973347
+	q := 0.7071752369554196
+	k := math.Tan(math.Pi * f0 / rate)
+	vh := math.Pow(10, gain/20)
+	vb := math.Pow(vh, 0.4996667741545416)
+	a0 := 1 + k/q + k*k
+	shelf := biquad{
+		b0: (vh + vb*k/q + k*k) / a0,
+		b1: 2 * (k*k - vh) / a0,
+		b2: (vh - vb*k/q + k*k) / a0,
+		a1: 2 * (k*k - 1) / a0,
+		a2: (1 - k/q + k*k) / a0,
+	}
+
+	f0 = 38.13547087602444
+	q = 0.5003270373238773
+	k = math.Tan(math.Pi * f0 / rate)
+	a0 = 1 + k/q + k*k
+	highPass := biquad{
+		b0: 1,
+		b1: -2,
+		b2: 1,
+		a1: 2 * (k*k - 1) / a0,
+		a2: (1 - k/q + k*k) / a0,
+	}
+
+	return shelf, highPass
+}
+
+// channelWeights returns the BS.1770 weighting of each channel in WAV
+// channel order, by the speaker it feeds in the default layout. Back and side
+// surrounds count 1.41 and the LFE is left out entirely; channel counts with
+// no known layout weigh every channel equally.
+func channelWeights(channels int) []float64 {
+	weights := make([]float64, channels)
+	layout, err := speakerLayout(channels)
+	for c := range weights {
+		weights[c] = 1.0
+		if err != nil {
+			continue
+		}
+		switch layout[c] {
+		case speakerLFE:
+			weights[c] = 0
+		case speakerBackLeft, speakerBackRight, speakerSideLeft, speakerSideRight:
+			weights[c] = surroundChannelGain
+		}
+	}
+	return weights
+}
+
+// kWeighted returns the K-weighted copy of the buffer's samples.
+func kWeighted(buf *AudioBuffer) []float64 {
+	weighted := make([]float64, len(buf.Samples))
+	for c := 0; c < buf.Channels; c++ {
+		shelf, highPass := kWeightingFilters(buf.SampleRate)
+		for i := c; i < len(buf.Samples); i += buf.Channels {
+			weighted[i] = highPass.process(shelf.process(buf.Samples[i]))
+		}
+	}
+	return weighted
+}
+
+// blockPowers returns the channel-weighted mean square of each gating block
+// of the given length, stepping by hop frames.
+func blockPowers(weighted []float64, channels int, blockFrames, hopFrames int) []float64 {
+	frames := len(weighted) / channels
+	weights := channelWeights(channels)
+	var powers []float64
+	for start := 0; start+blockFrames <= frames; start += hopFrames {
+		var power float64
+		for c, weight := range weights {
+			if weight == 0 {
+				continue
+			}
+			var sum float64
+			for f := start; f < start+blockFrames; f++ {
+				s := weighted[f*channels+c]
+				sum += s * s
+			}
+			power += weight * sum / float64(blockFrames)
+		}
+		powers = append(powers, power)
+	}
+	return powers
+}
+
+func powerToLUFS(power float64) float64 {
+	if power <= 0 {
+		return silenceLoudnessLUFS
+	}
+	return loudnessOffsetLUFS + 10*math.Log10(power)
+}
+
+// integratedLoudness returns the gated loudness of the whole buffer in LUFS,
+// or -Inf for silence and material shorter than one gating block.
+func integratedLoudness(buf *AudioBuffer) float64 {
+	return gatedLoudness(windowPowers(buf, kWeighted(buf), loudnessBlock))
+}
+
+// windowPowers returns the mean square of each window of the given length in
+// seconds over K-weighted samples, stepping by the 100ms gating hop.
+func windowPowers(buf *AudioBuffer, weighted []float64, seconds float64) []float64 {
+	// Below 10 Hz the hop rounds down to no frames at all, which would never
+	// advance, so windows are always at least one frame long and one apart
+	blockFrames := max(int(seconds*float64(buf.SampleRate)), 1)
+	hopFrames := max(int(loudnessBlock*(1-loudnessOverlap)*float64(buf.SampleRate)), 1)
+	return blockPowers(weighted, buf.Channels, blockFrames, hopFrames)
+}
+
+func gatedLoudness(powers []float64) float64 {
+	var gated []float64
+	for _, p := range powers {
+		if powerToLUFS(p) > absoluteGateLUFS {
+			gated = append(gated, p)
+		}
+	}
+	if len(gated) == 0 {
+		return silenceLoudnessLUFS
+	}
+
+	relativeGate := powerToLUFS(meanOf(gated)) + relativeGateLU
+	var sum float64
+	var count int
+	for _, p := range gated {
+		if powerToLUFS(p) > relativeGate {
+			sum += p
+			count++
+		}
+	}
+	if count == 0 {
+		return silenceLoudnessLUFS
+	}
+	return powerToLUFS(sum / float64(count))
+}
+
+func meanOf(values []float64) float64 {
+	var sum float64
+	for _, v := range values {
+		sum += v
+	}
+	return sum / float64(len(values))
+}
+
+// LoudnessStats is a full loudness measurement. Loudness values are in LUFS,
+// the range in LU and peaks in dBFS/dBTP; -Inf marks silence.
+type LoudnessStats struct {
+	Integrated   float64
+	MomentaryMax float64
+	ShortTermMax float64
+	Range        float64
+	TruePeak     float64
+	SamplePeak   float64
+}
+
+// measureLoudness runs every loudness measurement over the buffer.
+func measureLoudness(buf *AudioBuffer) LoudnessStats {
+	weighted := kWeighted(buf)
+	momentary := windowPowers(buf, weighted, loudnessBlock)
+	shortTerm := windowPowers(buf, weighted, shortTermBlock)
+
+	return LoudnessStats{
+		Integrated:   gatedLoudness(momentary),
+		MomentaryMax: powerToLUFS(maxOf(momentary)),
+		ShortTermMax: powerToLUFS(maxOf(shortTerm)),
+		Range:        loudnessRange(shortTerm),
+		TruePeak:     gainToDB(truePeak(buf)),
+		SamplePeak:   gainToDB(buf.Peak()),
+	}
+}
+
+// loudnessRange returns the EBU Tech 3342 loudness range of short-term block
+// powers in LU.
+func loudnessRange(powers []float64) float64 {
+	var gated []float64
+	for _, p := range powers {
+		if powerToLUFS(p) > absoluteGateLUFS {
+			gated = append(gated, p)
+		}
+	}
+	if len(gated) == 0 {
+		return 0
+	}
+
+	relativeGate := powerToLUFS(meanOf(gated)) + rangeRelativeGateLU
+	var levels []float64
+	for _, p := range gated {
+		if lufs := powerToLUFS(p); lufs > relativeGate {
+			levels = append(levels, lufs)
+		}
+	}
+	if len(levels) == 0 {
+		return 0
+	}
+	sort.Float64s(levels)
+	return percentile(levels, rangeHighPercentile) - percentile(levels, rangeLowPercentile)
+}
+
+// percentile interpolates the p-th percentile of sorted values.
+func percentile(sorted []float64, p float64) float64 {
+	position := p * float64(len(sorted)-1)
+	lower := int(position)
+	if lower+1 >= len(sorted) {
+		return sorted[len(sorted)-1]
+	}
+	frac := position - float64(lower)
+	return sorted[lower] + frac*(sorted[lower+1]-sorted[lower])
+}
+
+func maxOf(values []float64) float64 {
+	var largest float64
+	for _, v := range values {
+		largest = math.Max(largest, v)
+	}
+	return largest
+}
+
+// True peak oversampling per BS.1770 Annex 2: 4x below 96 kHz, 2x up to
+// 192 kHz, and none above, through a short interpolating filter.
+const (
+	truePeakHalfTaps = 6
+	truePeakBeta     = 8.0
+	truePeakCutoff   = 0.9
+)
+
+func truePeakFactor(sampleRate uint32) int {
+	switch {
+	case sampleRate < 96000:
+		return 4
+	case sampleRate < 192000:
+		return 2
+	default:
+		return 1
+	}
+}
+
+// truePeak returns the largest absolute value of the reconstructed signal,
+// which can exceed the largest sample when peaks fall between samples.
+func truePeak(buf *AudioBuffer) float64 {
+	factor := truePeakFactor(buf.SampleRate)
+	peak := buf.Peak()
+	if factor == 1 {
+		return peak
+	}
+
+	// One set of interpolation coefficients per intermediate position
+	taps := 2 * truePeakHalfTaps
+	fc := 0.5 * truePeakCutoff
+	bank := make([][]float64, factor)
+	for phase := 1; phase < factor; phase++ {
+		bank[phase] = make([]float64, taps)
+		frac := float64(phase) / float64(factor)
+		var sum float64
+		for j := range bank[phase] {
+			x := frac - float64(j-truePeakHalfTaps+1)
+			bank[phase][j] = 2 * fc * sinc(2*fc*x) * kaiser(x/truePeakHalfTaps, truePeakBeta)
+			sum += bank[phase][j]
+		}
+		for j := range bank[phase] {
+			bank[phase][j] /= sum
+		}
+	}
+
+	frames := buf.Frames()
+	for c := 0; c < buf.Channels; c++ {
+		for f := 0; f < frames; f++ {
+			first := f - truePeakHalfTaps + 1
+			for phase := 1; phase < factor; phase++ {
+				var acc float64
+				for j, h := range bank[phase] {
+					if k := first + j; k >= 0 && k < frames {
+						acc += h * buf.Samples[k*buf.Channels+c]
+					}
+				}
+				peak = math.Max(peak, math.Abs(acc))
+			}
+		}
+	}
+	return peak
+}
+
+// loudnessPreset is a delivery spec to check a measurement against.
+type loudnessPreset struct {
+	Name        string
+	Target      float64 // integrated LUFS
+	Tolerance   float64 // LU either side of the target
+	MaxTruePeak float64 // dBTP
+}
+
+var loudnessPresets = []loudnessPreset{
+	{Name: "streaming", Target: -14, Tolerance: 1, MaxTruePeak: -1},
+	{Name: "podcast", Target: -16, Tolerance: 1, MaxTruePeak: -1},
+	{Name: "broadcast", Target: -23, Tolerance: 0.5, MaxTruePeak: -1},
+	{Name: "atsc", Target: -24, Tolerance: 2, MaxTruePeak: -2},
+}
+
+func findLoudnessPreset(name string) (loudnessPreset, error) {
+	var names []string
+	for _, preset := range loudnessPresets {
+		if preset.Name == name {
+			return preset, nil
+		}
+		names = append(names, preset.Name)
+	}
+	return loudnessPreset{}, fmt.Errorf("unknown loudness preset '%s' (use %s)", name, strings.Join(names, ", "))
+}
+
+// check reports whether the measurement meets the preset's loudness and true
+// peak limits.
+func (p loudnessPreset) check(stats LoudnessStats) (loudnessOK, peakOK bool) {
+	loudnessOK = math.Abs(stats.Integrated-p.Target) <= p.Tolerance
+	peakOK = stats.TruePeak <= p.MaxTruePeak
+	return loudnessOK, peakOK
+}
+
+// loudnessReport is the JSON form of a measurement. Silence is reported as
+// null, since JSON has no infinity.
+type loudnessReport struct {
+	Source         string         `json:"source"`
+	IntegratedLUFS *float64       `json:"integrated_lufs"`
+	MomentaryMax   *float64       `json:"momentary_max_lufs"`
+	ShortTermMax   *float64       `json:"short_term_max_lufs"`
+	RangeLU        float64        `json:"loudness_range_lu"`
+	TruePeakDBTP   *float64       `json:"true_peak_dbtp"`
+	SamplePeakDBFS *float64       `json:"sample_peak_dbfs"`
+	Preset         *presetVerdict `json:"preset,omitempty"`
+}
+
+type presetVerdict struct {
+	Name        string  `json:"name"`
+	TargetLUFS  float64 `json:"target_lufs"`
+	ToleranceLU float64 `json:"tolerance_lu"`
+	MaxTruePeak float64 `json:"max_true_peak_dbtp"`
+	LoudnessOK  bool    `json:"loudness_ok"`
+	TruePeakOK  bool    `json:"true_peak_ok"`
+	Pass        bool    `json:"pass"`
+}
+
+func finiteOrNil(v float64) *float64 {
+	if math.IsInf(v, 0) || math.IsNaN(v) {
+		return nil
+	}
+	return &v
+}
+
+const loudnessUsage = "Usage: muxic loudness <track-name> | --mix [--preset streaming|podcast|broadcast|atsc] [--json]"
+
+// loudnessCommand measures a track, or the project mix with --mix, and
+// optionally checks it against a delivery preset.
+func loudnessCommand(args []string, quality string) error {
+	args, presetName, err := extractFlag(args, "preset")
+	if err != nil {
+		return err
+	}
+	var preset *loudnessPreset
+	if presetName != "" {
+		found, err := findLoudnessPreset(presetName)
+		if err != nil {
+			return err
+		}
+		preset = &found
+	}
+
+	var source string
+	asJSON, useMix := false, false
+	for _, arg := range args {
+		switch arg {
+		case "--json":
+			asJSON = true
+		case "--mix":
+			useMix = true
+		default:
+			source = arg
+		}
+	}
+
+	var buf *AudioBuffer
+	switch {
+	case useMix:
+		source = "mix"
+		var progress io.Writer = os.Stdout
+		if asJSON {
+			progress = io.Discard
+		}
+		sources, err := projectSources("", progress)
+		if err != nil {
+			return err
+		}
+		if len(sources) == 0 {
+			return fmt.Errorf("No tracks found to mix")
+		}
+		if buf, err = mixFiles(sources, quality); err != nil {
+			return err
+		}
+	case source != "":
+		trackPath := getTrackPath(source)
+		if _, err := os.Stat(trackPath); os.IsNotExist(err) {
+			return fmt.Errorf("Track '%s' not found", source)
+		}
+		if buf, err = loadTrack(trackPath); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("track name or --mix required\n%s", loudnessUsage)
+	}
+
+	stats := measureLoudness(buf)
+	if asJSON {
+		return printLoudnessJSON(source, stats, preset)
+	}
+	printLoudness(source, stats, preset)
+	return nil
+}
+
+func printLoudness(source string, stats LoudnessStats, preset *loudnessPreset) {
+	fmt.Printf("[LOUDNESS] %s\n", source)
+	fmt.Println("==================")
+	fmt.Printf("  Integrated:      %s\n", formatLUFS(stats.Integrated))
+	fmt.Printf("  Momentary max:   %s\n", formatLUFS(stats.MomentaryMax))
+	fmt.Printf("  Short-term max:  %s\n", formatLUFS(stats.ShortTermMax))
+	fmt.Printf("  Loudness range:  %.1f LU\n", stats.Range)
+	fmt.Printf("  True peak:       %s dBTP\n", formatLevel(stats.TruePeak))
+	fmt.Printf("  Sample peak:     %s dBFS\n", formatLevel(stats.SamplePeak))
+
+	if preset == nil {
+		return
+	}
+	loudnessOK, peakOK := preset.check(stats)
+	fmt.Println()
+	fmt.Printf("  Preset %s (%.0f LUFS ±%g LU, true peak ≤ %g dBTP)\n", preset.Name, preset.Target, preset.Tolerance, preset.MaxTruePeak)
+	fmt.Printf("    Loudness:  %s\n", passFail(loudnessOK))
+	fmt.Printf("    True peak: %s\n", passFail(peakOK))
+	fmt.Printf("  Result: %s\n", passFail(loudnessOK && peakOK))
+}
+
+func printLoudnessJSON(source string, stats LoudnessStats, preset *loudnessPreset) error {
+	report := loudnessReport{
+		Source:         source,
+		IntegratedLUFS: finiteOrNil(stats.Integrated),
+		MomentaryMax:   finiteOrNil(stats.MomentaryMax),
+		ShortTermMax:   finiteOrNil(stats.ShortTermMax),
+		RangeLU:        stats.Range,
+		TruePeakDBTP:   finiteOrNil(stats.TruePeak),
+		SamplePeakDBFS: finiteOrNil(stats.SamplePeak),
+	}
+	if preset != nil {
+		loudnessOK, peakOK := preset.check(stats)
+		report.Preset = &presetVerdict{
+			Name:        preset.Name,
+			TargetLUFS:  preset.Target,
+			ToleranceLU: preset.Tolerance,
+			MaxTruePeak: preset.MaxTruePeak,
+			LoudnessOK:  loudnessOK,
+			TruePeakOK:  peakOK,
+			Pass:        loudnessOK && peakOK,
+		}
+	}
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(data))
+	return nil
+}
+
+// formatLevel renders a level in dB to one decimal, showing silence as -inf.
+func formatLevel(db float64) string {
+	if math.IsInf(db, -1) {
+		return "-inf"
+	}
+	return fmt.Sprintf("%.1f", db)
+}
+
+func passFail(ok bool) string {
+	if ok {
+		return "PASS"
+	}
+	return "FAIL"
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"math"
+	"slices"
+	"testing"
+)
+
+func sineBuffer(sampleRate uint32, channels int, freq, amplitude float64, seconds float64) *AudioBuffer {
+	frames := int(seconds * float64(sampleRate))
+	buf := &AudioBuffer{SampleRate: sampleRate, Channels: channels, Samples: make([]float64, frames*channels)}
+	for f := 0; f < frames; f++ {
+		s := amplitude * math.Sin(2*math.Pi*freq*float64(f)/float64(sampleRate))
+		for c := 0; c < channels; c++ {
+			buf.Samples[f*channels+c] = s
+		}
+	}
+	return buf
+}
+
+func TestIntegratedLoudness_Sine(t *testing.T) {
+	// BS.1770 reference: a 997 Hz sine at 0 dBFS peak in one channel reads
+	// -3.01 LUFS, so the same sine 20 dB down reads -23.01 LUFS. Feeding it
+	// to both channels of a stereo file doubles the power.
+	for _, rate := range []uint32{44100, 48000} {
+		mono := sineBuffer(rate, 1, 997, 0.1, 5)
+		if got := integratedLoudness(mono); math.Abs(got-(-23.01)) > 0.1 {
+			t.Errorf("%d Hz mono: expected -23.0 LUFS, got %.2f", rate, got)
+		}
+		stereo := sineBuffer(rate, 2, 997, 0.1, 5)
+		if got := integratedLoudness(stereo); math.Abs(got-(-20.0)) > 0.1 {
+			t.Errorf("%d Hz stereo: expected -20.0 LUFS, got %.2f", rate, got)
+		}
+	}
+}
+
+func TestChannelWeights(t *testing.T) {
+	tests := []struct {
+		channels int
+		expected []float64
+	}{
+		{2, []float64{1, 1}},
+		{5, []float64{1, 1, 1, 1.41, 1.41}},
+		{6, []float64{1, 1, 1, 0, 1.41, 1.41}},
+		{8, []float64{1, 1, 1, 0, 1.41, 1.41, 1.41, 1.41}},
+		{7, []float64{1, 1, 1, 1, 1, 1, 1}},
+	}
+	for _, tt := range tests {
+		if got := channelWeights(tt.channels); !slices.Equal(got, tt.expected) {
+			t.Errorf("%d channels: expected %v, got %v", tt.channels, tt.expected, got)
+		}
+	}
+}
+
+func TestIntegratedLoudness_71(t *testing.T) {
+	// The same sine alone in a side surround of a 7.1 file reads 1.49 LU
+	// louder than in a front channel; in the LFE it does not count at all
+	sine := sineBuffer(48000, 1, 997, 0.1, 5)
+	inChannel := func(channel int) *AudioBuffer {
+		buf := &AudioBuffer{SampleRate: 48000, Channels: 8, Samples: make([]float64, len(sine.Samples)*8)}
+		for f, s := range sine.Samples {
+			buf.Samples[f*8+channel] = s
+		}
+		return buf
+	}
+	if got := integratedLoudness(inChannel(0)); math.Abs(got-(-23.01)) > 0.1 {
+		t.Errorf("Front left: expected -23.0 LUFS, got %.2f", got)
+	}
+	if got := integratedLoudness(inChannel(6)); math.Abs(got-(-21.52)) > 0.1 {
+		t.Errorf("Side left: expected -21.5 LUFS, got %.2f", got)
+	}
+	if got := integratedLoudness(inChannel(3)); !math.IsInf(got, -1) {
+		t.Errorf("LFE: expected -inf, got %.2f", got)
+	}
+}
+
+func TestIntegratedLoudness_Silence(t *testing.T) {
+	buf := &AudioBuffer{SampleRate: 48000, Channels: 2, Samples: make([]float64, 48000*2*2)}
+	if got := integratedLoudness(buf); !math.IsInf(got, -1) {
+		t.Errorf("Expected -inf for silence, got %f", got)
+	}
+}
+
+func TestMeasureLoudness_LowSampleRate(t *testing.T) {
+	// At 8 Hz the 100ms hop is less than one frame; this used to never return
+	buf := &AudioBuffer{SampleRate: 8, Channels: 1, Samples: []float64{0.5, -0.5, 0.5, -0.5, 0.5, -0.5, 0.5, -0.5}}
+	if powers := windowPowers(buf, buf.Samples, loudnessBlock); len(powers) != 6 {
+		t.Errorf("Expected six 3-frame windows a frame apart, got %d", len(powers))
+	}
+	measureLoudness(buf)
+}
+
+func TestIntegratedLoudness_RelativeGate(t *testing.T) {
+	// Quiet material more than 10 LU below the rest is gated out
+	loud := sineBuffer(48000, 2, 997, 0.1, 5)
+	quiet := sineBuffer(48000, 2, 997, 0.001, 5)
+	buf := &AudioBuffer{SampleRate: 48000, Channels: 2, Samples: append(loud.Samples, quiet.Samples...)}
+
+	if got := integratedLoudness(buf); math.Abs(got-(-20.0)) > 0.2 {
+		t.Errorf("Expected quiet half to be gated, got %.2f LUFS", got)
+	}
+}
+
+func TestMeasureLoudness_SteadySine(t *testing.T) {
+	// A steady tone has the same loudness on every time scale and no range
+	buf := sineBuffer(48000, 2, 997, 0.1, 6)
+	stats := measureLoudness(buf)
+	for name, got := range map[string]float64{
+		"integrated": stats.Integrated,
+		"momentary":  stats.MomentaryMax,
+		"short-term": stats.ShortTermMax,
+	} {
+		if math.Abs(got-(-20.0)) > 0.1 {
+			t.Errorf("Expected %s loudness of -20.0 LUFS, got %.2f", name, got)
+		}
+	}
+	if stats.Range > 0.1 {
+		t.Errorf("Expected no loudness range for a steady tone, got %.2f LU", stats.Range)
+	}
+	if math.Abs(stats.SamplePeak-(-20)) > 0.01 {
+		t.Errorf("Expected a -20 dBFS sample peak, got %.2f", stats.SamplePeak)
+	}
+}
+
+func TestLoudnessRange(t *testing.T) {
+	// EBU Tech 3342: equal halves at -20 and -30 LUFS give an LRA of 10 LU
+	rate := uint32(48000)
+	loud := sineBuffer(rate, 1, 997, 0.1*math.Sqrt(2), 20)
+	quiet := sineBuffer(rate, 1, 997, 0.1*math.Sqrt(2)/math.Sqrt(10), 20)
+	buf := &AudioBuffer{SampleRate: rate, Channels: 1, Samples: append(loud.Samples, quiet.Samples...)}
+
+	if got := measureLoudness(buf).Range; math.Abs(got-10) > 1 {
+		t.Errorf("Expected a loudness range of about 10 LU, got %.2f", got)
+	}
+}
+
+func TestLoudnessRange_Silence(t *testing.T) {
+	if got := loudnessRange([]float64{0, 0, 0}); got != 0 {
+		t.Errorf("Expected no range for silence, got %f", got)
+	}
+}
+
+func TestTruePeak_InterSamplePeak(t *testing.T) {
+	// A sine at a quarter of the sample rate sampled 45 degrees off its
+	// crests never lands on a peak: every sample is at 0.707 of it
+	rate := uint32(48000)
+	frames := int(rate)
+	buf := &AudioBuffer{SampleRate: rate, Channels: 1, Samples: make([]float64, frames)}
+	for f := range buf.Samples {
+		buf.Samples[f] = 0.5 * math.Sin(math.Pi/2*float64(f)+math.Pi/4)
+	}
+
+	stats := measureLoudness(buf)
+	if math.Abs(stats.SamplePeak-gainToDB(0.5*math.Sqrt(0.5))) > 0.01 {
+		t.Errorf("Unexpected sample peak %.2f dBFS", stats.SamplePeak)
+	}
+	if math.Abs(stats.TruePeak-gainToDB(0.5)) > 0.5 {
+		t.Errorf("Expected a true peak near %.2f dBTP, got %.2f", gainToDB(0.5), stats.TruePeak)
+	}
+}
+
+func TestTruePeak_NeverBelowSamplePeak(t *testing.T) {
+	buf := sineBuffer(44100, 2, 100, 0.8, 1)
+	if got := truePeak(buf); got < buf.Peak() {
+		t.Errorf("True peak %f below sample peak %f", got, buf.Peak())
+	}
+}
+
+func TestLoudnessPresetCheck(t *testing.T) {
+	streaming, err := findLoudnessPreset("streaming")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		stats              LoudnessStats
+		loudnessOK, peakOK bool
+	}{
+		{LoudnessStats{Integrated: -14.2, TruePeak: -1.5}, true, true},
+		{LoudnessStats{Integrated: -16, TruePeak: -1.5}, false, true},
+		{LoudnessStats{Integrated: -14, TruePeak: -0.2}, true, false},
+		{LoudnessStats{Integrated: math.Inf(-1), TruePeak: math.Inf(-1)}, false, true},
+	}
+	for _, tt := range tests {
+		loudnessOK, peakOK := streaming.check(tt.stats)
+		if loudnessOK != tt.loudnessOK || peakOK != tt.peakOK {
+			t.Errorf("%+v: expected (%v, %v), got (%v, %v)", tt.stats, tt.loudnessOK, tt.peakOK, loudnessOK, peakOK)
+		}
+	}
+
+	if _, err := findLoudnessPreset("cinema"); err == nil {
+		t.Error("Expected an error for an unknown preset")
+	}
+}
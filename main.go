@@ -2,19 +2,16 @@ package main
 
 import (
 	"bufio"
-	"encoding/binary"
 	"fmt"
 	"io"
-	"math"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"slices"
+	"strconv"
 	"strings"
+	"syscall"
 	"time"
-	"unsafe"
-
-	"github.com/go-ole/go-ole"
-
-	"github.com/moutend/go-wca/pkg/wca"
 )
 
 const (
@@ -24,24 +21,56 @@ const (
 	BitsPerSample = 16
 )
 
+// projectRoot is the directory the current command works in. Tracks and the
+// project file are resolved relative to it.
+var projectRoot = "."
+
 func main() {
-	if len(os.Args) < 2 {
+	args, projectRef, err := extractProjectFlag(os.Args[1:]) // args excluding program name
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	// --dither applies to every command that reduces word length
+	args, dither, err := extractDitherFlag(args)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	// --quality applies to every command that resamples
+	args, quality, err := extractQualityFlag(args)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	if len(args) < 1 {
 		printUsage()
 		return
 	}
 
-	command := os.Args[1]
-	args := os.Args[1:] // args excluding program name
+	command := args[0]
+
+	// Project management works on the registry itself, so it must not fail
+	// just because the active project has gone missing.
+	if command != "project" && command != "help" {
+		if err := useProject(projectRef); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+	}
 
-	var err error
 	switch command {
 	case "record":
+		var meta recordingMetadata
+		if args, meta, err = extractRecordingFlags(args); err != nil {
+			break
+		}
 		if len(args) < 2 {
 			fmt.Println("Error: track name required")
-			fmt.Println("Usage: muxic record <track-name>")
+			fmt.Println("Usage: muxic record <track-name> [--description <text>] [--scene <scene>] [--take <take>]")
 			os.Exit(1)
 		}
-		err = recordTrack(args[1])
+		err = recordTrack(args[1], dither, meta)
 	case "play":
 		if len(args) < 2 {
 			fmt.Println("Error: track name required")
@@ -50,21 +79,128 @@ func main() {
 		}
 		err = playTrack(args[1])
 	case "list":
-		err = listTracks()
+		long := len(args) >= 2 && (args[1] == "-l" || args[1] == "--long")
+		err = listTracks(long)
+	case "info":
+		if len(args) < 2 {
+			fmt.Println("Error: track name required")
+			fmt.Println("Usage: muxic info <track-name>")
+			os.Exit(1)
+		}
+		err = infoTrack(args[1])
+	case "recover":
+		err = recoverTracks()
 	case "mix":
+		var target *normalizeTarget
+		if args, target, err = extractNormalizeFlags(args); err != nil {
+			break
+		}
+		var tags Tags
+		if args, tags, err = extractTagFlags(args); err != nil {
+			break
+		}
 		if len(args) < 2 {
 			fmt.Println("Error: output name required")
-			fmt.Println("Usage: muxic mix <output-name>")
+			fmt.Println("Usage: muxic mix <output-name> [--peak <dBFS> | --lufs <LUFS>] [--tag <key>=<value>]...")
+			os.Exit(1)
+		}
+		err = mixTracks(args[1], target, quality, dither, tags)
+	case "normalize":
+		var target *normalizeTarget
+		if args, target, err = extractNormalizeFlags(args); err != nil {
+			break
+		}
+		var outputName string
+		if args, outputName, err = extractFlag(args, "output"); err != nil {
+			break
+		}
+		if len(args) < 2 {
+			fmt.Println("Error: track name required")
+			fmt.Println("Usage: muxic normalize <track-name> [--peak <dBFS> | --lufs <LUFS>] [--output <new-track>]")
+			os.Exit(1)
+		}
+		if target == nil {
+			target = &normalizeTarget{Mode: normalizePeak, Level: defaultPeakTarget}
+		}
+		err = normalizeTrack(args[1], *target, outputName, dither)
+	case "split":
+		if len(args) < 2 || len(args) == 3 {
+			fmt.Println("Error: track name required")
+			fmt.Println("Usage: muxic split <track-name> [<left-name> <right-name>]")
+			os.Exit(1)
+		}
+		leftName, rightName := "", ""
+		if len(args) >= 4 {
+			leftName, rightName = args[2], args[3]
+		}
+		err = splitTrack(args[1], leftName, rightName)
+	case "merge":
+		if len(args) < 4 {
+			fmt.Println("Error: two mono tracks and an output name required")
+			fmt.Println("Usage: muxic merge <left-track> <right-track> <output-name>")
 			os.Exit(1)
 		}
-		err = mixTracks(args[1])
+		err = mergeTracks(args[1], args[2], args[3])
+	case "track":
+		err = trackCommand(args[1:])
+	case "tag":
+		err = tagCommand(args[1:])
+	case "project":
+		err = projectCommand(args[1:])
+	case "tempo":
+		err = tempoCommand(args[1:])
+	case "panlaw":
+		err = panLawCommand(args[1:])
+	case "monitor":
+		err = monitorCommand(args[1:])
+	case "loudness":
+		err = loudnessCommand(args[1:], quality)
 	case "export":
+		options := exportOptions{Quality: quality, Dither: dither}
+		if args, options.Format, err = extractFlag(args, "format"); err != nil {
+			break
+		}
+		var rate string
+		if args, rate, err = extractFlag(args, "rate"); err != nil {
+			break
+		}
+		if rate != "" {
+			if options.Rate, err = parseSampleRate(rate); err != nil {
+				break
+			}
+		}
+		var channels string
+		if args, channels, err = extractFlag(args, "channels"); err != nil {
+			break
+		}
+		if channels != "" {
+			if options.Channels, err = parseChannelCount(channels); err != nil {
+				break
+			}
+		}
+		if args, options.Compression, err = extractCompressionFlag(args); err != nil {
+			break
+		}
+		if args, options.Tags, err = extractTagFlags(args); err != nil {
+			break
+		}
 		if len(args) < 3 {
 			fmt.Println("Error: track name and output file required")
-			fmt.Println("Usage: muxic export <track-name> <output-file>")
+			fmt.Println("Usage: muxic export <track-name> <output-file> [--format pcm16|pcm24|float32] [--rate <Hz>] [--channels <n>] [--compression 0-8] [--tag <key>=<value>]...")
+			os.Exit(1)
+		}
+		err = exportTrack(args[1], args[2], options)
+	case "import":
+		if len(args) < 2 {
+			fmt.Println("Error: file name required")
+			fmt.Println("Usage: muxic import <file> [track-name]")
 			os.Exit(1)
 		}
-		err = exportTrack(args[1], args[2])
+		trackName := ""
+		if len(args) >= 3 {
+			trackName = args[2]
+		}
+		err = importTrack(args[1], trackName)
 	case "device":
 		if len(args) >= 2 && args[1] == "select" {
 			if len(args) < 3 {
@@ -100,307 +236,401 @@ func printUsage() {
 	fmt.Print(`Muxic - Multi-Track Audio Recording CLI
 
 Usage:
-  muxic record <track-name>           Record a new track
+  muxic [--project <name|dir>] <command> [arguments]
+
+  muxic record <track-name>           Record a new track (--description, --scene
+                                      and --take are stored as BWF metadata)
   muxic play <track-name>             Play back a track
-  muxic list                          List all recorded tracks
+  muxic monitor [device]              Show input levels without recording
+                                      (--spectrum adds octave bands)
+  muxic list [-l]                     List all recorded tracks (-l adds format)
+  muxic info <track-name|file>        Show format, tags and signal statistics
+  muxic recover                       Repair takes interrupted by a crash
   muxic mix <output-name>             Mix all tracks into one file
-  muxic export <track-name> <file>    Export a track to WAV file
+                                      (--peak <dBFS> or --lufs <LUFS> normalizes it)
+  muxic normalize <track-name>        Normalize a track's peak (--peak, default -1)
+                                      or loudness (--lufs); --output keeps the original
+  muxic loudness <track-name>|--mix   Measure loudness, range and true peak
+                                      (--preset streaming|podcast|broadcast|atsc, --json)
+  muxic export <track-name> <file>    Export a track to a WAV, AIFF or FLAC file
+                                      (--format pcm16|pcm24|float32,
+                                      --rate <Hz> and --channels <n> convert it;
+                                      --compression 0-8 sets the FLAC level)
+  muxic import <file> [track-name]    Import a WAV, AIFF or FLAC file as a track
+  muxic split <track-name>            Split a stereo track into two mono tracks
+  muxic merge <left> <right> <output> Merge two mono tracks into a stereo track
+  muxic track <action> <track-name>   Set gain, pan, mute, solo, name, order or start
+  muxic tag <track-name> [key=value]  Show or edit a track's BWF/iXML metadata
+  muxic tempo [bpm] [beats-per-bar]   Show or set the tempo for bar:beat positions
+  muxic panlaw [0|-3|-4.5|-6]         Show or set the pan law for mono tracks
   muxic device list                   List available audio devices
   muxic device select <name>          Select default recording device
+  muxic project new <name> [dir]      Create a project and make it active
+  muxic project open <name|dir>       Switch to another project
+  muxic project list                  List known projects
+  muxic project close                 Go back to the current directory
   muxic help                          Show this help message
 
+Options:
+  --project <name|dir>                Run the command in another project
+  --dither none|tpdf|shaped           Dither used by record, mix and export when
+                                      reducing bit depth (default tpdf)
+  --quality fast|good|best            Resampling quality for mix, export and
+                                      loudness (default good)
+  --tag <key>=<value>                 Tag a mix or export; repeat for title, artist,
+                                      album, genre, comment, track and date
+
 Examples:
   muxic record vocals
-  muxic record guitar
+  muxic record guitar --description "Rhythm, neck pickup" --take 3
+  muxic monitor --spectrum
   muxic list
   muxic play vocals
+  muxic track gain vocals -3
+  muxic track mute guitar
+  muxic track move bass 9:1
   muxic mix final_mix
+  muxic mix final_mix --lufs -14
+  muxic normalize vocals --peak -1
+  muxic loudness final_mix --preset streaming
+  muxic loudness --mix --json
   muxic export vocals vocals.wav
+  muxic export final_mix master.wav --format pcm16 --dither shaped
+  muxic export final_mix video.wav --rate 48000 --quality best
+  muxic export final_mix mono_check.wav --channels 1
+  muxic export vocals vocals.flac
+  muxic export final_mix master.aiff
+  muxic export final_mix master.flac --tag title="Night Drive" --tag track=3/12
+  muxic import stems/bass.flac bass
+  muxic split overheads
+  muxic tag guitar scene=2 take=4
   muxic devices
 `)
 }
 
+// syncInterval is how often an in-progress recording is checkpointed to disk.
+const syncInterval = time.Second
+
+func tracksDir() string {
+	return filepath.Join(projectRoot, TracksDir)
+}
+
 func ensureTracksDir() error {
-	return os.MkdirAll(TracksDir, 0755)
+	return os.MkdirAll(tracksDir(), 0755)
 }
 
+// trackExtensions are the file types a track can be stored in. New tracks
+// are WAV; AIFF tracks come from collaborators dropping stems into tracks/.
+var trackExtensions = []string{".wav", ".aif", ".aiff", ".aifc"}
+
+// getTrackPath returns the file holding a track, or where a new WAV track of
+// that name would go.
 func getTrackPath(trackName string) string {
-	return filepath.Join(TracksDir, trackName+".wav")
+	base := filepath.Join(tracksDir(), trackName)
+	for _, ext := range trackExtensions {
+		if _, err := os.Stat(base + ext); err == nil {
+			return base + ext
+		}
+	}
+	// Stems copied from other machines often have upper-case suffixes such
+	// as .AIF, which the lookups above miss on case-sensitive file systems
+	if entries, err := os.ReadDir(tracksDir()); err == nil {
+		for _, entry := range entries {
+			if name, ok := trackFileName(entry.Name()); ok && !entry.IsDir() && name == trackName {
+				return filepath.Join(tracksDir(), entry.Name())
+			}
+		}
+	}
+	return base + ".wav"
+}
+
+// trackFileName returns the track name stored in a file, and whether the
+// file's extension, in any case, is one a track can be stored in.
+func trackFileName(fileName string) (string, bool) {
+	ext := filepath.Ext(fileName)
+	if !slices.Contains(trackExtensions, strings.ToLower(ext)) {
+		return "", false
+	}
+	return strings.TrimSuffix(fileName, ext), true
+}
+
+// getPartialTrackPath returns where a take is written while it is being
+// recorded. The suffix keeps it out of list and mix until it is promoted.
+func getPartialTrackPath(trackPath string) string {
+	return trackPath + partialSuffix
 }
 
-func recordTrack(trackName string) error {
+func recordTrack(trackName, dither string, meta recordingMetadata) error {
 	if err := ensureTracksDir(); err != nil {
 		return err
 	}
 
 	trackPath := getTrackPath(trackName)
-
-	if err := ole.CoInitialize(0); err != nil {
-		return err
+	// Takes are always recorded as WAV
+	if isAIFFPath(trackPath) {
+		return fmt.Errorf("Track '%s' is an AIFF file; record under another name", trackName)
 	}
-	defer ole.CoUninitialize()
 
-	// Find the device
 	config, err := LoadConfig()
 	if err != nil {
 		return err
 	}
 
-	var mmde *wca.IMMDeviceEnumerator
-	if err := wca.CoCreateInstance(wca.CLSID_MMDeviceEnumerator, 0, wca.CLSCTX_ALL, wca.IID_IMMDeviceEnumerator, &mmde); err != nil {
+	backend, err := openBackend(config)
+	if err != nil {
 		return err
 	}
-	defer mmde.Release()
+	defer backend.Close()
 
-	var pCollection *wca.IMMDeviceCollection
-	if err := mmde.EnumAudioEndpoints(wca.ECapture, wca.DEVICE_STATE_ACTIVE, &pCollection); err != nil {
-		return err
+	deviceName := ""
+	if config.DefaultDevice != nil {
+		deviceName = *config.DefaultDevice
 	}
-	defer pCollection.Release()
 
-	var count uint32
-	if err := pCollection.GetCount(&count); err != nil {
+	project, err := LoadProject()
+	if err != nil {
 		return err
 	}
-
-	var device *wca.IMMDevice
-	targetName := ""
-	if config.DefaultDevice != nil {
-		targetName = *config.DefaultDevice
-	}
-
-	for i := uint32(0); i < count; i++ {
-		var pEndpoint *wca.IMMDevice
-		if err := pCollection.Item(i, &pEndpoint); err != nil {
-			continue
+	if projectRoot != "." {
+		if meta.Project = projectName(config, projectRoot); meta.Project == "" {
+			meta.Project = filepath.Base(projectRoot)
 		}
+	}
 
-		var pProps *wca.IPropertyStore
-		if err := pEndpoint.OpenPropertyStore(wca.STGM_READ, &pProps); err != nil {
-			pEndpoint.Release()
-			continue
-		}
+	if err := recordFromBackend(backend, deviceName, trackPath, storageFormatName(config, project), dither, &meta, os.Stdin); err != nil {
+		return err
+	}
 
-		var pv wca.PROPVARIANT
-		if err := pProps.GetValue(&wca.PKEY_Device_FriendlyName, &pv); err != nil {
-			pProps.Release()
-			pEndpoint.Release()
-			continue
-		}
-		name := pv.String()
-		pProps.Release()
+	fmt.Printf("[OK] Track '%s' saved to %s\n", trackName, trackPath)
+	return nil
+}
 
-		if targetName != "" && name == targetName {
-			device = pEndpoint
-			fmt.Printf("Using device: %s\n", name)
-			break
-		} else if targetName == "" {
-			// improved default logic could go here, for now take first
-			device = pEndpoint
-			fmt.Printf("Using default device: %s\n", name)
-			break
-		}
-		pEndpoint.Release()
+// recordFromBackend captures from the device until a line is read from input
+// or the stream runs dry, then saves the take to trackPath in the named
+// storage format, dithering if that loses resolution. Unless meta is nil,
+// the take carries bext and iXML metadata stamped with its start time.
+func recordFromBackend(backend AudioBackend, deviceName, trackPath, storage, dither string, meta *recordingMetadata, input io.Reader) error {
+	stream, err := backend.OpenCapture(deviceName)
+	if err != nil {
+		return err
 	}
+	defer stream.Close()
 
-	if device == nil {
-		return fmt.Errorf("device not found")
+	if deviceName != "" {
+		fmt.Printf("Using device: %s\n", stream.DeviceName())
+	} else {
+		fmt.Printf("Using default device: %s\n", stream.DeviceName())
 	}
-	defer device.Release()
 
-	// Initialize Audio Client
-	var audioClient *wca.IAudioClient
-	if err := device.Activate(wca.IID_IAudioClient, wca.CLSCTX_ALL, nil, &audioClient); err != nil {
+	wfx := stream.Format()
+	fmt.Printf("Recording format: %d Hz, %d channels, %s\n", wfx.SampleRate, wfx.Channels, describeEncoding(wfx))
+
+	// WASAPI shared mode commonly delivers 32-bit float whatever the track
+	// is stored as, so each chunk is converted on its way to disk.
+	fileFormat, err := storageFormat(storage, wfx.Channels, wfx.SampleRate)
+	if err != nil {
 		return err
 	}
-	defer audioClient.Release()
-
-	var wfx *wca.WAVEFORMATEX
-	if err := audioClient.GetMixFormat(&wfx); err != nil {
+	convert, err := newSampleConverter(wfx, fileFormat, dither)
+	if err != nil {
 		return err
 	}
-	defer ole.CoTaskMemFree(uintptr(unsafe.Pointer(wfx)))
+	if convert != nil {
+		fmt.Printf("Converting %s to %s while recording%s\n", describeEncoding(wfx), describeEncoding(fileFormat), describeDither(wfx, fileFormat, dither))
+	}
 
-	// Initialize in Shared Mode
-	if err := audioClient.Initialize(wca.AUDCLNT_SHAREMODE_SHARED, 0, 10000000, 0, wfx, nil); err != nil {
+	fmt.Println("Press Enter to start recording...")
+	reader := bufio.NewReader(input)
+	reader.ReadString('\n')
+
+	// Record into a temp file and only promote it once the take is complete.
+	// If muxic dies on the way, `muxic recover` can repair and promote it.
+	partialPath := getPartialTrackPath(trackPath)
+	var metadata []byte
+	if meta != nil {
+		trackName := strings.TrimSuffix(filepath.Base(trackPath), filepath.Ext(trackPath))
+		if metadata, err = encodeMetadataChunks(meta.stamp(trackName, fileFormat, time.Now())); err != nil {
+			return err
+		}
+	}
+	writer, err := createLongWavWriter(partialPath, fileFormat, metadata)
+	if err != nil {
+		return err
+	}
+	if err := captureTake(stream, reader, writer, convert); err != nil {
+		writer.Close()
+		return fmt.Errorf("%v (partial take kept in %s, run 'muxic recover')", err, partialPath)
+	}
+	if err := writer.Close(); err != nil {
 		return err
 	}
+	return os.Rename(partialPath, trackPath)
+}
 
-	var captureClient *wca.IAudioCaptureClient
-	if err := audioClient.GetService(wca.IID_IAudioCaptureClient, &captureClient); err != nil {
+// captureTake streams captured chunks into the writer until a line is read
+// from input, the process is interrupted or the stream runs dry. Chunks are
+// passed through convert first unless it is nil.
+func captureTake(stream CaptureStream, input *bufio.Reader, writer *WavWriter, convert func([]byte) ([]byte, error)) error {
+	wfx := stream.Format()
+	meter := newMeter(int(wfx.Channels), wfx.SampleRate)
+	lastSync := time.Now()
+
+	write := func(chunk []byte, samples []float64) error {
+		var err error
+		if convert != nil {
+			if chunk, err = convert(chunk); err != nil {
+				return err
+			}
+		}
+		_, err = writer.Write(chunk)
 		return err
 	}
-	defer captureClient.Release()
+	// Checkpoint the header so a crash loses at most a second
+	sync := func() error {
+		if time.Since(lastSync) < syncInterval {
+			return nil
+		}
+		lastSync = time.Now()
+		return writer.Sync()
+	}
+	return captureStream(stream, input, "[RECORDING] Recording... (Press Enter to stop)", meter, nil, write, sync)
+}
 
-	fmt.Printf("Recording format: %d Hz, %d channels, %d bits\n", wfx.NSamplesPerSec, wfx.NChannels, wfx.WBitsPerSample)
-	fmt.Println("Press Enter to start recording...")
-	bufio.NewReader(os.Stdin).ReadString('\n')
+// captureStream runs a started capture with a live meter until a line is
+// read from input, the process is interrupted or the stream runs dry. Each
+// chunk is measured and handed to handle along with its decoded samples;
+// tick runs whenever the display is redrawn. A nil spectrum is not shown.
+func captureStream(stream CaptureStream, input *bufio.Reader, status string, meter *Meter, spectrum *Spectrum, handle func([]byte, []float64) error, tick func() error) error {
+	wfx := stream.Format()
 
-	if err := audioClient.Start(); err != nil {
+	// Ctrl-C ends the capture like Enter does, so a take still gets finalized
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(interrupt)
+
+	if err := stream.Start(); err != nil {
 		return err
 	}
-	fmt.Println("[RECORDING] Recording... (Press Enter to stop)")
+	fmt.Println(status)
 
-	// Visualizer setup
 	ticker := time.NewTicker(50 * time.Millisecond)
 	defer ticker.Stop()
-	var currentAmplitude float64
+	var display meterDisplay
 
-	done := make(chan bool)
+	done := make(chan bool, 1)
 	go func() {
-		bufio.NewReader(os.Stdin).ReadString('\n')
-		done <- true
+		// Only a real line stops the capture. EOF on input (e.g. when run
+		// from a script) leaves it running until the stream itself ends.
+		if _, err := input.ReadString('\n'); err == nil {
+			done <- true
+		}
 	}()
 
-	var audioData []byte
 	var isCapturing = true
 
 	for isCapturing {
 		select {
 		case <-done:
 			isCapturing = false
-			fmt.Println() // Newline after visualizer
+			fmt.Println() // Newline after the meter
+		case <-interrupt:
+			isCapturing = false
+			fmt.Println("\nInterrupted, finalizing...")
 		case <-ticker.C:
-			drawVisualizer(currentAmplitude)
-		default:
-			var buffer *byte
-			var framesAvailable uint32
-			var flags uint32
-			var devicePosition uint64
-			var qpcPosition uint64
-
-			// Get buffer
-			if err := captureClient.GetBuffer(&buffer, &framesAvailable, &flags, &devicePosition, &qpcPosition); err != nil {
-				if err.Error() == "AUDCLNT_S_BUFFER_EMPTY" { // Not really an error, just empty
-					time.Sleep(10 * time.Millisecond)
-					continue
+			width := terminalWidth()
+			rows := formatMeter(meter.Levels(), width)
+			if spectrum != nil {
+				rows = append(rows, formatSpectrum(spectrum.Bands(), wfx.SampleRate, width)...)
+			}
+			display.draw(rows)
+			if tick != nil {
+				if err := tick(); err != nil {
+					stream.Stop()
+					return err
 				}
-				// Retry or fail? For now sleep and continue
+			}
+		default:
+			chunk, err := stream.Read()
+			if err == io.EOF {
+				isCapturing = false
+				fmt.Println()
+				continue
+			}
+			if err != nil {
+				stream.Stop()
+				return err
+			}
+			if len(chunk) == 0 {
 				time.Sleep(10 * time.Millisecond)
 				continue
 			}
 
-			if framesAvailable > 0 {
-				bytesToCopy := int(framesAvailable) * int(wfx.NBlockAlign)
-				chunk := make([]byte, bytesToCopy)
-
-				// Unsafe copy from COM buffer
-				// Safety: buffer is valid until ReleaseBuffer
-				src := unsafe.Slice(buffer, bytesToCopy)
-				copy(chunk, src)
-				copy(chunk, src)
-				audioData = append(audioData, chunk...)
-
-				// Calculate amplitude for visualizer
-				currentAmplitude = calculateAmplitude(chunk, wfx.WBitsPerSample)
+			samples, err := decodeSamples(chunk, wfx)
+			if err != nil {
+				stream.Stop()
+				return err
+			}
+			meter.Update(samples)
+			if spectrum != nil {
+				spectrum.Update(samples)
 			}
 
-			if err := captureClient.ReleaseBuffer(framesAvailable); err != nil {
-				return err
+			if handle != nil {
+				if err := handle(chunk, samples); err != nil {
+					stream.Stop()
+					return err
+				}
 			}
 
-			// Small sleep to avoid busy loop if buffer is small?
-			// Actually GetBuffer returns quickly if available.
-			// We effectively poll.
+			// We effectively poll; a short sleep keeps the loop from spinning.
 			time.Sleep(1 * time.Millisecond)
 		}
 	}
 
-	if err := audioClient.Stop(); err != nil {
-		return err
-	}
-
-	// Convert if necessary
-	// WASAPI Audio Client commonly returns IEEE Float (32-bit) in Shared Mode.
-	// We want to save as standard PCM 16-bit for best compatibility.
-	var finalData []byte
-	var finalWfx *wca.WAVEFORMATEX
-
-	// Simple check: 32 bits usually implies Float for WASAPI shared mode
-	if wfx.WBitsPerSample == 32 {
-		fmt.Println("Converting 32-bit Float to 16-bit PCM...")
-
-		// Interpret byte slice as float32 slice
-		// Note: audioData is raw bytes. We need to handle endianness, but standard Windows is Little Endian.
-		// Go's unsafe cast helps if we assume host is LE.
-		numSamples := len(audioData) / 4
-		pcmData := make([]byte, numSamples*2)
-
-		// Create a reader to simplify binary reading
-		// Or unsafe slice for speed? Let's use unsafe for efficiency if we are careful.
-		// float32s := unsafe.Slice((*float32)(unsafe.Pointer(&audioData[0])), numSamples) // Unsafe if len=0
-
-		// Safer loop
-		for i := 0; i < numSamples; i++ {
-			// Read float32 (LE)
-			// Manual bit conversion to avoid unsafe alignment issues if any (buffer from Append should be aligned?)
-			// Actually, just using encoding/binary is safer slightly slower.
-
-			// Let's use Unsafe for "interpretation" but careful.
-			// Ideally we use a loop with math.
-
-			// Extract 4 bytes
-			b1 := audioData[i*4]
-			b2 := audioData[i*4+1]
-			b3 := audioData[i*4+2]
-			b4 := audioData[i*4+3]
-			valbits := uint32(b1) | uint32(b2)<<8 | uint32(b3)<<16 | uint32(b4)<<24
-			fVal := *(*float32)(unsafe.Pointer(&valbits))
-
-			// Clamp and Convert
-			if fVal > 1.0 {
-				fVal = 1.0
-			}
-			if fVal < -1.0 {
-				fVal = -1.0
-			}
-			iVal := int16(fVal * 32767)
+	return stream.Stop()
+}
 
-			// Write int16 (LE)
-			pcmData[i*2] = byte(iVal)
-			pcmData[i*2+1] = byte(iVal >> 8)
-		}
+// isFloatFormat reports whether samples are IEEE float. WASAPI shared mode
+// wraps its 32-bit float mix format in EXTENSIBLE, so that counts too.
+func isFloatFormat(wfx *WaveFormat) bool {
+	return wfx.Encoding() == waveFormatIEEEFloat
+}
 
-		finalData = pcmData
+func playTrack(trackName string) error {
+	trackPath := getTrackPath(trackName)
 
-		// Create new WFX for PCM 16-bit
-		finalWfx = &wca.WAVEFORMATEX{
-			WFormatTag:      1, // WAVE_FORMAT_PCM
-			NChannels:       wfx.NChannels,
-			NSamplesPerSec:  wfx.NSamplesPerSec,
-			NAvgBytesPerSec: wfx.NSamplesPerSec * uint32(wfx.NChannels) * 2,
-			NBlockAlign:     wfx.NChannels * 2,
-			WBitsPerSample:  16,
-			CbSize:          0,
-		}
-	} else {
-		finalData = audioData
-		finalWfx = wfx
+	if _, err := os.Stat(trackPath); os.IsNotExist(err) {
+		return fmt.Errorf("Track '%s' not found", trackName)
 	}
 
-	if err := saveWavFile(trackPath, finalData, finalWfx); err != nil {
+	track, err := loadTrack(trackPath)
+	if err != nil {
 		return err
 	}
 
-	fmt.Printf("[OK] Track '%s' saved to %s\n", trackName, trackPath)
-	return nil
-}
-
-func playTrack(trackName string) error {
-	trackPath := getTrackPath(trackName)
+	config, err := LoadConfig()
+	if err != nil {
+		return err
+	}
 
-	if _, err := os.Stat(trackPath); os.IsNotExist(err) {
-		return fmt.Errorf("Track '%s' not found", trackName)
+	backend, err := openBackend(config)
+	if err != nil {
+		return err
 	}
+	defer backend.Close()
 
 	fmt.Printf("[PLAYING] Playing track '%s'...\n", trackName)
-	fmt.Println("(Playback simulation - audio playback not yet implemented)")
+	if err := playBuffer(backend, "", track); err != nil {
+		return err
+	}
 	fmt.Println("[OK] Playback complete")
 	return nil
 }
 
-func listTracks() error {
-	entries, err := os.ReadDir(TracksDir)
+// listTracks prints the project's tracks. The long form adds each track's
+// duration and format.
+func listTracks(long bool) error {
+	names, err := recordedTrackNames()
 	if os.IsNotExist(err) {
 		fmt.Println("No tracks directory found. Record a track first!")
 		return nil
@@ -409,22 +639,38 @@ func listTracks() error {
 		return err
 	}
 
+	project, err := LoadProject()
+	if err != nil {
+		return err
+	}
+
 	fmt.Println("[TRACKS] Recorded Tracks:")
+	if projectRoot != "." {
+		fmt.Printf("Project: %s\n", projectRoot)
+	}
 	fmt.Println("==================")
 
+	tracks := project.Arrange(names)
 	count := 0
-	for _, entry := range entries {
-		if !entry.IsDir() && strings.HasSuffix(entry.Name(), ".wav") {
-			count++
-			nameWithoutExt := strings.TrimSuffix(entry.Name(), ".wav")
+	for _, track := range tracks {
+		info, err := os.Stat(getTrackPath(track.Name))
+		if err != nil {
+			continue
+		}
+		count++
+		sizeKb := info.Size() / 1024
 
-			info, err := entry.Info()
+		title := track.Name
+		if track.DisplayName != "" {
+			title = fmt.Sprintf("%s [%s]", track.DisplayName, track.Name)
+		}
+		fmt.Printf("  %d. %s (%d KB)%s\n", count, title, sizeKb, formatTrackSettings(track))
+		if long {
+			description, err := describeTrackFormat(getTrackPath(track.Name))
 			if err != nil {
-				continue
+				description = err.Error()
 			}
-			sizeKb := info.Size() / 1024
-
-			fmt.Printf("  %d. %s (%d KB)\n", count, nameWithoutExt, sizeKb)
+			fmt.Printf("     %s\n", description)
 		}
 	}
 
@@ -436,340 +682,406 @@ func listTracks() error {
 	return nil
 }
 
-func mixTracks(outputName string) error {
-	if err := ensureTracksDir(); err != nil {
-		return err
+// formatTrackSettings describes any mix settings that differ from the defaults.
+func formatTrackSettings(track ProjectTrack) string {
+	var settings []string
+	if track.GainDB != 0 {
+		settings = append(settings, fmt.Sprintf("%+.1f dB", track.GainDB))
+	}
+	if track.Pan != 0 {
+		settings = append(settings, "pan "+formatPan(track.Pan))
+	}
+	if track.Offset != 0 {
+		settings = append(settings, fmt.Sprintf("starts %s", formatTimestamp(time.Duration(track.Offset*float64(time.Second)))))
 	}
+	if track.Mute {
+		settings = append(settings, "MUTE")
+	}
+	if track.Solo {
+		settings = append(settings, "SOLO")
+	}
+	if len(settings) == 0 {
+		return ""
+	}
+	return " " + strings.Join(settings, ", ")
+}
 
-	entries, err := os.ReadDir(TracksDir)
-	if os.IsNotExist(err) {
-		fmt.Println("Error: No tracks found to mix")
+// mixTracks mixes the audible tracks into outputName. With a target the mix
+// is normalized to it; otherwise it is only turned down if it would clip.
+// Any tags are written into the mix file.
+func mixTracks(outputName string, target *normalizeTarget, quality, dither string, tags Tags) error {
+	project, err := LoadProject()
+	if err != nil {
 		return err
 	}
+
+	config, err := LoadConfig()
 	if err != nil {
 		return err
 	}
 
 	fmt.Printf("[MIXING] Mixing tracks into '%s'...\n", outputName)
 
-	trackCount := 0
-	for _, entry := range entries {
-		if !entry.IsDir() && strings.HasSuffix(entry.Name(), ".wav") {
-			trackCount++
-			fmt.Printf("  + %s\n", entry.Name())
-		}
+	outputPath := getTrackPath(outputName)
+	if err := checkTaggable(outputPath, tags); err != nil {
+		return err
 	}
-
-	if trackCount == 0 {
+	// Never feed a previous take of this mix back into itself
+	sources, err := projectSources(outputName, os.Stdout)
+	if err != nil {
+		return err
+	}
+	if len(sources) == 0 {
 		return fmt.Errorf("No tracks found to mix")
 	}
 
-	outputPath := getTrackPath(outputName)
-	// Use silence for mixing as placeholder
-	mixedData := generateSilence()
+	mix, err := mixFiles(sources, quality)
+	if err != nil {
+		return err
+	}
 
-	// Default mix format (PCM 44.1kHz 16-bit Stereo)
-	wfx := &wca.WAVEFORMATEX{
-		WFormatTag:      1, // WAVE_FORMAT_PCM
-		NChannels:       2,
-		NSamplesPerSec:  44100,
-		NAvgBytesPerSec: 44100 * 4,
-		NBlockAlign:     4,
-		WBitsPerSample:  16,
-		CbSize:          0,
+	if target != nil {
+		gain, err := normalizeBuffer(mix, *target)
+		if err != nil {
+			return fmt.Errorf("cannot normalize the mix: %v", err)
+		}
+		fmt.Printf("  Normalized to %s: %+.2f dB\n", *target, gainToDB(gain))
+		warnIfClipping(mix)
+	} else if gain := mix.applyHeadroom(); gain < 1.0 {
+		fmt.Printf("  Mix exceeded full scale, reduced by %.1f dB to avoid clipping\n", gainToDB(gain))
 	}
 
-	if err := saveWavFile(outputPath, mixedData, wfx); err != nil {
+	// Mix down to the storage format at the tracks' sample rate
+	wfx, err := storageFormat(storageFormatName(config, project), uint16(mix.Channels), mix.SampleRate)
+	if err != nil {
 		return err
 	}
-
-	fmt.Printf("[OK] Mixed %d tracks into %s\n", trackCount, outputPath)
-	return nil
-}
-
-func generateSilence() []byte {
-	// Generate 2 seconds of silence as placeholder
-	durationSeconds := 2
-	numSamples := SampleRate * durationSeconds * Channels
-	dataSize := numSamples * (BitsPerSample / 8)
-	return make([]byte, dataSize)
-}
-
-func exportTrack(trackName, outputFile string) error {
-	trackPath := getTrackPath(trackName)
-
-	srcFile, err := os.Open(trackPath)
-	if os.IsNotExist(err) {
-		return fmt.Errorf("Track '%s' not found", trackName)
+	// The mix is summed at full precision, so anything but float loses resolution
+	var ditherer *Ditherer
+	if !isFloatFormat(wfx) {
+		if ditherer, err = newDitherer(dither, mix.Channels, defaultDitherSeed); err != nil {
+			return err
+		}
 	}
+	audioData, err := encodeSamples(mix.Samples, wfx, ditherer)
 	if err != nil {
 		return err
 	}
-	defer srcFile.Close()
-
-	destFile, err := os.Create(outputFile)
-	if err != nil {
+	if err := saveAudioFile(outputPath, audioData, wfx); err != nil {
 		return err
 	}
-	defer destFile.Close()
-
-	if _, err := io.Copy(destFile, srcFile); err != nil {
+	if err := tagAudioFile(outputPath, tags); err != nil {
 		return err
 	}
 
-	fmt.Printf("[OK] Exported '%s' to %s\n", trackName, outputFile)
+	fmt.Printf("[OK] Mixed %d tracks into %s (%.1fs at %d Hz)\n", len(sources), outputPath, mix.Duration().Seconds(), mix.SampleRate)
 	return nil
 }
 
-type AudioDevice struct {
-	Name         string `json:"Name"`
-	Manufacturer string `json:"Manufacturer"`
-}
-
-func listDevices() error {
-	if err := ole.CoInitialize(0); err != nil {
-		return err
-	}
-	defer ole.CoUninitialize()
-
-	var mmde *wca.IMMDeviceEnumerator
-	if err := wca.CoCreateInstance(wca.CLSID_MMDeviceEnumerator, 0, wca.CLSCTX_ALL, wca.IID_IMMDeviceEnumerator, &mmde); err != nil {
-		return err
-	}
-	defer mmde.Release()
-
-	var pCollection *wca.IMMDeviceCollection
-	if err := mmde.EnumAudioEndpoints(wca.ECapture, wca.DEVICE_STATE_ACTIVE, &pCollection); err != nil {
-		return err
-	}
-	defer pCollection.Release()
-
-	var count uint32
-	if err := pCollection.GetCount(&count); err != nil {
-		return err
+// projectSources returns the audible tracks of the project in mix order,
+// skipping the named track. Each track's inclusion is reported to w.
+func projectSources(exclude string, w io.Writer) ([]mixSource, error) {
+	if err := ensureTracksDir(); err != nil {
+		return nil, err
 	}
 
-	config, err := LoadConfig()
+	names, err := recordedTrackNames()
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	fmt.Println("[DEVICES] Audio Capture Devices:")
-	if config.DefaultDevice != nil {
-		fmt.Printf("Current Default: %s\n", *config.DefaultDevice)
-	} else {
-		fmt.Println("Current Default: (none selected)")
+	project, err := LoadProject()
+	if err != nil {
+		return nil, err
 	}
-	fmt.Println("======================")
 
-	if count == 0 {
-		fmt.Println("No audio capture devices found.")
-		return nil
-	}
+	tracks := project.Arrange(names)
+	anySolo := anySoloed(tracks)
 
-	for i := uint32(0); i < count; i++ {
-		var pEndpoint *wca.IMMDevice
-		if err := pCollection.Item(i, &pEndpoint); err != nil {
+	var sources []mixSource
+	for _, track := range tracks {
+		if track.Name == exclude {
 			continue
 		}
-		defer pEndpoint.Release()
-
-		var pProps *wca.IPropertyStore
-		if err := pEndpoint.OpenPropertyStore(wca.STGM_READ, &pProps); err != nil {
+		if !track.Audible(anySolo) {
+			reason := "not soloed"
+			if track.Mute {
+				reason = "muted"
+			}
+			fmt.Fprintf(w, "  - %s (%s)\n", track.Title(), reason)
 			continue
 		}
-		defer pProps.Release()
+		sources = append(sources, mixSource{
+			Path:   getTrackPath(track.Name),
+			GainDB: track.GainDB,
+			Pan:    track.Pan,
+			PanLaw: project.PanLawDB(),
+			Offset: track.Offset,
+		})
+		fmt.Fprintf(w, "  + %s%s\n", track.Title(), formatTrackSettings(track))
+	}
+	return sources, nil
+}
 
-		var pv wca.PROPVARIANT
-		if err := pProps.GetValue(&wca.PKEY_Device_FriendlyName, &pv); err != nil {
-			continue
-		}
-		deviceName := pv.String()
+// exportOptions describes how an exported copy differs from the track. The
+// zero Format, Rate and Channels keep the track's own.
+type exportOptions struct {
+	Format   string
+	Rate     uint32
+	Channels int
+	Quality  string
+	Dither   string
+	// Compression is the FLAC compression level, used for .flac files
+	Compression int
+	// Tags are written into the exported file
+	Tags Tags
+	// PanLaw is the project's pan law in dB, which places a mono track in
+	// the centre when it is converted to stereo
+	PanLaw float64
+}
 
-		indicator := " "
-		if config.DefaultDevice != nil && *config.DefaultDevice == deviceName {
-			indicator = "*"
-		}
-		fmt.Printf("%s %d. %s\n", indicator, i+1, deviceName)
+// exportTrack copies a track to outputFile, converting it on the way if the
+// options ask for another format, sample rate or channel count, or the file
+// name asks for another file type than the track's.
+func exportTrack(trackName, outputFile string, options exportOptions) error {
+	trackPath := getTrackPath(trackName)
+	if err := checkTaggable(outputFile, options.Tags); err != nil {
+		return err
 	}
 
-	return nil
-}
+	sameType := !isFLACPath(outputFile) && isAIFFPath(outputFile) == isAIFFPath(trackPath)
+	if options.Format != "" || options.Rate != 0 || options.Channels != 0 || !sameType {
+		project, err := LoadProject()
+		if err != nil {
+			return err
+		}
+		options.PanLaw = project.PanLawDB()
+		if err := convertTrackFile(trackPath, outputFile, options); err != nil {
+			if os.IsNotExist(err) {
+				return fmt.Errorf("Track '%s' not found", trackName)
+			}
+			return err
+		}
+		if err := tagAudioFile(outputFile, options.Tags); err != nil {
+			return err
+		}
+		fmt.Printf("[OK] Exported '%s' to %s\n", trackName, outputFile)
+		return nil
+	}
 
-func selectDevice(deviceName string) error {
-	config, err := LoadConfig()
+	srcFile, err := os.Open(trackPath)
+	if os.IsNotExist(err) {
+		return fmt.Errorf("Track '%s' not found", trackName)
+	}
 	if err != nil {
 		return err
 	}
+	defer srcFile.Close()
 
-	config.DefaultDevice = &deviceName
-	if err := config.Save(); err != nil {
+	destFile, err := os.Create(outputFile)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(destFile, srcFile)
+	if closeErr := destFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	if err := tagAudioFile(outputFile, options.Tags); err != nil {
 		return err
 	}
 
-	fmt.Printf("Selected default recording device: '%s'\n", deviceName)
+	fmt.Printf("[OK] Exported '%s' to %s\n", trackName, outputFile)
 	return nil
 }
 
-func saveWavFile(path string, audioData []byte, wfx *wca.WAVEFORMATEX) error {
-	f, err := os.Create(path)
+// convertTrackFile writes a copy of a track file in another storage format,
+// at another sample rate or with another channel count, as FLAC or AIFF when
+// the output path ends in .flac or .aif, .aiff or .aifc.
+func convertTrackFile(inputPath, outputPath string, options exportOptions) error {
+	wfx, audioData, err := readAudioFile(inputPath)
 	if err != nil {
 		return err
 	}
-	defer f.Close()
 
-	dataSize := uint32(len(audioData))
-	fileSize := uint32(36 + dataSize)
-
-	// WAV header
-	if _, err := f.Write([]byte("RIFF")); err != nil {
-		return err
+	rate := wfx.SampleRate
+	if options.Rate != 0 {
+		rate = options.Rate
 	}
-	if err := binary.Write(f, binary.LittleEndian, fileSize); err != nil {
-		return err
+	channels := wfx.Channels
+	if options.Channels != 0 {
+		channels = uint16(options.Channels)
 	}
-	if _, err := f.Write([]byte("WAVE")); err != nil {
-		return err
+	outFormat := formatLike(wfx, channels, rate)
+	if options.Format != "" {
+		if outFormat, err = storageFormat(options.Format, channels, rate); err != nil {
+			return err
+		}
+	} else if isFLACPath(outputPath) && !fitsFLAC(outFormat) {
+		// FLAC has no float or 32-bit samples; 24-bit keeps everything audible
+		fmt.Printf("FLAC stores integer samples of up to 24 bits, converting %s to 24-bit\n", describeEncoding(outFormat))
+		outFormat = newPCMFormat(channels, rate, 24)
 	}
 
-	// fmt chunk
-	if _, err := f.Write([]byte("fmt ")); err != nil {
+	// Only the encoding changes, so convert chunk-wise without touching the
+	// samples any more than that needs
+	if rate == wfx.SampleRate && channels == wfx.Channels {
+		convert, err := newSampleConverter(wfx, outFormat, options.Dither)
+		if err != nil {
+			return err
+		}
+		if convert != nil {
+			if audioData, err = convert(audioData); err != nil {
+				return err
+			}
+		}
+		return saveExport(outputPath, audioData, outFormat, options)
+	}
+
+	samples, err := decodeSamples(audioData, wfx)
+	if err != nil {
 		return err
 	}
-	// Check for extensible format
-	if wfx.WFormatTag == 0xFFFE /* WAVE_FORMAT_EXTENSIBLE */ {
-		if err := binary.Write(f, binary.LittleEndian, uint32(40)); err != nil {
+	buf := &AudioBuffer{SampleRate: wfx.SampleRate, Channels: int(wfx.Channels), Samples: samples}
+	if rate != wfx.SampleRate {
+		fmt.Printf("Resampling %d Hz to %d Hz (%s quality)\n", wfx.SampleRate, rate, options.Quality)
+		if buf, err = buf.resample(rate, options.Quality); err != nil {
 			return err
 		}
-	} else {
-		if err := binary.Write(f, binary.LittleEndian, uint32(16)); err != nil {
+	}
+	if channels != wfx.Channels {
+		fmt.Printf("Converting %d channels to %d\n", wfx.Channels, channels)
+		if buf.Samples, err = buf.toChannels(int(channels), options.PanLaw); err != nil {
 			return err
 		}
+		buf.Channels = int(channels)
 	}
 
-	if err := binary.Write(f, binary.LittleEndian, wfx.WFormatTag); err != nil {
-		return err
+	// Resampled and downmixed samples fall between the integer steps of any
+	// PCM format
+	var ditherer *Ditherer
+	if !isFloatFormat(outFormat) {
+		if ditherer, err = newDitherer(options.Dither, buf.Channels, defaultDitherSeed); err != nil {
+			return err
+		}
 	}
-	if err := binary.Write(f, binary.LittleEndian, wfx.NChannels); err != nil {
+	if audioData, err = encodeSamples(buf.Samples, outFormat, ditherer); err != nil {
 		return err
 	}
-	if err := binary.Write(f, binary.LittleEndian, wfx.NSamplesPerSec); err != nil {
+	return saveExport(outputPath, audioData, outFormat, options)
+}
+
+// saveExport writes exported audio as FLAC, AIFF or WAV by the file
+// extension.
+func saveExport(path string, audioData []byte, wfx *WaveFormat, options exportOptions) error {
+	if isFLACPath(path) {
+		return saveFLACFile(path, audioData, wfx, options.Compression)
+	}
+	return saveAudioFile(path, audioData, wfx)
+}
+
+// importTrack brings an audio file into the project as a new track, named
+// after the file unless a name is given. The audio is stored as WAV in its
+// own resolution, so nothing is lost.
+func importTrack(file, trackName string) error {
+	if err := ensureTracksDir(); err != nil {
 		return err
 	}
-	if err := binary.Write(f, binary.LittleEndian, wfx.NAvgBytesPerSec); err != nil {
+	if trackName == "" {
+		trackName = strings.TrimSuffix(filepath.Base(file), filepath.Ext(file))
+	}
+	trackPath := getTrackPath(trackName)
+	if _, err := os.Stat(trackPath); err == nil {
+		return fmt.Errorf("Track '%s' already exists", trackName)
+	}
+
+	wfx, audioData, err := readAudioFile(file)
+	if err != nil {
 		return err
 	}
-	if err := binary.Write(f, binary.LittleEndian, wfx.NBlockAlign); err != nil {
+	meta, err := carriedMetadata(file, nil)
+	if err != nil {
 		return err
 	}
-	if err := binary.Write(f, binary.LittleEndian, wfx.WBitsPerSample); err != nil {
+	if err := saveWavFile(trackPath, audioData, wfx, meta); err != nil {
 		return err
 	}
+	fmt.Printf("[OK] Imported %s as '%s' (%d Hz, %d channels, %s)\n", file, trackName, wfx.SampleRate, wfx.Channels, describeEncoding(wfx))
+	return nil
+}
 
-	if wfx.WFormatTag == 0xFFFE /* WAVE_FORMAT_EXTENSIBLE */ {
-		if err := binary.Write(f, binary.LittleEndian, wfx.CbSize); err != nil { // cbSize
-			return err
-		}
-		// Write the rest of WAVEFORMATEXTENSIBLE if needed?
-		// wca.WAVEFORMATEX doesn't expose the extra bytes directly as a struct field easily accessible here
-		// without unsafe casting, but standard WAVE header is usually enough.
-		// However, for EXTENSIBLE, we need to write the helper bytes.
-		// The Go struct wca.WAVEFORMATEX ends at CbSize.
-		// To keep it simple, we might just write the standard 16 + cbSize bytes if accessible,
-		// but Go definition is tricky.
-		// NOTE: wca.WAVEFORMATEX in go-wca seems to only have the basic fields + CbSize?
-		// Checking definition of WAVEFORMATEX: it has cbSize at end.
-		// If 16-bit PCM, cbSize is 0 or ignored.
-		// If Float, it might be WAVE_FORMAT_IEEE_FLOAT (3) or EXTENSIBLE (0xFFFE).
-
-		// Let's assume for now we write basic header.
-		// To be strictly correct for EXTENSIBLE we need subformat.
-		// But let's check what GetMixFormat returns usually.
-		// Often it returns EXTENSIBLE for shared mode.
-		// If we just write the fmt chunk based on what we have:
-
-		// Actually, let's keep it simple: Write what we have.
-		// If it is extensible, we should ideally write the GUIDs.
-		// But maybe just writing the data as is works for many players.
-
-		// Let's rely on standard PCM or Float tags if possible.
-		// If GetMixFormat returns EXTENSIBLE, we might want to Convert to PCM/Float,
-		// or just write the header as is.
-
-		// We will write the additional bytes for Extensible if we can access them.
-		// Since we can't easily, let's just write the basic fields and hope `CbSize` covers it if we write extra?
-		// No, `CbSize` tells how many extra bytes follow. We don't have them in the struct `wfx` easily.
-		// BUT `wfx` is a pointer to the start of the memory. We can read `cbSize` bytes after the struct.
-
-		cbSize := wfx.CbSize
-		if cbSize > 0 {
-			// Read extra bytes
-			extraBytes := unsafe.Slice((*byte)(unsafe.Pointer(uintptr(unsafe.Pointer(wfx))+unsafe.Sizeof(*wfx))), cbSize)
-			if _, err := f.Write(extraBytes); err != nil {
-				return err
-			}
-		}
-	} else {
-		// If not extensible, and cbSize is present (e.g. for some compressed formats), handle it?
-		// Usually for PCM cbSize is ignored/not present in basics, but we wrote 16 for chunk size.
-		// If wFormatTag != PCM, chunk size might be larger.
-		// For simplicity, let's write 16 bytes for PCM/Float if we force it?
-		// But we are using the MixFormat.
+// parseChannelCount reads a channel count for export, e.g. 1 or 2.
+func parseChannelCount(value string) (int, error) {
+	channels, err := strconv.Atoi(value)
+	if err != nil || channels < 1 || channels > 8 {
+		return 0, fmt.Errorf("invalid channel count '%s'", value)
+	}
+	return channels, nil
+}
 
-		// We wrote 16 as chunk size above for non-extensible.
-		// So we shouldn't write cbSize or extra bytes.
+// parseSampleRate reads a sample rate in Hz, e.g. 48000.
+func parseSampleRate(value string) (uint32, error) {
+	rate, err := strconv.ParseUint(value, 10, 32)
+	if err != nil || rate < 1000 || rate > 768000 {
+		return 0, fmt.Errorf("invalid sample rate '%s'", value)
 	}
+	return uint32(rate), nil
+}
 
-	// data chunk
-	if _, err := f.Write([]byte("data")); err != nil {
+func listDevices() error {
+	config, err := LoadConfig()
+	if err != nil {
 		return err
 	}
-	if err := binary.Write(f, binary.LittleEndian, dataSize); err != nil {
+
+	backend, err := openBackend(config)
+	if err != nil {
 		return err
 	}
-	if _, err := f.Write(audioData); err != nil {
+	defer backend.Close()
+
+	devices, err := backend.Devices()
+	if err != nil {
 		return err
 	}
 
-	return nil
-}
+	fmt.Println("[DEVICES] Audio Capture Devices:")
+	if config.DefaultDevice != nil {
+		fmt.Printf("Current Default: %s\n", *config.DefaultDevice)
+	} else {
+		fmt.Println("Current Default: (none selected)")
+	}
+	fmt.Println("======================")
 
-func calculateAmplitude(data []byte, bitsPerSample uint16) float64 {
-	var maxVal float64
+	if len(devices) == 0 {
+		fmt.Println("No audio capture devices found.")
+		return nil
+	}
 
-	if bitsPerSample == 16 {
-		numSamples := len(data) / 2
-		for i := 0; i < numSamples; i++ {
-			sample := int16(binary.LittleEndian.Uint16(data[i*2 : i*2+2]))
-			absVal := math.Abs(float64(sample)) / 32768.0
-			if absVal > maxVal {
-				maxVal = absVal
-			}
-		}
-	} else if bitsPerSample == 32 {
-		numSamples := len(data) / 4
-		for i := 0; i < numSamples; i++ {
-			valbits := binary.LittleEndian.Uint32(data[i*4 : i*4+4])
-			fVal := math.Float32frombits(valbits)
-			absVal := math.Abs(float64(fVal))
-			if absVal > maxVal {
-				maxVal = absVal
-			}
+	for i, device := range devices {
+		indicator := " "
+		if config.DefaultDevice != nil && *config.DefaultDevice == device.Name {
+			indicator = "*"
 		}
+		fmt.Printf("%s %d. %s\n", indicator, i+1, device.Name)
 	}
 
-	return maxVal
+	return nil
 }
 
-func drawVisualizer(amplitude float64) {
-	const barWidth = 20
-	numBars := int(amplitude * barWidth)
-	if numBars > barWidth {
-		numBars = barWidth
+func selectDevice(deviceName string) error {
+	config, err := LoadConfig()
+	if err != nil {
+		return err
 	}
 
-	bars := strings.Repeat("|", numBars)
-	spaces := strings.Repeat(" ", barWidth-numBars)
+	config.DefaultDevice = &deviceName
+	if err := config.Save(); err != nil {
+		return err
+	}
 
-	fmt.Printf("\r\033[K[%s%s]", bars, spaces)
+	fmt.Printf("Selected default recording device: '%s'\n", deviceName)
+	return nil
 }
@@ -1,11 +1,11 @@
 package main
 
 import (
+	"bytes"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
-
-	"github.com/moutend/go-wca/pkg/wca"
 )
 
 func TestGetTrackPath(t *testing.T) {
@@ -53,14 +53,13 @@ func TestSaveWavFile(t *testing.T) {
 	audioData := []byte{0x01, 0x02, 0x03, 0x04}
 
 	// dummy wfx
-	wfx := &wca.WAVEFORMATEX{
-		WFormatTag:      1, // PCM
-		NChannels:       2,
-		NSamplesPerSec:  44100,
-		NAvgBytesPerSec: 176400,
-		NBlockAlign:     4,
-		WBitsPerSample:  16,
-		CbSize:          0,
+	wfx := &WaveFormat{
+		FormatTag:     1, // PCM
+		Channels:      2,
+		SampleRate:    44100,
+		ByteRate:      176400,
+		BlockAlign:    4,
+		BitsPerSample: 16,
 	}
 
 	err := saveWavFile(tmpFile, audioData, wfx)
@@ -90,28 +89,27 @@ func TestSaveWavFile(t *testing.T) {
 	}
 }
 
-func TestCalculateAmplitude(t *testing.T) {
-	// Test 16-bit silence
-	silence16 := []byte{0, 0, 0, 0}
-	amp := calculateAmplitude(silence16, 16)
-	if amp != 0 {
-		t.Errorf("Expected 0 amplitude for silence, got %f", amp)
-	}
+func TestRecordFromBackend(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "mic.wav")
+	trackPath := filepath.Join(dir, "take.wav")
+	writeTestTrack(t, input, 2, []int16{100, -100, 200, -200, 300, -300})
 
-	// Test 16-bit max amplitude
-	// 32767 = 0x7FFF -> 0xFF 0x7F (Little Endian)
-	max16 := []byte{0xFF, 0x7F}
-	amp = calculateAmplitude(max16, 16)
-	// 32767/32768 ~= 0.999969
-	if amp < 0.99 {
-		t.Errorf("Expected ~1.0 amplitude for max int16, got %f", amp)
+	backend := &FileBackend{InputPath: input}
+	// No stop line: the take ends when the virtual microphone runs dry
+	if err := recordFromBackend(backend, "", trackPath, storagePCM16, defaultDither, nil, strings.NewReader("\n")); err != nil {
+		t.Fatalf("recordFromBackend failed: %v", err)
 	}
 
-	// Test 32-bit float max amplitude
-	// 1.0 = 0x3F800000 -> 00 00 80 3F
-	max32 := []byte{0x00, 0x00, 0x80, 0x3F}
-	amp = calculateAmplitude(max32, 32)
-	if amp != 1.0 {
-		t.Errorf("Expected 1.0 amplitude for 1.0 float, got %f", amp)
+	_, expected, _ := readWavFile(input)
+	wfx, recorded, err := readWavFile(trackPath)
+	if err != nil {
+		t.Fatalf("Failed to read recorded track: %v", err)
+	}
+	if wfx.Channels != 2 || wfx.BitsPerSample != 16 {
+		t.Errorf("Unexpected recorded format: %+v", wfx)
+	}
+	if !bytes.Equal(recorded, expected) {
+		t.Errorf("Expected recorded data %v, got %v", expected, recorded)
 	}
 }
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Live level metering for recording. A Meter tracks per-channel levels as
+// chunks arrive; rendering them is left to formatMeter so the two can be
+// tested apart.
+
+const (
+	meterFloorDB = -60.0 // bottom of the meter scale in dBFS
+	// peakHoldSeconds is how long the hold marker stays at a peak before it
+	// starts to fall, and peakDecayDBPerSecond how fast it falls after that.
+	peakHoldSeconds      = 1.5
+	peakDecayDBPerSecond = 20.0
+)
+
+// ChannelLevel is the state of one meter channel. Levels are linear.
+type ChannelLevel struct {
+	Peak    float64 // largest absolute sample in the latest chunk
+	RMS     float64 // RMS of the latest chunk
+	Hold    float64 // held peak, decaying after peakHoldSeconds
+	Clipped bool    // set once any sample reaches full scale, until Reset
+}
+
+// Meter measures interleaved audio chunk by chunk. Peak hold is timed by the
+// frames seen rather than the wall clock.
+type Meter struct {
+	sampleRate uint32
+	levels     []ChannelLevel
+	holdAge    []float64 // seconds since each channel's hold was set
+	// Totals since the last Reset, for the summary
+	maxPeak    []float64
+	sumSquares []float64
+	frames     int
+}
+
+func newMeter(channels int, sampleRate uint32) *Meter {
+	return &Meter{
+		sampleRate: sampleRate,
+		levels:     make([]ChannelLevel, channels),
+		holdAge:    make([]float64, channels),
+		maxPeak:    make([]float64, channels),
+		sumSquares: make([]float64, channels),
+	}
+}
+
+// Update measures a chunk of interleaved samples.
+func (m *Meter) Update(samples []float64) {
+	channels := len(m.levels)
+	if channels == 0 || len(samples) < channels {
+		return
+	}
+	frames := len(samples) / channels
+	elapsed := float64(frames) / float64(m.sampleRate)
+	chunk := &AudioBuffer{SampleRate: m.sampleRate, Channels: channels, Samples: samples[:frames*channels]}
+
+	for c, stats := range analyzeChannels(chunk) {
+		level := &m.levels[c]
+		peak := stats.Peak
+		level.Peak = peak
+		level.RMS = stats.RMS
+		m.maxPeak[c] = math.Max(m.maxPeak[c], peak)
+		m.sumSquares[c] += stats.RMS * stats.RMS * float64(frames)
+		if stats.Clipped > 0 {
+			level.Clipped = true
+		}
+
+		m.holdAge[c] += elapsed
+		if peak >= level.Hold {
+			level.Hold = peak
+			m.holdAge[c] = 0
+		} else if m.holdAge[c] > peakHoldSeconds {
+			falling := math.Min(elapsed, m.holdAge[c]-peakHoldSeconds)
+			level.Hold = math.Max(peak, level.Hold*dbToGain(-peakDecayDBPerSecond*falling))
+		}
+	}
+	m.frames += frames
+}
+
+// Levels returns the current state of every channel.
+func (m *Meter) Levels() []ChannelLevel {
+	return m.levels
+}
+
+// ChannelSummary is what a channel's meter saw since the last Reset.
+type ChannelSummary struct {
+	MaxPeak float64
+	RMS     float64
+	Clipped bool
+}
+
+// Summary returns the largest peak and overall RMS of every channel.
+func (m *Meter) Summary() []ChannelSummary {
+	summary := make([]ChannelSummary, len(m.levels))
+	for c := range summary {
+		summary[c].MaxPeak = m.maxPeak[c]
+		summary[c].Clipped = m.levels[c].Clipped
+		if m.frames > 0 {
+			summary[c].RMS = math.Sqrt(m.sumSquares[c] / float64(m.frames))
+		}
+	}
+	return summary
+}
+
+// Reset clears the levels, holds, clip indicators and totals.
+func (m *Meter) Reset() {
+	for c := range m.levels {
+		m.levels[c] = ChannelLevel{}
+		m.holdAge[c] = 0
+		m.maxPeak[c] = 0
+		m.sumSquares[c] = 0
+	}
+	m.frames = 0
+}
+
+// meterPosition maps a linear level onto a bar of the given width using the
+// dBFS scale.
+func meterPosition(level float64, width int) int {
+	if level <= 0 {
+		return 0
+	}
+	fraction := (gainToDB(level) - meterFloorDB) / -meterFloorDB
+	return max(0, min(width, int(math.Round(fraction*float64(width)))))
+}
+
+// channelLabel names a meter row, using speaker names for mono and stereo.
+func channelLabel(channel, channels int) string {
+	switch {
+	case channels == 1:
+		return "M"
+	case channels == 2 && channel == 0:
+		return "L"
+	case channels == 2:
+		return "R"
+	default:
+		return strconv.Itoa(channel + 1)
+	}
+}
+
+// meterReadoutWidth is the space each row needs around the bar: the label,
+// brackets, peak and RMS readouts and the clip flag.
+const meterReadoutWidth = len("L [] -60.0 pk -60.0 rms CLIP")
+
+// formatMeter renders one row per channel fitting the given terminal width.
+// RMS fills the bar with '#', the peak extends it with '=', and '|' marks
+// the held peak.
+func formatMeter(levels []ChannelLevel, width int) []string {
+	barWidth := max(10, width-meterReadoutWidth-1)
+	rows := make([]string, len(levels))
+	for c, level := range levels {
+		rms := meterPosition(level.RMS, barWidth)
+		peak := max(rms, meterPosition(level.Peak, barWidth))
+		bar := []byte(strings.Repeat("#", rms) + strings.Repeat("=", peak-rms) + strings.Repeat(" ", barWidth-peak))
+		if hold := meterPosition(level.Hold, barWidth); hold > 0 {
+			bar[hold-1] = '|'
+		}
+
+		clip := "    "
+		if level.Clipped {
+			clip = "CLIP"
+		}
+		rows[c] = fmt.Sprintf("%s [%s] %5s pk %5s rms %s", channelLabel(c, len(levels)), bar, formatMeterDB(level.Peak), formatMeterDB(level.RMS), clip)
+	}
+	return rows
+}
+
+// formatPeakBar renders a single compact peak bar on the meter's dBFS scale,
+// for status lines with no room for a full meter.
+func formatPeakBar(peak float64) string {
+	const barWidth = 20
+	filled := meterPosition(peak, barWidth)
+	return fmt.Sprintf("[%s%s]", strings.Repeat("|", filled), strings.Repeat(" ", barWidth-filled))
+}
+
+// formatMeterDB renders a level as dBFS, with anything below the meter's
+// floor shown as -inf.
+func formatMeterDB(level float64) string {
+	db := gainToDB(level)
+	if db < meterFloorDB {
+		return "-inf"
+	}
+	return fmt.Sprintf("%.1f", db)
+}
+
+// meterDisplay redraws rows in place, moving the cursor back over the rows
+// it drew last time.
+type meterDisplay struct {
+	rows int
+}
+
+func (d *meterDisplay) draw(rows []string) {
+	var b strings.Builder
+	if d.rows > 1 {
+		fmt.Fprintf(&b, "\033[%dA", d.rows-1)
+	}
+	for i, row := range rows {
+		if i > 0 {
+			b.WriteString("\n")
+		}
+		b.WriteString("\r\033[K")
+		b.WriteString(row)
+	}
+	fmt.Print(b.String())
+	d.rows = len(rows)
+}
+
+const defaultTerminalWidth = 80
+
+// terminalWidth returns the width of the console, falling back to $COLUMNS
+// and then 80 columns when output is not a terminal.
+func terminalWidth() int {
+	if width, ok := consoleWidth(); ok && width > 0 {
+		return width
+	}
+	if width, err := strconv.Atoi(os.Getenv("COLUMNS")); err == nil && width > 0 {
+		return width
+	}
+	return defaultTerminalWidth
+}
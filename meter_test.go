@@ -0,0 +1,174 @@
+package main
+
+import (
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestMeterUpdate_PerChannel(t *testing.T) {
+	// Left carries a half-scale square wave, right is silent
+	meter := newMeter(2, 48000)
+	samples := make([]float64, 480*2)
+	for f := 0; f < 480; f++ {
+		samples[f*2] = 0.5
+		if f%2 == 1 {
+			samples[f*2] = -0.5
+		}
+	}
+	meter.Update(samples)
+
+	levels := meter.Levels()
+	if levels[0].Peak != 0.5 || math.Abs(levels[0].RMS-0.5) > 1e-9 {
+		t.Errorf("Expected left peak and RMS of 0.5, got %f and %f", levels[0].Peak, levels[0].RMS)
+	}
+	if levels[1].Peak != 0 || levels[1].RMS != 0 {
+		t.Errorf("Expected a silent right channel, got %+v", levels[1])
+	}
+}
+
+func TestMeterUpdate_RMSOfSine(t *testing.T) {
+	meter := newMeter(1, 48000)
+	meter.Update(sineBuffer(48000, 1, 1000, 1, 0.1).Samples)
+	if got := meter.Levels()[0].RMS; math.Abs(got-math.Sqrt(0.5)) > 1e-3 {
+		t.Errorf("Expected a sine RMS of 0.707, got %f", got)
+	}
+}
+
+func TestMeterUpdate_SampleFormats(t *testing.T) {
+	// The recording meter reads raw chunks through decodeSamples, so the
+	// levels must come out the same for each sample format
+	cases := []struct {
+		name      string
+		wfx       *WaveFormat
+		data      []byte
+		peak, rms float64
+	}{
+		{"16-bit silence", newPCMFormat(1, 48000, 16), []byte{0, 0, 0, 0}, 0, 0},
+		// 32767 = 0x7FFF, little endian
+		{"16-bit max", newPCMFormat(1, 48000, 16), []byte{0xFF, 0x7F}, 32767.0 / 32768, 32767.0 / 32768},
+		// 0x400000 is half of 24-bit full scale
+		{"24-bit half scale", newPCMFormat(1, 48000, 24), []byte{0x00, 0x00, 0x40, 0x00, 0x00, 0xC0}, 0.5, 0.5},
+		// 1.0 = 0x3F800000, next to silence
+		{"32-bit float max", newFloatFormat(1, 48000), []byte{0x00, 0x00, 0x80, 0x3F, 0, 0, 0, 0}, 1.0, math.Sqrt(0.5)},
+	}
+	for _, tc := range cases {
+		samples, err := decodeSamples(tc.data, tc.wfx)
+		if err != nil {
+			t.Fatalf("%s: decodeSamples failed: %v", tc.name, err)
+		}
+		meter := newMeter(1, 48000)
+		meter.Update(samples)
+		level := meter.Levels()[0]
+		if level.Peak != tc.peak || math.Abs(level.RMS-tc.rms) > 1e-9 {
+			t.Errorf("%s: expected peak %f and RMS %f, got %f and %f", tc.name, tc.peak, tc.rms, level.Peak, level.RMS)
+		}
+	}
+}
+
+func TestMeterUpdate_PeakHold(t *testing.T) {
+	meter := newMeter(1, 1000)
+	chunk := func(level float64) []float64 {
+		samples := make([]float64, 100) // 100ms
+		for i := range samples {
+			samples[i] = level
+		}
+		return samples
+	}
+
+	meter.Update(chunk(0.5))
+	meter.Update(chunk(0.1))
+	if got := meter.Levels()[0].Hold; got != 0.5 {
+		t.Fatalf("Expected the hold to keep the 0.5 peak, got %f", got)
+	}
+
+	// Still held until peakHoldSeconds have passed
+	for elapsed := 0.2; elapsed <= peakHoldSeconds; elapsed += 0.1 {
+		meter.Update(chunk(0.1))
+	}
+	if got := meter.Levels()[0].Hold; got != 0.5 {
+		t.Fatalf("Expected the hold to last %.1fs, got %f", peakHoldSeconds, got)
+	}
+
+	// Then it falls at peakDecayDBPerSecond, but never below the signal
+	meter.Update(chunk(0.1))
+	meter.Update(chunk(0.1))
+	held := meter.Levels()[0].Hold
+	if held >= 0.5 || held <= 0.1 {
+		t.Errorf("Expected the hold to be falling, got %f", held)
+	}
+	for i := 0; i < 30; i++ {
+		meter.Update(chunk(0.1))
+	}
+	if got := meter.Levels()[0].Hold; got != 0.1 {
+		t.Errorf("Expected the hold to settle on the signal, got %f", got)
+	}
+}
+
+func TestMeterUpdate_ClipIsSticky(t *testing.T) {
+	meter := newMeter(2, 48000)
+	meter.Update([]float64{0.1, -1.0})
+	meter.Update([]float64{0.1, 0.1})
+	levels := meter.Levels()
+	if levels[0].Clipped {
+		t.Error("Expected the left channel not to clip")
+	}
+	if !levels[1].Clipped {
+		t.Error("Expected the right channel clip to stay lit")
+	}
+
+	meter.Reset()
+	if meter.Levels()[1].Clipped {
+		t.Error("Expected Reset to clear the clip indicator")
+	}
+}
+
+func TestMeterPosition(t *testing.T) {
+	tests := []struct {
+		level float64
+		want  int
+	}{
+		{0, 0},
+		{dbToGain(-80), 0},
+		{dbToGain(-60), 0},
+		{dbToGain(-30), 30},
+		{1, 60},
+		{2, 60},
+	}
+	for _, tt := range tests {
+		if got := meterPosition(tt.level, 60); got != tt.want {
+			t.Errorf("meterPosition(%f) = %d, expected %d", tt.level, got, tt.want)
+		}
+	}
+}
+
+func TestFormatMeter(t *testing.T) {
+	levels := []ChannelLevel{
+		{Peak: dbToGain(-6), RMS: dbToGain(-12), Hold: dbToGain(-3)},
+		{Peak: 1, RMS: 0.5, Hold: 1, Clipped: true},
+	}
+	for _, width := range []int{80, 120} {
+		rows := formatMeter(levels, width)
+		if len(rows) != 2 {
+			t.Fatalf("Expected a row per channel, got %d", len(rows))
+		}
+		for _, row := range rows {
+			if len(row) >= width {
+				t.Errorf("Row of %d characters does not fit a %d column terminal: %q", len(row), width, row)
+			}
+		}
+		if !strings.HasPrefix(rows[0], "L [###") || !strings.Contains(rows[0], "|") {
+			t.Errorf("Unexpected left row %q", rows[0])
+		}
+		if strings.Contains(rows[0], "CLIP") || !strings.HasSuffix(rows[1], "CLIP") {
+			t.Errorf("Expected only the right row to show CLIP: %q / %q", rows[0], rows[1])
+		}
+		if !strings.Contains(rows[0], " -6.0 pk -12.0 rms") {
+			t.Errorf("Expected peak and RMS readouts in %q", rows[0])
+		}
+	}
+
+	if rows := formatMeter([]ChannelLevel{{}}, 80); !strings.HasPrefix(rows[0], "M [ ") || !strings.Contains(rows[0], "-inf pk") {
+		t.Errorf("Unexpected silent mono row %q", rows[0])
+	}
+}
@@ -0,0 +1,266 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"path/filepath"
+	"time"
+)
+
+// AudioBuffer holds decoded audio as interleaved samples in the range [-1, 1].
+type AudioBuffer struct {
+	SampleRate uint32
+	Channels   int
+	Samples    []float64
+}
+
+func (b *AudioBuffer) Frames() int {
+	if b.Channels == 0 {
+		return 0
+	}
+	return len(b.Samples) / b.Channels
+}
+
+func (b *AudioBuffer) Duration() time.Duration {
+	if b.SampleRate == 0 {
+		return 0
+	}
+	return time.Duration(b.Frames()) * time.Second / time.Duration(b.SampleRate)
+}
+
+// Peak returns the largest absolute sample value in the buffer.
+func (b *AudioBuffer) Peak() float64 {
+	return peakOf(b.Samples)
+}
+
+func peakOf(samples []float64) float64 {
+	var peak float64
+	for _, s := range samples {
+		if abs := math.Abs(s); abs > peak {
+			peak = abs
+		}
+	}
+	return peak
+}
+
+// applyHeadroom scales the buffer down if its peak exceeds full scale so the
+// final conversion to integer PCM does not clip. It returns the gain applied.
+func (b *AudioBuffer) applyHeadroom() float64 {
+	peak := b.Peak()
+	if peak <= 1.0 {
+		return 1.0
+	}
+	gain := 1.0 / peak
+	for i := range b.Samples {
+		b.Samples[i] *= gain
+	}
+	return gain
+}
+
+func loadTrack(path string) (*AudioBuffer, error) {
+	wfx, data, err := readAudioFile(path)
+	if err != nil {
+		return nil, err
+	}
+	samples, err := decodeSamples(data, wfx)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return &AudioBuffer{
+		SampleRate: wfx.SampleRate,
+		Channels:   int(wfx.Channels),
+		Samples:    samples,
+	}, nil
+}
+
+// decodeSamples converts raw WAV sample data into floats in the range [-1, 1].
+func decodeSamples(data []byte, wfx *WaveFormat) ([]float64, error) {
+	bytesPerSample := int(wfx.BitsPerSample) / 8
+	if bytesPerSample == 0 {
+		return nil, fmt.Errorf("unsupported bit depth %d", wfx.BitsPerSample)
+	}
+	numSamples := len(data) / bytesPerSample
+	samples := make([]float64, numSamples)
+
+	isFloat := isFloatFormat(wfx)
+
+	switch {
+	case isFloat && wfx.BitsPerSample == 32:
+		for i := range samples {
+			samples[i] = float64(math.Float32frombits(binary.LittleEndian.Uint32(data[i*4:])))
+		}
+	case isFloat:
+		return nil, fmt.Errorf("unsupported float bit depth %d", wfx.BitsPerSample)
+	case wfx.BitsPerSample == 8:
+		// 8-bit WAV is unsigned with a midpoint of 128
+		for i := range samples {
+			samples[i] = (float64(data[i]) - 128) / 128.0
+		}
+	case wfx.BitsPerSample == 16:
+		for i := range samples {
+			samples[i] = float64(int16(binary.LittleEndian.Uint16(data[i*2:]))) / 32768.0
+		}
+	case wfx.BitsPerSample == 24:
+		for i := range samples {
+			b := data[i*3:]
+			v := int32(uint32(b[0])<<8|uint32(b[1])<<16|uint32(b[2])<<24) >> 8
+			samples[i] = float64(v) / 8388608.0
+		}
+	case wfx.BitsPerSample == 32:
+		for i := range samples {
+			samples[i] = float64(int32(binary.LittleEndian.Uint32(data[i*4:]))) / 2147483648.0
+		}
+	default:
+		return nil, fmt.Errorf("unsupported bit depth %d", wfx.BitsPerSample)
+	}
+
+	return samples, nil
+}
+
+// encodePCM16 converts float samples to 16-bit little-endian PCM, clamping
+// anything outside [-1, 1].
+func encodePCM16(samples []float64) []byte {
+	pcmData := make([]byte, len(samples)*2)
+	for i, s := range samples {
+		binary.LittleEndian.PutUint16(pcmData[i*2:], uint16(int16(math.Round(clampSample(s)*32767))))
+	}
+	return pcmData
+}
+
+// encodeSamples converts float samples to raw data in the given format,
+// clamping anything outside [-1, 1]. Integer formats are quantized through
+// the ditherer; a nil ditherer simply rounds.
+func encodeSamples(samples []float64, wfx *WaveFormat, dither *Ditherer) ([]byte, error) {
+	if isFloatFormat(wfx) {
+		if wfx.BitsPerSample != 32 {
+			return nil, fmt.Errorf("unsupported float bit depth %d", wfx.BitsPerSample)
+		}
+		data := make([]byte, len(samples)*4)
+		for i, s := range samples {
+			binary.LittleEndian.PutUint32(data[i*4:], math.Float32bits(float32(clampSample(s))))
+		}
+		return data, nil
+	}
+
+	channels := int(wfx.Channels)
+	quantize := func(i int, s float64, fullScale float64) float64 {
+		return math.Max(-fullScale-1, math.Min(fullScale, dither.quantize(clampSample(s)*fullScale, i%channels)))
+	}
+
+	switch wfx.BitsPerSample {
+	case 16:
+		data := make([]byte, len(samples)*2)
+		for i, s := range samples {
+			binary.LittleEndian.PutUint16(data[i*2:], uint16(int16(quantize(i, s, 32767))))
+		}
+		return data, nil
+	case 24:
+		data := make([]byte, len(samples)*3)
+		for i, s := range samples {
+			v := int32(quantize(i, s, 8388607))
+			data[i*3] = byte(v)
+			data[i*3+1] = byte(v >> 8)
+			data[i*3+2] = byte(v >> 16)
+		}
+		return data, nil
+	case 32:
+		data := make([]byte, len(samples)*4)
+		for i, s := range samples {
+			binary.LittleEndian.PutUint32(data[i*4:], uint32(int32(quantize(i, s, 2147483647))))
+		}
+		return data, nil
+	default:
+		return nil, fmt.Errorf("unsupported bit depth %d", wfx.BitsPerSample)
+	}
+}
+
+func clampSample(s float64) float64 {
+	if s > 1.0 {
+		return 1.0
+	}
+	if s < -1.0 {
+		return -1.0
+	}
+	return s
+}
+
+// mixSource is one track fed to the mixer along with its project settings.
+type mixSource struct {
+	Path   string
+	GainDB float64
+	Pan    float64
+	// PanLaw is the project's pan law in dB, used for mono tracks
+	PanLaw float64
+	// Offset is the silence before the track starts, in seconds
+	Offset float64
+}
+
+// mixFiles decodes every track, applies its gain and pan, and sums them
+// sample-by-sample starting at each track's offset. The mix runs at the
+// highest sample rate among the tracks, resampling the others at the given
+// quality, and lasts until the latest track ends. The result is not clamped;
+// callers decide how to bring it back under full scale.
+func mixFiles(sources []mixSource, quality string) (*AudioBuffer, error) {
+	mix := &AudioBuffer{Channels: Channels}
+
+	for _, source := range sources {
+		wfx, _, err := readAudioHeader(source.Path)
+		if err != nil {
+			return nil, err
+		}
+		mix.SampleRate = max(mix.SampleRate, wfx.SampleRate)
+	}
+
+	for _, source := range sources {
+		track, err := loadTrack(source.Path)
+		if err != nil {
+			return nil, err
+		}
+		if track, err = track.resample(mix.SampleRate, quality); err != nil {
+			return nil, fmt.Errorf("%s: %v", filepath.Base(source.Path), err)
+		}
+
+		// Pan and the pan law are applied below, by channelGains
+		samples, err := track.toChannels(mix.Channels, 0)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", filepath.Base(source.Path), err)
+		}
+
+		gains := channelGains(source, track.Channels, mix.Channels)
+
+		start := offsetFrames(source.Offset, mix.SampleRate) * mix.Channels
+		if end := start + len(samples); end > len(mix.Samples) {
+			mix.Samples = append(mix.Samples, make([]float64, end-len(mix.Samples))...)
+		}
+		for i, s := range samples {
+			mix.Samples[start+i] += s * gains[i%mix.Channels]
+		}
+	}
+
+	return mix, nil
+}
+
+// channelGains returns the linear gain for each output channel. On stereo
+// output a mono track is placed with the pan law, while pan acts as a balance
+// control on tracks that already have their own stereo image.
+func channelGains(source mixSource, sourceChannels, channels int) []float64 {
+	gain := dbToGain(source.GainDB)
+	gains := make([]float64, channels)
+	for c := range gains {
+		gains[c] = gain
+	}
+	if channels == 2 {
+		left, right := balanceGains(source.Pan)
+		if sourceChannels == 1 {
+			left, right = panLawGains(source.Pan, source.PanLaw)
+		}
+		gains[0] *= left
+		gains[1] *= right
+	}
+	return gains
+}
+
+func dbToGain(db float64) float64 {
+	return math.Pow(10, db/20)
+}
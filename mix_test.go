@@ -0,0 +1,117 @@
+package main
+
+import (
+	"encoding/binary"
+	"math"
+	"path/filepath"
+	"testing"
+)
+
+func writeTestTrack(t *testing.T, path string, channels uint16, samples []int16) {
+	t.Helper()
+	data := make([]byte, len(samples)*2)
+	for i, s := range samples {
+		binary.LittleEndian.PutUint16(data[i*2:], uint16(s))
+	}
+	wfx := &WaveFormat{
+		FormatTag:     1, // PCM
+		Channels:      channels,
+		SampleRate:    44100,
+		ByteRate:      44100 * uint32(channels) * 2,
+		BlockAlign:    channels * 2,
+		BitsPerSample: 16,
+	}
+	if err := saveWavFile(path, data, wfx); err != nil {
+		t.Fatalf("Failed to write test track: %v", err)
+	}
+}
+
+func TestMixFiles_SumsAndPads(t *testing.T) {
+	dir := t.TempDir()
+	long := filepath.Join(dir, "long.wav")
+	short := filepath.Join(dir, "short.wav")
+
+	// Two stereo frames vs one stereo frame
+	writeTestTrack(t, long, 2, []int16{1000, 2000, 3000, 4000})
+	writeTestTrack(t, short, 2, []int16{1000, -2000})
+
+	mix, err := mixFiles([]mixSource{{Path: long}, {Path: short}}, defaultResampleQuality)
+	if err != nil {
+		t.Fatalf("mixFiles failed: %v", err)
+	}
+
+	if mix.Frames() != 2 {
+		t.Fatalf("Expected 2 frames, got %d", mix.Frames())
+	}
+
+	got := encodePCM16(mix.Samples)
+	expected := []int16{2000, 0, 3000, 4000}
+	for i, want := range expected {
+		sample := int16(binary.LittleEndian.Uint16(got[i*2:]))
+		if diff := int(sample) - int(want); diff < -1 || diff > 1 {
+			t.Errorf("Sample %d: expected %d, got %d", i, want, sample)
+		}
+	}
+}
+
+func TestMixFiles_MonoToStereo(t *testing.T) {
+	dir := t.TempDir()
+	mono := filepath.Join(dir, "mono.wav")
+	writeTestTrack(t, mono, 1, []int16{16384})
+
+	mix, err := mixFiles([]mixSource{{Path: mono}}, defaultResampleQuality)
+	if err != nil {
+		t.Fatalf("mixFiles failed: %v", err)
+	}
+	if len(mix.Samples) != 2 || mix.Samples[0] != 0.5 || mix.Samples[1] != 0.5 {
+		t.Errorf("Expected mono sample copied to both channels, got %v", mix.Samples)
+	}
+}
+
+func TestApplyHeadroom(t *testing.T) {
+	buf := &AudioBuffer{SampleRate: 44100, Channels: 2, Samples: []float64{2.0, -1.0}}
+	gain := buf.applyHeadroom()
+	if gain != 0.5 {
+		t.Errorf("Expected gain 0.5, got %f", gain)
+	}
+	if buf.Peak() != 1.0 {
+		t.Errorf("Expected peak 1.0 after headroom, got %f", buf.Peak())
+	}
+}
+
+func TestMixFiles_GainAndPan(t *testing.T) {
+	mono := filepath.Join(t.TempDir(), "mono.wav")
+	writeTestTrack(t, mono, 1, []int16{16384})
+
+	// -6.02 dB halves the level; panning half right fades the left by half
+	mix, err := mixFiles([]mixSource{{Path: mono, GainDB: -6.0206, Pan: 50}}, defaultResampleQuality)
+	if err != nil {
+		t.Fatalf("mixFiles failed: %v", err)
+	}
+	if math.Abs(mix.Samples[0]-0.125) > 1e-4 || math.Abs(mix.Samples[1]-0.25) > 1e-4 {
+		t.Errorf("Expected [0.125 0.25], got %v", mix.Samples)
+	}
+}
+
+func TestMixFiles_Offset(t *testing.T) {
+	dir := t.TempDir()
+	first := filepath.Join(dir, "first.wav")
+	late := filepath.Join(dir, "late.wav")
+	writeTestTrack(t, first, 2, []int16{16384, 16384, 16384, 16384})
+	writeTestTrack(t, late, 1, []int16{8192, 8192})
+
+	// Three frames in, so the late track runs a frame past the first one
+	mix, err := mixFiles([]mixSource{{Path: first}, {Path: late, Offset: 3.0 / 44100}}, defaultResampleQuality)
+	if err != nil {
+		t.Fatalf("mixFiles failed: %v", err)
+	}
+	if mix.Frames() != 5 {
+		t.Fatalf("Expected the mix to end with the late track at 5 frames, got %d", mix.Frames())
+	}
+	expected := []float64{0.5, 0.5, 0.5, 0.5, 0, 0, 0.25, 0.25, 0.25, 0.25}
+	for i, want := range expected {
+		if mix.Samples[i] != want {
+			t.Errorf("Sample %d: expected %g, got %g", i, want, mix.Samples[i])
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+)
+
+const monitorUsage = "Usage: muxic monitor [device] [--spectrum]"
+
+// monitorCommand shows the input meter without recording, so levels can be
+// set before a take.
+func monitorCommand(args []string) error {
+	deviceName, showSpectrum := "", false
+	for _, arg := range args {
+		switch {
+		case arg == "--spectrum":
+			showSpectrum = true
+		case deviceName == "":
+			deviceName = arg
+		default:
+			return fmt.Errorf("unexpected argument '%s'\n%s", arg, monitorUsage)
+		}
+	}
+
+	config, err := LoadConfig()
+	if err != nil {
+		return err
+	}
+
+	backend, err := openBackend(config)
+	if err != nil {
+		return err
+	}
+	defer backend.Close()
+
+	if deviceName == "" && config.DefaultDevice != nil {
+		deviceName = *config.DefaultDevice
+	}
+
+	summary, err := monitorFromBackend(backend, deviceName, showSpectrum, os.Stdin)
+	if err != nil {
+		return err
+	}
+	printMonitorSummary(summary)
+	return nil
+}
+
+// monitorFromBackend meters the capture device until a line is read from
+// input, the process is interrupted or the stream runs dry, and returns what
+// each channel saw. Nothing is written to disk.
+func monitorFromBackend(backend AudioBackend, deviceName string, showSpectrum bool, input io.Reader) ([]ChannelSummary, error) {
+	stream, err := backend.OpenCapture(deviceName)
+	if err != nil {
+		return nil, err
+	}
+	defer stream.Close()
+
+	wfx := stream.Format()
+	fmt.Printf("Monitoring %s: %d Hz, %d channels, %s\n", stream.DeviceName(), wfx.SampleRate, wfx.Channels, describeEncoding(wfx))
+
+	meter := newMeter(int(wfx.Channels), wfx.SampleRate)
+	var spectrum *Spectrum
+	if showSpectrum {
+		spectrum = newSpectrum(int(wfx.Channels), wfx.SampleRate)
+	}
+	status := "[MONITOR] Monitoring input... (Press Enter to stop)"
+	if err := captureStream(stream, bufio.NewReader(input), status, meter, spectrum, nil, nil); err != nil {
+		return nil, err
+	}
+	return meter.Summary(), nil
+}
+
+func printMonitorSummary(summary []ChannelSummary) {
+	fmt.Println("[OK] Monitoring stopped")
+	for c, channel := range summary {
+		clip := ""
+		if channel.Clipped {
+			clip = ", clipped"
+		}
+		fmt.Printf("  %s: max peak %s dBFS, average %s dBFS RMS%s\n", channelLabel(c, len(summary)), formatMeterDB(channel.MaxPeak), formatMeterDB(channel.RMS), clip)
+	}
+}
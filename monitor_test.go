@@ -0,0 +1,39 @@
+package main
+
+import (
+	"math"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestMonitorFromBackend(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "mic.wav")
+	writeTestTrack(t, input, 2, []int16{16384, -8192, -16384, 8192, 0, 0, 16384, -8192})
+
+	backend := &FileBackend{InputPath: input}
+	// No stop line: monitoring ends when the virtual microphone runs dry
+	summary, err := monitorFromBackend(backend, "", true, strings.NewReader(""))
+	if err != nil {
+		t.Fatalf("monitorFromBackend failed: %v", err)
+	}
+	if len(summary) != 2 {
+		t.Fatalf("Expected a summary per channel, got %d", len(summary))
+	}
+	if summary[0].MaxPeak != 0.5 || summary[1].MaxPeak != 0.25 {
+		t.Errorf("Expected max peaks of 0.5 and 0.25, got %f and %f", summary[0].MaxPeak, summary[1].MaxPeak)
+	}
+	// Three of the four left samples are at half scale
+	if want := math.Sqrt(0.75 * 0.25); math.Abs(summary[0].RMS-want) > 1e-9 {
+		t.Errorf("Expected a left RMS of %f, got %f", want, summary[0].RMS)
+	}
+	if summary[0].Clipped || summary[1].Clipped {
+		t.Error("Expected no clipping")
+	}
+
+	matches, _ := filepath.Glob(filepath.Join(dir, "*"))
+	if len(matches) != 1 {
+		t.Errorf("Expected monitoring to write nothing, found %v", matches)
+	}
+}
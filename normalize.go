@@ -0,0 +1,178 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// Normalization modes: bring the sample peak or the integrated loudness to a
+// target level.
+const (
+	normalizePeak     = "peak"
+	normalizeLoudness = "lufs"
+)
+
+const defaultPeakTarget = -1.0 // dBFS
+
+// backupSuffix is appended to a track's file name when it is normalized in
+// place. It keeps the backup out of list and mix.
+const backupSuffix = ".bak"
+
+// normalizeTarget is the level a normalization aims for, in dBFS for peak
+// mode and LUFS for loudness mode.
+type normalizeTarget struct {
+	Mode  string
+	Level float64
+}
+
+func (t normalizeTarget) String() string {
+	if t.Mode == normalizeLoudness {
+		return fmt.Sprintf("%.1f LUFS", t.Level)
+	}
+	return fmt.Sprintf("%.1f dBFS peak", t.Level)
+}
+
+// extractNormalizeFlags pulls --peak and --lufs out of the arguments. It
+// returns nil when neither is given.
+func extractNormalizeFlags(args []string) ([]string, *normalizeTarget, error) {
+	args, peak, err := extractFlag(args, "peak")
+	if err != nil {
+		return nil, nil, err
+	}
+	args, lufs, err := extractFlag(args, "lufs")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	switch {
+	case peak != "" && lufs != "":
+		return nil, nil, errors.New("use either --peak or --lufs, not both")
+	case peak != "":
+		level, err := strconv.ParseFloat(peak, 64)
+		if err != nil || level > 0 {
+			return nil, nil, fmt.Errorf("invalid peak target '%s' (use dBFS, e.g. -1)", peak)
+		}
+		return args, &normalizeTarget{Mode: normalizePeak, Level: level}, nil
+	case lufs != "":
+		level, err := strconv.ParseFloat(lufs, 64)
+		if err != nil || level > 0 {
+			return nil, nil, fmt.Errorf("invalid loudness target '%s' (use LUFS, e.g. -14)", lufs)
+		}
+		return args, &normalizeTarget{Mode: normalizeLoudness, Level: level}, nil
+	default:
+		return args, nil, nil
+	}
+}
+
+// normalizationGain returns the linear gain that brings the buffer to the
+// target level.
+func normalizationGain(buf *AudioBuffer, target normalizeTarget) (float64, error) {
+	var current float64
+	if target.Mode == normalizeLoudness {
+		current = integratedLoudness(buf)
+		if math.IsInf(current, -1) {
+			return 0, errors.New("too quiet or too short to measure loudness")
+		}
+	} else {
+		peak := buf.Peak()
+		if peak == 0 {
+			return 0, errors.New("audio is silent")
+		}
+		current = gainToDB(peak)
+	}
+	return dbToGain(target.Level - current), nil
+}
+
+// normalizeBuffer applies the normalization gain and returns it. The result
+// may exceed full scale when a loudness target is set too high.
+func normalizeBuffer(buf *AudioBuffer, target normalizeTarget) (float64, error) {
+	gain, err := normalizationGain(buf, target)
+	if err != nil {
+		return 0, err
+	}
+	for i := range buf.Samples {
+		buf.Samples[i] *= gain
+	}
+	return gain, nil
+}
+
+// warnIfClipping tells the user when normalized audio will clip on output.
+func warnIfClipping(buf *AudioBuffer) {
+	if peak := buf.Peak(); peak > 1.0 {
+		fmt.Printf("  Warning: peaks reach %+.1f dBFS and will clip; lower the target\n", gainToDB(peak))
+	}
+}
+
+// normalizeTrack brings a track to the target level, either writing a new
+// track or replacing it in place after keeping a backup.
+func normalizeTrack(trackName string, target normalizeTarget, outputName, dither string) error {
+	trackPath := getTrackPath(trackName)
+	wfx, audioData, err := readAudioFile(trackPath)
+	if os.IsNotExist(err) {
+		return fmt.Errorf("Track '%s' not found", trackName)
+	}
+	if err != nil {
+		return err
+	}
+	samples, err := decodeSamples(audioData, wfx)
+	if err != nil {
+		return err
+	}
+	buf := &AudioBuffer{SampleRate: wfx.SampleRate, Channels: int(wfx.Channels), Samples: samples}
+
+	gain, err := normalizeBuffer(buf, target)
+	if err != nil {
+		return fmt.Errorf("cannot normalize '%s': %v", trackName, err)
+	}
+	fmt.Printf("[NORMALIZE] '%s' to %s: %+.2f dB\n", trackName, target, gainToDB(gain))
+	warnIfClipping(buf)
+
+	outFormat := formatLike(wfx, wfx.Channels, wfx.SampleRate)
+	var ditherer *Ditherer
+	if !isFloatFormat(outFormat) {
+		if ditherer, err = newDitherer(dither, buf.Channels, defaultDitherSeed); err != nil {
+			return err
+		}
+	}
+	normalized, err := encodeSamples(buf.Samples, outFormat, ditherer)
+	if err != nil {
+		return err
+	}
+	meta, err := carriedMetadata(trackPath, nil)
+	if err != nil {
+		return err
+	}
+
+	if outputName != "" {
+		outputPath := getTrackPath(outputName)
+		if _, err := os.Stat(outputPath); err == nil {
+			return fmt.Errorf("Track '%s' already exists", outputName)
+		}
+		if err := saveWavFile(outputPath, normalized, outFormat, meta); err != nil {
+			return err
+		}
+		fmt.Printf("[OK] Normalized '%s' into '%s'\n", trackName, outputName)
+		return nil
+	}
+
+	// Write alongside the track first so a failure leaves it untouched
+	tempPath := trackPath + ".tmp"
+	if err := saveAudioFileAs(tempPath, filepath.Ext(trackPath), normalized, outFormat, meta); err != nil {
+		os.Remove(tempPath)
+		return err
+	}
+	backupPath := trackPath + backupSuffix
+	if err := os.Rename(trackPath, backupPath); err != nil {
+		os.Remove(tempPath)
+		return err
+	}
+	if err := os.Rename(tempPath, trackPath); err != nil {
+		return err
+	}
+	fmt.Printf("[OK] Normalized '%s' in place (original kept in %s)\n", trackName, backupPath)
+	return nil
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"math"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestNormalizeBuffer(t *testing.T) {
+	buf := &AudioBuffer{SampleRate: 44100, Channels: 1, Samples: []float64{0.25, -0.5, 0.1}}
+	if _, err := normalizeBuffer(buf, normalizeTarget{Mode: normalizePeak, Level: -6.0206}); err != nil {
+		t.Fatalf("Peak normalization failed: %v", err)
+	}
+	if math.Abs(buf.Peak()-0.5) > 1e-4 {
+		t.Errorf("Expected a peak of 0.5, got %f", buf.Peak())
+	}
+
+	sine := sineBuffer(48000, 2, 997, 0.05, 3)
+	if _, err := normalizeBuffer(sine, normalizeTarget{Mode: normalizeLoudness, Level: -16}); err != nil {
+		t.Fatalf("Loudness normalization failed: %v", err)
+	}
+	if lufs := integratedLoudness(sine); math.Abs(lufs+16) > 0.05 {
+		t.Errorf("Expected -16 LUFS after normalization, got %.2f", lufs)
+	}
+
+	silent := &AudioBuffer{SampleRate: 44100, Channels: 1, Samples: make([]float64, 44100)}
+	for _, mode := range []string{normalizePeak, normalizeLoudness} {
+		if _, err := normalizeBuffer(silent, normalizeTarget{Mode: mode, Level: -1}); err == nil {
+			t.Errorf("%s: expected an error normalizing silence", mode)
+		}
+	}
+}
+
+func TestExtractNormalizeFlags(t *testing.T) {
+	args, target, err := extractNormalizeFlags([]string{"mix", "out", "--lufs", "-14"})
+	if err != nil || len(args) != 2 || target == nil || *target != (normalizeTarget{Mode: normalizeLoudness, Level: -14}) {
+		t.Errorf("Unexpected result: %v, %+v, %v", args, target, err)
+	}
+	if _, target, err := extractNormalizeFlags([]string{"mix", "out"}); err != nil || target != nil {
+		t.Errorf("Expected no target without flags, got %+v, %v", target, err)
+	}
+	for _, bad := range [][]string{
+		{"--peak", "-1", "--lufs", "-14"},
+		{"--peak", "3"},
+		{"--lufs", "loud"},
+	} {
+		if _, _, err := extractNormalizeFlags(bad); err == nil {
+			t.Errorf("%v: expected an error", bad)
+		}
+	}
+}
+
+func TestNormalizeTrack(t *testing.T) {
+	isolateProjects(t)
+	projectRoot = t.TempDir()
+	if err := ensureTracksDir(); err != nil {
+		t.Fatal(err)
+	}
+	writeTestTrack(t, getTrackPath("vocals"), 1, []int16{8192, -4096, 0})
+	peak := normalizeTarget{Mode: normalizePeak, Level: 0}
+
+	if err := normalizeTrack("vocals", peak, "vocals_loud", ditherNone); err != nil {
+		t.Fatalf("Normalizing to a new track failed: %v", err)
+	}
+	loud, err := loadTrack(getTrackPath("vocals_loud"))
+	if err != nil {
+		t.Fatalf("Failed to read the normalized track: %v", err)
+	}
+	if math.Abs(loud.Peak()-1) > 1e-4 {
+		t.Errorf("Expected the new track to peak at full scale, got %f", loud.Peak())
+	}
+
+	if err := normalizeTrack("vocals", peak, "", ditherNone); err != nil {
+		t.Fatalf("Normalizing in place failed: %v", err)
+	}
+	original, err := loadTrack(getTrackPath("vocals") + backupSuffix)
+	if err != nil {
+		t.Fatalf("Expected a readable backup: %v", err)
+	}
+	if original.Peak() != 0.25 {
+		t.Errorf("Expected the backup to hold the original take, got peak %f", original.Peak())
+	}
+	inPlace, err := loadTrack(getTrackPath("vocals"))
+	if err != nil {
+		t.Fatalf("Failed to read the normalized track: %v", err)
+	}
+	if math.Abs(inPlace.Peak()-1) > 1e-4 {
+		t.Errorf("Expected the track to peak at full scale, got %f", inPlace.Peak())
+	}
+	if _, err := os.Stat(getTrackPath("vocals") + ".tmp"); !os.IsNotExist(err) {
+		t.Error("Expected the temporary file to be gone")
+	}
+}
+
+func TestNormalizeTrack_KeepsMetadata(t *testing.T) {
+	isolateProjects(t)
+	projectRoot = t.TempDir()
+	if err := ensureTracksDir(); err != nil {
+		t.Fatal(err)
+	}
+	wfx := newPCMFormat(1, 44100, 16)
+	meta, err := encodeMetadataChunks(&TrackMetadata{
+		Broadcast: newRecordingInfo("Lead vocal", wfx, time.Now()),
+		IXML:      &IXMLInfo{Scene: "4", Take: "2"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := saveWavFile(getTrackPath("vocals"), []byte{0x00, 0x20, 0x00, 0xF0}, wfx, meta); err != nil {
+		t.Fatal(err)
+	}
+	peak := normalizeTarget{Mode: normalizePeak, Level: 0}
+
+	if err := normalizeTrack("vocals", peak, "vocals_loud", ditherNone); err != nil {
+		t.Fatalf("Normalizing to a new track failed: %v", err)
+	}
+	if err := normalizeTrack("vocals", peak, "", ditherNone); err != nil {
+		t.Fatalf("Normalizing in place failed: %v", err)
+	}
+	for _, name := range []string{"vocals_loud", "vocals"} {
+		got, err := readTrackMetadata(getTrackPath(name))
+		if err != nil {
+			t.Fatalf("%s: readTrackMetadata failed: %v", name, err)
+		}
+		if got.Broadcast == nil || got.Broadcast.Description != "Lead vocal" {
+			t.Errorf("%s: expected the bext description kept, got %+v", name, got.Broadcast)
+		}
+		if got.IXML == nil || got.IXML.Scene != "4" || got.IXML.Take != "2" {
+			t.Errorf("%s: expected the iXML kept, got %+v", name, got.IXML)
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Pan laws set how loud a mono track panned to the centre is relative to
+// hard left or right. 0 dB keeps the centre at full level on both sides,
+// -3 dB keeps the acoustic power constant across the field, -6 dB keeps the
+// summed amplitude constant and -4.5 dB splits the difference.
+var panLaws = []float64{0, -3, -4.5, -6}
+
+// defaultPanLaw is used by projects that never set one, matching the full
+// level mono tracks were mixed at before pan laws existed.
+const defaultPanLaw = 0.0
+
+// PanLawDB returns the project pan law in dB, defaulting to defaultPanLaw.
+func (p Project) PanLawDB() float64 {
+	if p.PanLaw != nil {
+		return *p.PanLaw
+	}
+	return defaultPanLaw
+}
+
+// panLawGains returns the left and right gains for a mono source at a pan
+// position from -100 to 100 under the given pan law.
+func panLawGains(pan, lawDB float64) (left, right float64) {
+	p := math.Max(-1, math.Min(1, pan/100))
+	theta := (p + 1) * math.Pi / 4
+
+	linearLeft, linearRight := (1-p)/2, (1+p)/2
+	powerLeft, powerRight := math.Cos(theta), math.Sin(theta)
+
+	switch lawDB {
+	case -3:
+		return powerLeft, powerRight
+	case -4.5:
+		return math.Sqrt(linearLeft * powerLeft), math.Sqrt(linearRight * powerRight)
+	case -6:
+		return linearLeft, linearRight
+	default:
+		return balanceGains(pan)
+	}
+}
+
+// balanceGains returns the left and right gains of a balance control: the
+// centre is unity and turning towards one side fades the other out.
+func balanceGains(pan float64) (left, right float64) {
+	p := math.Max(-1, math.Min(1, pan/100))
+	return math.Min(1, 1-p), math.Min(1, 1+p)
+}
+
+// parsePanLaw reads a pan law such as "-3" or "-4.5dB".
+func parsePanLaw(value string) (float64, error) {
+	law, err := strconv.ParseFloat(strings.TrimSuffix(strings.TrimSuffix(value, "dB"), "db"), 64)
+	if err == nil {
+		for _, known := range panLaws {
+			if law == known {
+				return law, nil
+			}
+		}
+	}
+	return 0, fmt.Errorf("invalid pan law '%s' (use 0, -3, -4.5 or -6)", value)
+}
+
+// panLawCommand shows or sets the project's pan law.
+func panLawCommand(args []string) error {
+	project, err := LoadProject()
+	if err != nil {
+		return err
+	}
+
+	if len(args) == 0 {
+		if project.PanLaw == nil {
+			fmt.Printf("Pan law: %g dB (default)\n", defaultPanLaw)
+		} else {
+			fmt.Printf("Pan law: %g dB\n", *project.PanLaw)
+		}
+		return nil
+	}
+
+	law, err := parsePanLaw(args[0])
+	if err != nil {
+		return err
+	}
+	project.PanLaw = &law
+	if err := project.Save(); err != nil {
+		return err
+	}
+	fmt.Printf("Pan law set to %g dB\n", law)
+	return nil
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPanLawGains(t *testing.T) {
+	for _, law := range panLaws {
+		left, right := panLawGains(0, law)
+		if math.Abs(gainToDB(left)-law) > 0.05 || math.Abs(gainToDB(right)-law) > 0.05 {
+			t.Errorf("%g dB law: expected centre at %g dB, got %.2f / %.2f dB", law, law, gainToDB(left), gainToDB(right))
+		}
+
+		left, right = panLawGains(-100, law)
+		if left != 1 || math.Abs(right) > 1e-12 {
+			t.Errorf("%g dB law: expected hard left to be [1 0], got [%g %g]", law, left, right)
+		}
+		left, right = panLawGains(100, law)
+		if math.Abs(left) > 1e-12 || right != 1 {
+			t.Errorf("%g dB law: expected hard right to be [0 1], got [%g %g]", law, left, right)
+		}
+	}
+
+	// Constant power holds everywhere across the field under -3 dB
+	for _, pan := range []float64{-70, -20, 35, 90} {
+		left, right := panLawGains(pan, -3)
+		if power := left*left + right*right; math.Abs(power-1) > 1e-9 {
+			t.Errorf("Pan %g: expected unit power, got %f", pan, power)
+		}
+	}
+}
+
+func TestParsePanLaw(t *testing.T) {
+	for value, want := range map[string]float64{"0": 0, "-3": -3, "-4.5dB": -4.5, "-6db": -6} {
+		if got, err := parsePanLaw(value); err != nil || got != want {
+			t.Errorf("parsePanLaw(%q) = %g, %v; expected %g", value, got, err, want)
+		}
+	}
+	for _, bad := range []string{"-2", "3", "loud"} {
+		if _, err := parsePanLaw(bad); err == nil {
+			t.Errorf("parsePanLaw(%q): expected an error", bad)
+		}
+	}
+}
+
+func TestPanLawCommand_RoundTrip(t *testing.T) {
+	isolateProjects(t)
+	projectRoot = t.TempDir()
+
+	project, err := LoadProject()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if project.PanLaw != nil || project.PanLawDB() != defaultPanLaw {
+		t.Errorf("Expected an unset pan law to default to %g dB, got %v", defaultPanLaw, project.PanLaw)
+	}
+
+	// An explicit 0 dB is saved and read back as set, not as the default
+	for _, value := range []string{"-3", "0"} {
+		if err := panLawCommand([]string{value}); err != nil {
+			t.Fatalf("panLawCommand(%s) failed: %v", value, err)
+		}
+		project, err := LoadProject()
+		if err != nil {
+			t.Fatal(err)
+		}
+		want, _ := parsePanLaw(value)
+		if project.PanLaw == nil || *project.PanLaw != want {
+			t.Errorf("Expected a saved pan law of %g dB, got %v", want, project.PanLaw)
+		}
+	}
+	data, err := os.ReadFile(projectFilePath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), `"pan_law": 0`) {
+		t.Errorf("Expected the project file to keep pan_law 0, got %s", data)
+	}
+}
+
+func TestMixFiles_PanLawAndBalance(t *testing.T) {
+	dir := t.TempDir()
+	mono := filepath.Join(dir, "mono.wav")
+	stereo := filepath.Join(dir, "stereo.wav")
+	writeTestTrack(t, mono, 1, []int16{16384})
+	writeTestTrack(t, stereo, 2, []int16{16384, 16384})
+
+	// A centred mono track drops 3 dB on each side under the -3 dB law
+	mix, err := mixFiles([]mixSource{{Path: mono, PanLaw: -3}}, defaultResampleQuality)
+	if err != nil {
+		t.Fatalf("mixFiles failed: %v", err)
+	}
+	want := 0.5 * math.Sqrt(0.5)
+	if math.Abs(mix.Samples[0]-want) > 1e-9 || math.Abs(mix.Samples[1]-want) > 1e-9 {
+		t.Errorf("Expected [%g %g], got %v", want, want, mix.Samples)
+	}
+
+	// A stereo track keeps its level at the centre and balances when panned,
+	// whatever the pan law
+	mix, err = mixFiles([]mixSource{{Path: stereo, PanLaw: -3, Pan: 50}}, defaultResampleQuality)
+	if err != nil {
+		t.Fatalf("mixFiles failed: %v", err)
+	}
+	if mix.Samples[0] != 0.25 || mix.Samples[1] != 0.5 {
+		t.Errorf("Expected balance to give [0.25 0.5], got %v", mix.Samples)
+	}
+}
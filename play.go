@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// playbackBlock is how much audio is converted and queued per write. It is
+// also how often the transport display updates.
+const playbackBlock = 50 * time.Millisecond
+
+// playBuffer streams the buffer to an output device, converting it to the
+// format the stream asks for, and draws the transport while it plays.
+func playBuffer(backend AudioBackend, deviceName string, buf *AudioBuffer) error {
+	// Ask for float at the track's own rate and layout; the stream reports
+	// what it will actually take.
+	requested := newFloatFormat(uint16(buf.Channels), buf.SampleRate)
+	stream, err := backend.OpenPlayback(deviceName, requested)
+	if err != nil {
+		return err
+	}
+	if err := streamToOutput(stream, buf); err != nil {
+		stream.Close()
+		return err
+	}
+	return stream.Close()
+}
+
+func streamToOutput(stream PlaybackStream, buf *AudioBuffer) error {
+	out := stream.Format()
+	if out.SampleRate != buf.SampleRate {
+		fmt.Printf("Resampling %d Hz to %d Hz for %s\n", buf.SampleRate, out.SampleRate, stream.DeviceName())
+		resampled, err := buf.resample(out.SampleRate, defaultResampleQuality)
+		if err != nil {
+			return err
+		}
+		buf = resampled
+	}
+	samples, err := buf.toChannels(int(out.Channels), 0)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Output: %s\n", stream.DeviceName())
+
+	channels := int(out.Channels)
+	var dither *Ditherer
+	if !isFloatFormat(out) {
+		if dither, err = newDitherer(defaultDither, channels, defaultDitherSeed); err != nil {
+			return err
+		}
+	}
+	blockFrames := int(out.SampleRate) * int(playbackBlock/time.Millisecond) / 1000
+	totalFrames := len(samples) / channels
+	total := buf.Duration()
+
+	for frame := 0; frame < totalFrames; frame += blockFrames {
+		end := min(frame+blockFrames, totalFrames)
+		block := samples[frame*channels : end*channels]
+
+		data, err := encodeSamples(block, out, dither)
+		if err != nil {
+			return err
+		}
+		if err := stream.Write(data); err != nil {
+			return err
+		}
+
+		elapsed := time.Duration(end) * time.Second / time.Duration(out.SampleRate)
+		drawTransport(elapsed, total, peakOf(block))
+	}
+	fmt.Println()
+	return nil
+}
+
+func drawTransport(elapsed, total time.Duration, amplitude float64) {
+	fmt.Printf("\r\033[K%s / %s %s", formatTimestamp(elapsed), formatTimestamp(total), formatPeakBar(amplitude))
+}
+
+// formatTimestamp renders a duration as m:ss.t
+func formatTimestamp(d time.Duration) string {
+	tenths := d.Round(100*time.Millisecond) / (100 * time.Millisecond)
+	return fmt.Sprintf("%d:%02d.%d", tenths/600, (tenths/10)%60, tenths%10)
+}
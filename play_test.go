@@ -0,0 +1,83 @@
+package main
+
+import (
+	"math"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestPlayBuffer_FileSink(t *testing.T) {
+	output := filepath.Join(t.TempDir(), "played.wav")
+	backend := &FileBackend{OutputPath: output}
+
+	// A little over two playback blocks of a mono ramp
+	buf := &AudioBuffer{SampleRate: 8000, Channels: 1, Samples: make([]float64, 900)}
+	for i := range buf.Samples {
+		buf.Samples[i] = float64(i)/900 - 0.5
+	}
+
+	if err := playBuffer(backend, "", buf); err != nil {
+		t.Fatalf("playBuffer failed: %v", err)
+	}
+
+	played, err := loadTrack(output)
+	if err != nil {
+		t.Fatalf("Failed to read playback output: %v", err)
+	}
+	if played.SampleRate != 8000 || played.Channels != 1 {
+		t.Errorf("Unexpected output format: %d Hz, %d channels", played.SampleRate, played.Channels)
+	}
+	if len(played.Samples) != len(buf.Samples) {
+		t.Fatalf("Expected %d samples played, got %d", len(buf.Samples), len(played.Samples))
+	}
+	for i := range buf.Samples {
+		if math.Abs(played.Samples[i]-buf.Samples[i]) > 1e-6 {
+			t.Fatalf("Sample %d: expected %f, got %f", i, buf.Samples[i], played.Samples[i])
+		}
+	}
+}
+
+func TestPlayBuffer_NullSink(t *testing.T) {
+	buf := &AudioBuffer{SampleRate: 44100, Channels: 2, Samples: make([]float64, 200)}
+	if err := playBuffer(&FileBackend{}, "", buf); err != nil {
+		t.Errorf("playBuffer to null sink failed: %v", err)
+	}
+}
+
+func TestEncodeSamples_RoundTrip(t *testing.T) {
+	samples := []float64{0, 0.5, -0.5, 0.999}
+	for _, wfx := range []*WaveFormat{
+		newPCMFormat(1, 44100, 16),
+		newPCMFormat(1, 44100, 24),
+		newPCMFormat(1, 44100, 32),
+		newFloatFormat(1, 44100),
+	} {
+		data, err := encodeSamples(samples, wfx, nil)
+		if err != nil {
+			t.Fatalf("encodeSamples(%d bits) failed: %v", wfx.BitsPerSample, err)
+		}
+		decoded, err := decodeSamples(data, wfx)
+		if err != nil {
+			t.Fatalf("decodeSamples(%d bits) failed: %v", wfx.BitsPerSample, err)
+		}
+		for i := range samples {
+			if math.Abs(decoded[i]-samples[i]) > 2.0/32768 {
+				t.Errorf("%d bits, sample %d: expected %f, got %f", wfx.BitsPerSample, i, samples[i], decoded[i])
+			}
+		}
+	}
+}
+
+func TestFormatTimestamp(t *testing.T) {
+	tests := map[time.Duration]string{
+		0:                       "0:00.0",
+		1500 * time.Millisecond: "0:01.5",
+		75 * time.Second:        "1:15.0",
+	}
+	for d, want := range tests {
+		if got := formatTimestamp(d); got != want {
+			t.Errorf("formatTimestamp(%v) = %s, expected %s", d, got, want)
+		}
+	}
+}
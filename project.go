@@ -0,0 +1,285 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Project holds the per-track mix settings for the tracks under a project
+// root. The order of Tracks is the order tracks are listed and mixed in.
+type Project struct {
+	// Tempo and BeatsPerBar place bar:beat positions on the timeline; zero
+	// means 120 BPM in 4/4
+	Tempo       float64 `json:"tempo,omitempty"`
+	BeatsPerBar int     `json:"beats_per_bar,omitempty"`
+	// PanLaw is the level of a centred mono track in dB: 0, -3, -4.5 or -6;
+	// nil means defaultPanLaw, and an explicit 0 dB is kept as set
+	PanLaw *float64 `json:"pan_law,omitempty"`
+	// StorageFormat overrides the user config's storage format for this
+	// project when set
+	StorageFormat string         `json:"storage_format,omitempty"`
+	Tracks        []ProjectTrack `json:"tracks"`
+}
+
+type ProjectTrack struct {
+	// Name is the track's file name without the .wav extension
+	Name        string `json:"name"`
+	DisplayName string `json:"display_name,omitempty"`
+	// GainDB is applied before panning; 0 leaves the level untouched
+	GainDB float64 `json:"gain_db"`
+	// Pan runs from -100 (hard left) through 0 (centre) to +100 (hard right)
+	Pan  float64 `json:"pan"`
+	Mute bool    `json:"mute,omitempty"`
+	Solo bool    `json:"solo,omitempty"`
+	// Offset is where the track starts on the timeline, in seconds
+	Offset float64 `json:"offset,omitempty"`
+}
+
+const projectFileName = "muxic.project.json"
+
+func LoadProject() (Project, error) {
+	var project Project
+	data, err := os.ReadFile(projectFilePath())
+	if os.IsNotExist(err) {
+		return project, nil
+	}
+	if err != nil {
+		return project, err
+	}
+	err = json.Unmarshal(data, &project)
+	return project, err
+}
+
+func (p Project) Save() error {
+	data, err := json.MarshalIndent(p, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(projectFilePath(), data, 0644)
+}
+
+func projectFilePath() string {
+	return filepath.Join(projectRoot, projectFileName)
+}
+
+// Title returns the name to show for the track.
+func (t ProjectTrack) Title() string {
+	if t.DisplayName != "" {
+		return t.DisplayName
+	}
+	return t.Name
+}
+
+// Track returns the settings for the named track, adding defaults for a track
+// the project has not seen yet.
+func (p *Project) Track(name string) *ProjectTrack {
+	for i := range p.Tracks {
+		if p.Tracks[i].Name == name {
+			return &p.Tracks[i]
+		}
+	}
+	p.Tracks = append(p.Tracks, ProjectTrack{Name: name})
+	return &p.Tracks[len(p.Tracks)-1]
+}
+
+// Arrange returns settings for each of the given track names: tracks the
+// project knows come first in project order, followed by new tracks
+// alphabetically. Tracks whose files are gone are left out.
+func (p Project) Arrange(names []string) []ProjectTrack {
+	present := make(map[string]bool, len(names))
+	for _, name := range names {
+		present[name] = true
+	}
+
+	var arranged []ProjectTrack
+	for _, track := range p.Tracks {
+		if present[track.Name] {
+			arranged = append(arranged, track)
+			delete(present, track.Name)
+		}
+	}
+
+	var added []string
+	for name := range present {
+		added = append(added, name)
+	}
+	sort.Strings(added)
+	for _, name := range added {
+		arranged = append(arranged, ProjectTrack{Name: name})
+	}
+	return arranged
+}
+
+// Audible reports whether a track is heard in the mix: muted tracks never
+// are, and once any track is soloed only soloed tracks are.
+func (t ProjectTrack) Audible(anySolo bool) bool {
+	return !t.Mute && (!anySolo || t.Solo)
+}
+
+func anySoloed(tracks []ProjectTrack) bool {
+	for _, t := range tracks {
+		if t.Solo {
+			return true
+		}
+	}
+	return false
+}
+
+// move places the named track at a 1-based position in the project order.
+func (p *Project) move(name string, position int) {
+	track := *p.Track(name)
+	var rest []ProjectTrack
+	for _, t := range p.Tracks {
+		if t.Name != name {
+			rest = append(rest, t)
+		}
+	}
+	index := min(max(position-1, 0), len(rest))
+	p.Tracks = append(rest[:index:index], append([]ProjectTrack{track}, rest[index:]...)...)
+}
+
+// recordedTrackNames returns the names of the tracks in the project.
+func recordedTrackNames() ([]string, error) {
+	entries, err := os.ReadDir(tracksDir())
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	seen := make(map[string]bool)
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		name, ok := trackFileName(entry.Name())
+		if !ok {
+			continue
+		}
+		if !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	return names, nil
+}
+
+const trackUsage = `Usage:
+  muxic track gain <track-name> <dB>
+  muxic track pan <track-name> <-100..100>
+  muxic track mute|unmute <track-name>
+  muxic track solo|unsolo <track-name>
+  muxic track name <track-name> <display-name>
+  muxic track order <track-name> <position>
+  muxic track move <track-name> <seconds|samples smp|bar:beat>`
+
+// trackCommand edits a track's settings in the project file.
+func trackCommand(args []string) error {
+	if len(args) < 2 {
+		return errors.New("track name required\n" + trackUsage)
+	}
+	action, trackName := args[0], args[1]
+	values := args[2:]
+
+	if _, err := os.Stat(getTrackPath(trackName)); os.IsNotExist(err) {
+		return fmt.Errorf("Track '%s' not found", trackName)
+	}
+
+	project, err := LoadProject()
+	if err != nil {
+		return err
+	}
+	track := project.Track(trackName)
+
+	var message string
+	switch action {
+	case "gain":
+		gain, err := parseTrackValue(values, "gain in dB")
+		if err != nil {
+			return err
+		}
+		track.GainDB = gain
+		message = fmt.Sprintf("Gain of '%s' set to %+.1f dB", trackName, gain)
+	case "pan":
+		pan, err := parseTrackValue(values, "pan position")
+		if err != nil {
+			return err
+		}
+		if pan < -100 || pan > 100 {
+			return fmt.Errorf("pan must be between -100 (left) and 100 (right), got %g", pan)
+		}
+		track.Pan = pan
+		message = fmt.Sprintf("Pan of '%s' set to %s", trackName, formatPan(pan))
+	case "mute", "unmute":
+		track.Mute = action == "mute"
+		message = fmt.Sprintf("Track '%s' %sd", trackName, action)
+	case "solo", "unsolo":
+		track.Solo = action == "solo"
+		message = fmt.Sprintf("Track '%s' %sed", trackName, action)
+	case "name":
+		if len(values) == 0 {
+			return errors.New("display name required\n" + trackUsage)
+		}
+		track.DisplayName = strings.Join(values, " ")
+		message = fmt.Sprintf("Track '%s' is now shown as '%s'", trackName, track.DisplayName)
+	case "order":
+		if len(values) == 0 {
+			return errors.New("position required\n" + trackUsage)
+		}
+		position, err := strconv.Atoi(values[0])
+		if err != nil {
+			return fmt.Errorf("invalid position '%s' (use a whole number)", values[0])
+		}
+		project.move(trackName, position)
+		message = fmt.Sprintf("Track '%s' moved to position %d", trackName, position)
+	case "move":
+		if len(values) == 0 {
+			return errors.New("timeline position required\n" + trackUsage)
+		}
+		wfx, _, err := readAudioHeader(getTrackPath(trackName))
+		if err != nil {
+			return err
+		}
+		offset, err := parsePosition(values[0], wfx.SampleRate, project)
+		if err != nil {
+			return err
+		}
+		track.Offset = offset
+		message = fmt.Sprintf("Track '%s' now starts at %s", trackName, formatOffset(offset, project))
+	default:
+		return fmt.Errorf("unknown track action '%s'\n%s", action, trackUsage)
+	}
+
+	if err := project.Save(); err != nil {
+		return err
+	}
+	fmt.Println(message)
+	return nil
+}
+
+func parseTrackValue(values []string, what string) (float64, error) {
+	if len(values) == 0 {
+		return 0, fmt.Errorf("%s required\n%s", what, trackUsage)
+	}
+	value, err := strconv.ParseFloat(values[0], 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s '%s'", what, values[0])
+	}
+	return value, nil
+}
+
+// formatPan renders a pan value as C, L30 or R100.
+func formatPan(pan float64) string {
+	switch {
+	case pan < 0:
+		return fmt.Sprintf("L%g", -pan)
+	case pan > 0:
+		return fmt.Sprintf("R%g", pan)
+	default:
+		return "C"
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"os"
+	"reflect"
+	"testing"
+)
+
+func trackNames(tracks []ProjectTrack) []string {
+	var names []string
+	for _, t := range tracks {
+		names = append(names, t.Name)
+	}
+	return names
+}
+
+func TestProject_SaveAndLoad(t *testing.T) {
+	// LoadProject reads from the working directory, so move any real project aside
+	if _, err := os.Stat(projectFileName); err == nil {
+		os.Rename(projectFileName, projectFileName+".bak")
+		defer os.Rename(projectFileName+".bak", projectFileName)
+	} else {
+		defer os.Remove(projectFileName)
+	}
+
+	project := Project{}
+	project.Track("vocals").GainDB = -3
+	project.Track("guitar").Mute = true
+	if err := project.Save(); err != nil {
+		t.Fatalf("Failed to save project: %v", err)
+	}
+
+	loaded, err := LoadProject()
+	if err != nil {
+		t.Fatalf("Failed to load project: %v", err)
+	}
+	if !reflect.DeepEqual(loaded, project) {
+		t.Errorf("Expected %+v, got %+v", project, loaded)
+	}
+}
+
+func TestProject_Arrange(t *testing.T) {
+	project := Project{Tracks: []ProjectTrack{
+		{Name: "drums"},
+		{Name: "deleted"},
+		{Name: "bass", GainDB: -2},
+	}}
+
+	arranged := project.Arrange([]string{"bass", "vocals", "drums", "guitar"})
+	expected := []string{"drums", "bass", "guitar", "vocals"}
+	if names := trackNames(arranged); !reflect.DeepEqual(names, expected) {
+		t.Errorf("Expected order %v, got %v", expected, names)
+	}
+	if arranged[1].GainDB != -2 {
+		t.Errorf("Expected bass settings to be kept, got %+v", arranged[1])
+	}
+}
+
+func TestProject_Move(t *testing.T) {
+	project := Project{Tracks: []ProjectTrack{{Name: "a"}, {Name: "b"}, {Name: "c"}}}
+
+	project.move("c", 1)
+	if names := trackNames(project.Tracks); !reflect.DeepEqual(names, []string{"c", "a", "b"}) {
+		t.Errorf("Unexpected order after move to front: %v", names)
+	}
+	project.move("c", 10)
+	if names := trackNames(project.Tracks); !reflect.DeepEqual(names, []string{"a", "b", "c"}) {
+		t.Errorf("Unexpected order after move past the end: %v", names)
+	}
+}
+
+func TestTrackCommand_Order(t *testing.T) {
+	isolateProjects(t)
+	projectRoot = t.TempDir()
+	if err := ensureTracksDir(); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"drums", "bass"} {
+		writeTestTrack(t, getTrackPath(name), 1, []int16{0})
+	}
+
+	for _, bad := range []string{"1.5", "2.9", "first", ""} {
+		if err := trackCommand([]string{"order", "bass", bad}); err == nil {
+			t.Errorf("Expected position %q to be rejected", bad)
+		}
+	}
+	if err := trackCommand([]string{"order", "bass"}); err == nil {
+		t.Error("Expected a missing position to be rejected")
+	}
+
+	if err := trackCommand([]string{"order", "bass", "1"}); err != nil {
+		t.Fatalf("trackCommand failed: %v", err)
+	}
+	project, err := LoadProject()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(project.Tracks) == 0 || project.Tracks[0].Name != "bass" {
+		t.Errorf("Expected bass first, got %+v", project.Tracks)
+	}
+}
+
+func TestProjectTrack_Audible(t *testing.T) {
+	tests := []struct {
+		track   ProjectTrack
+		anySolo bool
+		want    bool
+	}{
+		{ProjectTrack{}, false, true},
+		{ProjectTrack{Mute: true}, false, false},
+		{ProjectTrack{}, true, false},
+		{ProjectTrack{Solo: true}, true, true},
+		{ProjectTrack{Solo: true, Mute: true}, true, false},
+	}
+	for _, tt := range tests {
+		if got := tt.track.Audible(tt.anySolo); got != tt.want {
+			t.Errorf("%+v with anySolo=%v: expected %v, got %v", tt.track, tt.anySolo, tt.want, got)
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// partialSuffix marks a take that is still being recorded, or whose recording
+// was interrupted before it could be promoted to a track.
+const partialSuffix = ".part"
+
+func recoverTracks() error {
+	recovered, err := recoverPartialTakes(tracksDir())
+	if os.IsNotExist(err) {
+		fmt.Println("No tracks directory found. Nothing to recover.")
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if len(recovered) == 0 {
+		fmt.Println("No interrupted takes found.")
+		return nil
+	}
+	fmt.Printf("[OK] Recovered %d take(s)\n", len(recovered))
+	return nil
+}
+
+// recoverPartialTakes repairs every partial take in dir and renames it to a
+// regular track, returning the paths of the recovered tracks. A take never
+// overwrites an existing track; it gets a numbered name instead.
+func recoverPartialTakes(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var recovered []string
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".wav"+partialSuffix) {
+			continue
+		}
+		partialPath := filepath.Join(dir, entry.Name())
+
+		dataSize, err := repairWavFile(partialPath)
+		if err != nil {
+			fmt.Printf("  ! %s: %v\n", entry.Name(), err)
+			continue
+		}
+
+		trackPath := availableTrackPath(strings.TrimSuffix(partialPath, partialSuffix))
+		if err := os.Rename(partialPath, trackPath); err != nil {
+			return recovered, err
+		}
+		fmt.Printf("  + %s (%d KB)\n", filepath.Base(trackPath), dataSize/1024)
+		recovered = append(recovered, trackPath)
+	}
+	return recovered, nil
+}
+
+// availableTrackPath returns path, or path with a "_recoveredN" suffix if a
+// file by that name already exists.
+func availableTrackPath(path string) string {
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return path
+	}
+	base := strings.TrimSuffix(path, ".wav")
+	for i := 1; ; i++ {
+		candidate := fmt.Sprintf("%s_recovered%d.wav", base, i)
+		if _, err := os.Stat(candidate); os.IsNotExist(err) {
+			return candidate
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// crashedTake leaves a partial take behind as if muxic died mid-recording:
+// sample data is on disk but the header sizes were never patched.
+func crashedTake(t *testing.T, path string, data []byte) {
+	t.Helper()
+	w, err := createWavWriter(path, newPCMFormat(2, 44100, 16))
+	if err != nil {
+		t.Fatalf("createWavWriter failed: %v", err)
+	}
+	if _, err := w.Write(data); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	w.f.Close()
+}
+
+func TestRepairWavFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "take.wav.part")
+	// Two whole stereo frames and a torn third one
+	crashedTake(t, path, []byte{1, 0, 2, 0, 3, 0, 4, 0, 5, 0})
+
+	if _, _, err := readWavFile(path); err == nil {
+		t.Fatal("Expected unrepaired take to be unreadable")
+	}
+
+	dataSize, err := repairWavFile(path)
+	if err != nil {
+		t.Fatalf("repairWavFile failed: %v", err)
+	}
+	if dataSize != 8 {
+		t.Errorf("Expected 8 bytes kept, got %d", dataSize)
+	}
+
+	_, audioData, err := readWavFile(path)
+	if err != nil {
+		t.Fatalf("Repaired take is unreadable: %v", err)
+	}
+	if !bytes.Equal(audioData, []byte{1, 0, 2, 0, 3, 0, 4, 0}) {
+		t.Errorf("Unexpected repaired data %v", audioData)
+	}
+}
+
+func TestRecoverPartialTakes(t *testing.T) {
+	dir := t.TempDir()
+	crashedTake(t, filepath.Join(dir, "vocals.wav"+partialSuffix), []byte{1, 0, 2, 0})
+	crashedTake(t, filepath.Join(dir, "guitar.wav"+partialSuffix), []byte{3, 0, 4, 0})
+	// An existing guitar track must not be overwritten
+	writeTestTrack(t, filepath.Join(dir, "guitar.wav"), 2, []int16{9, 9})
+
+	recovered, err := recoverPartialTakes(dir)
+	if err != nil {
+		t.Fatalf("recoverPartialTakes failed: %v", err)
+	}
+	if len(recovered) != 2 {
+		t.Fatalf("Expected 2 recovered takes, got %v", recovered)
+	}
+
+	for _, name := range []string{"vocals.wav", "guitar.wav", "guitar_recovered1.wav"} {
+		if _, _, err := readWavFile(filepath.Join(dir, name)); err != nil {
+			t.Errorf("Expected readable %s: %v", name, err)
+		}
+	}
+	if matches, _ := filepath.Glob(filepath.Join(dir, "*"+partialSuffix)); len(matches) != 0 {
+		t.Errorf("Expected no partial takes left, got %v", matches)
+	}
+
+	_, guitar, _ := readWavFile(filepath.Join(dir, "guitar.wav"))
+	if !bytes.Equal(guitar, []byte{9, 0, 9, 0}) {
+		t.Errorf("Existing guitar track was modified: %v", guitar)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "vocals.wav")); err != nil {
+		t.Errorf("Expected vocals.wav: %v", err)
+	}
+}
+
+func TestRepairWavFile_FloatFactChunk(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "take.wav.part")
+	wfx := newFloatFormat(1, 48000)
+	w, err := createWavWriter(path, wfx)
+	if err != nil {
+		t.Fatalf("createWavWriter failed: %v", err)
+	}
+	if _, err := w.Write(make([]byte, 5*4)); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	w.f.Close()
+
+	if _, err := repairWavFile(path); err != nil {
+		t.Fatalf("repairWavFile failed: %v", err)
+	}
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if frames := binary.LittleEndian.Uint32(raw[factLengthOffset(wfx):]); frames != 5 {
+		t.Errorf("Expected the fact chunk to hold 5 frames, got %d", frames)
+	}
+}
+
+func TestRepairWavFile_RF64(t *testing.T) {
+	const size = 5<<30 + 5
+	path := filepath.Join(t.TempDir(), "take.wav.part")
+	w, err := createLongWavWriter(path, newPCMFormat(2, 48000, 24))
+	if err != nil {
+		t.Fatalf("createLongWavWriter failed: %v", err)
+	}
+	// A crash leaves the sizes unpatched and maybe a partial frame
+	if err := w.f.Truncate(w.layout.dataOffset + size); err != nil {
+		t.Fatal(err)
+	}
+	w.f.Close()
+
+	dataSize, err := repairWavFile(path)
+	if err != nil {
+		t.Fatalf("repairWavFile failed: %v", err)
+	}
+	if dataSize != size-size%6 {
+		t.Errorf("Expected %d bytes kept, got %d", int64(size-size%6), dataSize)
+	}
+	if _, headerSize, err := readWavHeader(path); err != nil || headerSize != dataSize {
+		t.Errorf("Expected the repaired RF64 header to give %d bytes, got %d, %v", dataSize, headerSize, err)
+	}
+}
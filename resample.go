@@ -0,0 +1,169 @@
+package main
+
+import (
+	"fmt"
+	"math"
+)
+
+// Resampler quality settings, trading speed for a flatter passband and a
+// steeper, deeper stopband.
+const (
+	resampleFast = "fast"
+	resampleGood = "good"
+	resampleBest = "best"
+)
+
+const defaultResampleQuality = resampleGood
+
+// resampleQuality sets up the windowed-sinc kernel: the number of zero
+// crossings on each side, the Kaiser window shape and the cutoff as a
+// fraction of the lower Nyquist frequency.
+type resampleQuality struct {
+	halfTaps int
+	beta     float64
+	cutoff   float64
+}
+
+var resampleQualities = map[string]resampleQuality{
+	resampleFast: {halfTaps: 8, beta: 5, cutoff: 0.85},
+	resampleGood: {halfTaps: 24, beta: 8, cutoff: 0.92},
+	resampleBest: {halfTaps: 64, beta: 12, cutoff: 0.96},
+}
+
+// maxPolyphaseCoefficients caps the size of the precomputed filter bank. Rate
+// pairs with an awkward ratio have too many phases to tabulate, and their
+// coefficients are computed per output frame instead.
+const maxPolyphaseCoefficients = 1 << 20
+
+// resample returns the buffer converted to another sample rate with a
+// Kaiser-windowed sinc filter. The buffer itself is returned when the rate
+// already matches.
+func (b *AudioBuffer) resample(rate uint32, quality string) (*AudioBuffer, error) {
+	q, ok := resampleQualities[quality]
+	if !ok {
+		return nil, fmt.Errorf("unknown resampling quality '%s' (use %s, %s or %s)", quality, resampleFast, resampleGood, resampleBest)
+	}
+	if rate == 0 {
+		return nil, fmt.Errorf("invalid sample rate %d", rate)
+	}
+	if rate == b.SampleRate {
+		return b, nil
+	}
+
+	// Output frame n sits at input position n*step/phases, which cycles
+	// through a fixed set of fractional phases
+	g := gcd(uint64(b.SampleRate), uint64(rate))
+	phases := uint64(rate) / g
+	step := uint64(b.SampleRate) / g
+
+	// When downsampling the filter must cut below the new Nyquist frequency,
+	// which widens the kernel by the same factor
+	scale := math.Min(1, float64(rate)/float64(b.SampleRate))
+	fc := 0.5 * scale * q.cutoff
+	width := float64(q.halfTaps) / scale
+	taps := int(math.Ceil(width))
+
+	kernel := func(phase uint64, coefficients []float64) {
+		frac := float64(phase) / float64(phases)
+		var sum float64
+		for j := range coefficients {
+			x := frac - float64(j-taps+1)
+			coefficients[j] = 2 * fc * sinc(2*fc*x) * kaiser(x/width, q.beta)
+			sum += coefficients[j]
+		}
+		// Normalize each phase to unity gain at DC so no phase is louder
+		for j := range coefficients {
+			coefficients[j] /= sum
+		}
+	}
+
+	var bank [][]float64
+	if phases*uint64(2*taps) <= maxPolyphaseCoefficients {
+		bank = make([][]float64, phases)
+		for p := range bank {
+			bank[p] = make([]float64, 2*taps)
+			kernel(uint64(p), bank[p])
+		}
+	}
+
+	frames := uint64(b.Frames())
+	outFrames := (frames*uint64(rate) + uint64(b.SampleRate) - 1) / uint64(b.SampleRate)
+	out := &AudioBuffer{SampleRate: rate, Channels: b.Channels, Samples: make([]float64, int(outFrames)*b.Channels)}
+
+	scratch := make([]float64, 2*taps)
+	for n := uint64(0); n < outFrames; n++ {
+		position := n * step
+		index := int(position / phases)
+		phase := position % phases
+
+		coefficients := scratch
+		if bank != nil {
+			coefficients = bank[phase]
+		} else {
+			kernel(phase, coefficients)
+		}
+
+		first := index - taps + 1
+		for c := 0; c < b.Channels; c++ {
+			var acc float64
+			for j, h := range coefficients {
+				k := first + j
+				if k < 0 || k >= int(frames) {
+					continue
+				}
+				acc += h * b.Samples[k*b.Channels+c]
+			}
+			out.Samples[int(n)*b.Channels+c] = acc
+		}
+	}
+	return out, nil
+}
+
+func sinc(x float64) float64 {
+	if x == 0 {
+		return 1
+	}
+	return math.Sin(math.Pi*x) / (math.Pi * x)
+}
+
+// kaiser evaluates a Kaiser window at u in [-1, 1].
+func kaiser(u, beta float64) float64 {
+	if u <= -1 || u >= 1 {
+		return 0
+	}
+	return besselI0(beta*math.Sqrt(1-u*u)) / besselI0(beta)
+}
+
+// besselI0 is the zeroth-order modified Bessel function of the first kind,
+// summed from its power series.
+func besselI0(x float64) float64 {
+	sum, term := 1.0, 1.0
+	for k := 1; term > 1e-12*sum; k++ {
+		term *= (x / (2 * float64(k))) * (x / (2 * float64(k)))
+		sum += term
+	}
+	return sum
+}
+
+func gcd(a, b uint64) uint64 {
+	for b != 0 {
+		a, b = b, a%b
+	}
+	return a
+}
+
+// extractQualityFlag pulls a --quality flag out of the arguments, defaulting
+// to good.
+func extractQualityFlag(args []string) ([]string, string, error) {
+	rest, quality, err := extractFlag(args, "quality")
+	if err != nil {
+		return nil, "", err
+	}
+	if quality == "" {
+		quality = defaultResampleQuality
+	}
+	if _, ok := resampleQualities[quality]; !ok {
+		return nil, "", fmt.Errorf("unknown resampling quality '%s' (use %s, %s or %s)", quality, resampleFast, resampleGood, resampleBest)
+	}
+	return rest, quality, nil
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"math"
+	"path/filepath"
+	"testing"
+)
+
+// sineError returns the largest difference between a resampled sine and the
+// ideal sine at the new rate, ignoring the edges where the filter runs off
+// the ends of the input.
+func sineError(buf *AudioBuffer, freq, amplitude float64) float64 {
+	edge := buf.Frames() / 10
+	var worst float64
+	for f := edge; f < buf.Frames()-edge; f++ {
+		want := amplitude * math.Sin(2*math.Pi*freq*float64(f)/float64(buf.SampleRate))
+		for c := 0; c < buf.Channels; c++ {
+			worst = math.Max(worst, math.Abs(buf.Samples[f*buf.Channels+c]-want))
+		}
+	}
+	return worst
+}
+
+func TestResample_SineAcrossRates(t *testing.T) {
+	tests := []struct {
+		from, to uint32
+	}{
+		{44100, 48000},
+		{48000, 44100},
+		{22050, 96000},
+	}
+	for _, tt := range tests {
+		in := sineBuffer(tt.from, 2, 1000, 0.5, 0.2)
+		out, err := in.resample(tt.to, resampleGood)
+		if err != nil {
+			t.Fatalf("%d -> %d: resample failed: %v", tt.from, tt.to, err)
+		}
+		if out.SampleRate != tt.to || out.Channels != 2 {
+			t.Errorf("%d -> %d: unexpected format %d Hz, %d channels", tt.from, tt.to, out.SampleRate, out.Channels)
+		}
+		if want := int(math.Ceil(float64(in.Frames()) * float64(tt.to) / float64(tt.from))); out.Frames() != want {
+			t.Errorf("%d -> %d: expected %d frames, got %d", tt.from, tt.to, want, out.Frames())
+		}
+		if e := sineError(out, 1000, 0.5); e > 1e-3 {
+			t.Errorf("%d -> %d: sine deviates by %g", tt.from, tt.to, e)
+		}
+	}
+}
+
+func TestResample_QualityOrdering(t *testing.T) {
+	in := sineBuffer(44100, 1, 15000, 0.5, 0.2)
+	var errs []float64
+	for _, quality := range []string{resampleFast, resampleGood, resampleBest} {
+		out, err := in.resample(48000, quality)
+		if err != nil {
+			t.Fatalf("%s: resample failed: %v", quality, err)
+		}
+		errs = append(errs, sineError(out, 15000, 0.5))
+	}
+	if !(errs[0] > errs[1] && errs[1] > errs[2]) {
+		t.Errorf("Expected error to fall with quality, got fast %g, good %g, best %g", errs[0], errs[1], errs[2])
+	}
+}
+
+func TestResample_RemovesAliases(t *testing.T) {
+	// 23 kHz is fine at 48 kHz but above the Nyquist frequency of 44.1 kHz
+	in := sineBuffer(48000, 1, 23000, 0.5, 0.2)
+	out, err := in.resample(44100, resampleGood)
+	if err != nil {
+		t.Fatalf("resample failed: %v", err)
+	}
+	edge := out.Frames() / 10
+	if peak := peakOf(out.Samples[edge : out.Frames()-edge]); gainToDB(peak/0.5) > -60 {
+		t.Errorf("Expected the tone to be filtered out, peak is %.1f dB", gainToDB(peak/0.5))
+	}
+}
+
+func TestResample_UntabulatedRatio(t *testing.T) {
+	// 44101 Hz has too many phases to tabulate, so coefficients are computed
+	// per frame
+	in := sineBuffer(44100, 1, 1000, 0.5, 0.05)
+	out, err := in.resample(44101, resampleGood)
+	if err != nil {
+		t.Fatalf("resample failed: %v", err)
+	}
+	if e := sineError(out, 1000, 0.5); e > 1e-3 {
+		t.Errorf("Sine deviates by %g", e)
+	}
+}
+
+func TestResample_SameRateAndErrors(t *testing.T) {
+	in := sineBuffer(44100, 1, 1000, 0.5, 0.01)
+	if out, err := in.resample(44100, resampleGood); err != nil || out != in {
+		t.Errorf("Expected the buffer back unchanged, got %v, %v", out, err)
+	}
+	if _, err := in.resample(48000, "ultra"); err == nil {
+		t.Error("Expected an error for an unknown quality")
+	}
+}
+
+func TestMixFiles_MixedRates(t *testing.T) {
+	dir := t.TempDir()
+	high := filepath.Join(dir, "high.wav")
+	low := filepath.Join(dir, "low.wav")
+	writeTestTrack(t, high, 2, make([]int16, 2*441))
+
+	lowFormat := newPCMFormat(1, 22050, 16)
+	if err := saveWavFile(low, make([]byte, 2*441), lowFormat); err != nil {
+		t.Fatal(err)
+	}
+
+	mix, err := mixFiles([]mixSource{{Path: low}, {Path: high}}, resampleFast)
+	if err != nil {
+		t.Fatalf("mixFiles failed: %v", err)
+	}
+	if mix.SampleRate != 44100 {
+		t.Errorf("Expected the mix at the highest rate, got %d Hz", mix.SampleRate)
+	}
+	if mix.Frames() != 882 {
+		t.Errorf("Expected the 22.05 kHz track to double to 882 frames, got %d", mix.Frames())
+	}
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/cmplx"
+	"strings"
+)
+
+// A rough octave-band spectrum for monitoring. The latest spectrumSize
+// frames, summed to mono, are windowed and transformed, and the power in
+// each octave is shown on the meter's dBFS scale.
+
+const spectrumSize = 4096 // frames per analysis, a power of two
+
+// spectrumBands are the octave centre frequencies shown, in Hz.
+var spectrumBands = []float64{63, 125, 250, 500, 1000, 2000, 4000, 8000, 16000}
+
+// Spectrum keeps the most recent audio for analysis.
+type Spectrum struct {
+	sampleRate uint32
+	channels   int
+	history    []float64 // mono, oldest first
+}
+
+func newSpectrum(channels int, sampleRate uint32) *Spectrum {
+	return &Spectrum{sampleRate: sampleRate, channels: channels, history: make([]float64, spectrumSize)}
+}
+
+// Update appends a chunk of interleaved samples, keeping the latest
+// spectrumSize frames.
+func (s *Spectrum) Update(samples []float64) {
+	frames := len(samples) / s.channels
+	if frames >= spectrumSize {
+		samples = samples[(frames-spectrumSize)*s.channels:]
+		frames = spectrumSize
+	}
+	copy(s.history, s.history[frames:])
+	tail := s.history[spectrumSize-frames:]
+	for f := range tail {
+		var sum float64
+		for c := 0; c < s.channels; c++ {
+			sum += samples[f*s.channels+c]
+		}
+		tail[f] = sum / float64(s.channels)
+	}
+}
+
+// Bands returns the level of each octave band as the amplitude of a sine
+// carrying the same power, so a full-scale tone reads 1.
+func (s *Spectrum) Bands() []float64 {
+	// Hann window, whose power gain of 3/8 is undone below
+	bins := make([]complex128, spectrumSize)
+	for n, x := range s.history {
+		w := 0.5 - 0.5*math.Cos(2*math.Pi*float64(n)/float64(spectrumSize))
+		bins[n] = complex(x*w, 0)
+	}
+	fft(bins)
+
+	binHz := float64(s.sampleRate) / spectrumSize
+	levels := make([]float64, len(spectrumBands))
+	for i, centre := range spectrumBands {
+		lower := int(math.Ceil(centre / math.Sqrt2 / binHz))
+		upper := min(spectrumSize/2, int(math.Ceil(centre*math.Sqrt2/binHz)))
+		var power float64
+		for k := max(1, lower); k < upper; k++ {
+			power += real(bins[k])*real(bins[k]) + imag(bins[k])*imag(bins[k])
+		}
+		levels[i] = math.Sqrt(power*32/3) / spectrumSize
+	}
+	return levels
+}
+
+// fft transforms x in place with an iterative radix-2 Cooley-Tukey FFT. The
+// length must be a power of two.
+func fft(x []complex128) {
+	n := len(x)
+	for i, j := 1, 0; i < n; i++ {
+		bit := n >> 1
+		for ; j&bit != 0; bit >>= 1 {
+			j ^= bit
+		}
+		j ^= bit
+		if i < j {
+			x[i], x[j] = x[j], x[i]
+		}
+	}
+	for size := 2; size <= n; size <<= 1 {
+		step := cmplx.Exp(complex(0, -2*math.Pi/float64(size)))
+		for start := 0; start < n; start += size {
+			w := complex(1, 0)
+			for k := 0; k < size/2; k++ {
+				even, odd := x[start+k], w*x[start+k+size/2]
+				x[start+k] = even + odd
+				x[start+k+size/2] = even - odd
+				w *= step
+			}
+		}
+	}
+}
+
+// formatSpectrum renders one row per octave band below the Nyquist
+// frequency, sized like the meter rows.
+func formatSpectrum(levels []float64, sampleRate uint32, width int) []string {
+	barWidth := max(10, width-meterReadoutWidth-1)
+	var rows []string
+	for i, centre := range spectrumBands {
+		if centre >= float64(sampleRate)/2 {
+			break
+		}
+		filled := meterPosition(levels[i], barWidth)
+		rows = append(rows, fmt.Sprintf("%5s [%s%s] %5s dB", formatBandName(centre), strings.Repeat("#", filled), strings.Repeat(" ", barWidth-filled), formatMeterDB(levels[i])))
+	}
+	return rows
+}
+
+func formatBandName(hz float64) string {
+	if hz >= 1000 {
+		return fmt.Sprintf("%gk", hz/1000)
+	}
+	return fmt.Sprintf("%g", hz)
+}
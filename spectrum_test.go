@@ -0,0 +1,73 @@
+package main
+
+import (
+	"math"
+	"math/cmplx"
+	"testing"
+)
+
+func TestFFT_MatchesDFT(t *testing.T) {
+	const n = 16
+	x := make([]complex128, n)
+	for i := range x {
+		x[i] = complex(math.Sin(float64(i)*0.7)+0.3*float64(i%3), 0)
+	}
+	want := make([]complex128, n)
+	for k := range want {
+		for i, v := range x {
+			want[k] += v * cmplx.Exp(complex(0, -2*math.Pi*float64(k*i)/n))
+		}
+	}
+
+	fft(x)
+	for k := range x {
+		if cmplx.Abs(x[k]-want[k]) > 1e-9 {
+			t.Errorf("Bin %d: expected %v, got %v", k, want[k], x[k])
+		}
+	}
+}
+
+func TestSpectrumBands_Sine(t *testing.T) {
+	// A 1 kHz sine at half scale lands in the 1k band at about -6 dB and
+	// leaves distant bands near silence
+	spectrum := newSpectrum(2, 48000)
+	spectrum.Update(sineBuffer(48000, 2, 1000, 0.5, 0.2).Samples)
+
+	bands := spectrum.Bands()
+	for i, centre := range spectrumBands {
+		db := gainToDB(bands[i])
+		switch centre {
+		case 1000:
+			if math.Abs(db-gainToDB(0.5)) > 0.5 {
+				t.Errorf("Expected the 1k band near -6 dB, got %.2f", db)
+			}
+		case 63, 125, 8000, 16000:
+			if db > -60 {
+				t.Errorf("Expected the %g Hz band to be quiet, got %.2f dB", centre, db)
+			}
+		}
+	}
+}
+
+func TestSpectrumUpdate_KeepsLatestFrames(t *testing.T) {
+	spectrum := newSpectrum(1, 48000)
+	spectrum.Update([]float64{1, 2, 3})
+	spectrum.Update([]float64{4})
+	tail := spectrum.history[spectrumSize-4:]
+	for i, want := range []float64{1, 2, 3, 4} {
+		if tail[i] != want {
+			t.Errorf("Expected history to end with 1 2 3 4, got %v", tail)
+			break
+		}
+	}
+}
+
+func TestFormatSpectrum_StopsAtNyquist(t *testing.T) {
+	levels := make([]float64, len(spectrumBands))
+	if rows := formatSpectrum(levels, 48000, 80); len(rows) != len(spectrumBands) {
+		t.Errorf("Expected every band at 48 kHz, got %d rows", len(rows))
+	}
+	if rows := formatSpectrum(levels, 22050, 80); len(rows) != len(spectrumBands)-1 {
+		t.Errorf("Expected the 16k band to be dropped at 22.05 kHz, got %d rows", len(rows))
+	}
+}
@@ -0,0 +1,465 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+	"unicode/utf16"
+)
+
+// Descriptive tags for finished mixes, given with --tag key=value on export
+// and mix. WAV files carry them twice, as a LIST INFO chunk and as an ID3v2.4
+// tag in an "id3 " chunk, since players read one or the other; FLAC files
+// carry them as Vorbis comments.
+
+// Tags maps tag keys to their values.
+type Tags map[string]string
+
+// tagField is one tag and its name in each tagging scheme.
+type tagField struct {
+	Key    string
+	Label  string // as shown by info
+	Info   string // LIST INFO chunk ID
+	ID3    string // ID3v2.4 frame ID
+	Vorbis string // Vorbis comment field name
+}
+
+var tagFields = []tagField{
+	{"title", "Title", "INAM", "TIT2", "TITLE"},
+	{"artist", "Artist", "IART", "TPE1", "ARTIST"},
+	{"album", "Album", "IPRD", "TALB", "ALBUM"},
+	{"genre", "Genre", "IGNR", "TCON", "GENRE"},
+	{"comment", "Comment", "ICMT", "COMM", "COMMENT"},
+	{"track", "Track", "ITRK", "TRCK", "TRACKNUMBER"},
+	{"date", "Date", "ICRD", "TDRC", "DATE"},
+}
+
+var (
+	trackNumberPattern = regexp.MustCompile(`^[0-9]+(/[0-9]+)?$`)
+	datePattern        = regexp.MustCompile(`^[0-9]{4}(-[0-9]{2}(-[0-9]{2})?)?$`)
+)
+
+// extractTagFlags pulls every --tag key=value out of the arguments.
+func extractTagFlags(args []string) ([]string, Tags, error) {
+	args, values, err := extractFlagValues(args, "tag")
+	if err != nil {
+		return nil, nil, err
+	}
+	tags := Tags{}
+	for _, value := range values {
+		key, text, ok := strings.Cut(value, "=")
+		key = strings.ToLower(key)
+		if !ok || !slices.ContainsFunc(tagFields, func(f tagField) bool { return f.Key == key }) {
+			var keys []string
+			for _, field := range tagFields {
+				keys = append(keys, field.Key)
+			}
+			return nil, nil, fmt.Errorf("invalid tag '%s' (use %s=<value>)", value, strings.Join(keys, "|"))
+		}
+		switch {
+		case key == "track" && !trackNumberPattern.MatchString(text):
+			return nil, nil, fmt.Errorf("invalid track number '%s' (use e.g. 3 or 3/12)", text)
+		case key == "date" && !datePattern.MatchString(text):
+			return nil, nil, fmt.Errorf("invalid date '%s' (use YYYY, YYYY-MM or YYYY-MM-DD)", text)
+		}
+		tags[key] = text
+	}
+	return args, tags, nil
+}
+
+// checkTaggable fails early when tags are asked for on a file type that
+// cannot hold them.
+func checkTaggable(path string, tags Tags) error {
+	if len(tags) > 0 && isAIFFPath(path) {
+		return errors.New("tags can only be written to WAV and FLAC files")
+	}
+	return nil
+}
+
+// tagAudioFile writes tags into a finished export or mix, leaving the file
+// alone when none were given.
+func tagAudioFile(path string, tags Tags) error {
+	if len(tags) == 0 {
+		return nil
+	}
+	return writeAudioTags(path, tags)
+}
+
+// writeAudioTags tags a WAV or FLAC file, replacing any tags it had.
+func writeAudioTags(path string, tags Tags) error {
+	magic, err := sniffAudioFile(path)
+	if err != nil {
+		return err
+	}
+	switch magic {
+	case flacMagic:
+		return writeFLACComments(path, tags)
+	case "RIFF", "RF64", "BW64":
+		isTags := func(id string, head []byte) bool {
+			return id == "id3 " || id == "ID3 " || id == "LIST" && string(head) == "INFO"
+		}
+		return rewriteWavChunks(path, isTags, encodeTagChunks(tags))
+	default:
+		return fmt.Errorf("%s: tags can only be written to WAV and FLAC files", path)
+	}
+}
+
+// encodeTagChunks returns the LIST INFO and id3 chunks that hold tags in a
+// WAV file.
+func encodeTagChunks(tags Tags) []byte {
+	chunks := appendRIFFChunk(nil, "LIST", encodeInfoList(tags))
+	return appendRIFFChunk(chunks, "id3 ", encodeID3(tags))
+}
+
+// readAudioTags returns the tags of a WAV or FLAC file. LIST INFO wins over
+// ID3 where a WAV file has both. Other files have no tags.
+func readAudioTags(path string) (Tags, error) {
+	magic, err := sniffAudioFile(path)
+	if err != nil {
+		return nil, err
+	}
+	switch magic {
+	case flacMagic:
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		blocks, _, err := splitFLACMetadata(data)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+		tags := Tags{}
+		for _, block := range blocks {
+			if block.blockType == flacBlockVorbisComment {
+				parseVorbisComments(block.body, tags)
+			}
+		}
+		return tags, nil
+	case "RIFF", "RF64", "BW64":
+		return readWavTags(path)
+	default:
+		return Tags{}, nil
+	}
+}
+
+func readWavTags(path string) (Tags, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	chunks, err := listWavChunks(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+
+	var info, id3 Tags
+	for _, chunk := range chunks {
+		if chunk.ID != "LIST" && chunk.ID != "id3 " && chunk.ID != "ID3 " {
+			continue
+		}
+		body, err := readWavChunk(f, chunk)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+		if chunk.ID == "LIST" {
+			if len(body) >= 4 && string(body[:4]) == "INFO" {
+				info = parseInfoList(body[4:])
+			}
+		} else {
+			id3 = parseID3(body)
+		}
+	}
+	tags := Tags{}
+	for key, value := range id3 {
+		tags[key] = value
+	}
+	for key, value := range info {
+		tags[key] = value
+	}
+	return tags, nil
+}
+
+// printTags prints the tags that are set, in the layout of `muxic info`,
+// and reports whether there were any.
+func printTags(tags Tags) bool {
+	printed := false
+	for _, field := range tagFields {
+		if value := tags[field.Key]; value != "" {
+			fmt.Printf("  %-12s %s\n", field.Label+":", value)
+			printed = true
+		}
+	}
+	return printed
+}
+
+// encodeInfoList returns the body of a LIST INFO chunk. Each value is NUL
+// terminated, as RIFF text fields are.
+func encodeInfoList(tags Tags) []byte {
+	body := []byte("INFO")
+	for _, field := range tagFields {
+		if value := tags[field.Key]; value != "" {
+			body = appendRIFFChunk(body, field.Info, append([]byte(value), 0))
+		}
+	}
+	return body
+}
+
+func parseInfoList(body []byte) Tags {
+	tags := Tags{}
+	for len(body) >= 8 {
+		id := string(body[:4])
+		size := int(binary.LittleEndian.Uint32(body[4:8]))
+		if 8+size > len(body) {
+			break
+		}
+		value := string(bytes.TrimRight(body[8:8+size], "\x00"))
+		for _, field := range tagFields {
+			if field.Info == id {
+				tags[field.Key] = value
+			}
+		}
+		body = body[min(len(body), 8+size+size%2):]
+	}
+	return tags
+}
+
+// ID3v2 text encodings
+const (
+	id3Latin1  = 0
+	id3UTF16   = 1 // with a byte order mark
+	id3UTF16BE = 2
+	id3UTF8    = 3
+)
+
+// encodeID3 returns an ID3v2.4 tag with UTF-8 text frames.
+func encodeID3(tags Tags) []byte {
+	var frames []byte
+	for _, field := range tagFields {
+		value := tags[field.Key]
+		if value == "" {
+			continue
+		}
+		body := []byte{id3UTF8}
+		if field.ID3 == "COMM" {
+			body = append(body, "eng\x00"...) // language and an empty description
+		}
+		body = append(body, value...)
+		frames = append(frames, field.ID3...)
+		frames = append(frames, synchsafe(len(body))...)
+		frames = append(frames, 0, 0)
+		frames = append(frames, body...)
+	}
+	tag := []byte{'I', 'D', '3', 4, 0, 0}
+	tag = append(tag, synchsafe(len(frames))...)
+	return append(tag, frames...)
+}
+
+// synchsafe encodes a size in four bytes of seven bits each.
+func synchsafe(n int) []byte {
+	return []byte{byte(n >> 21 & 0x7F), byte(n >> 14 & 0x7F), byte(n >> 7 & 0x7F), byte(n & 0x7F)}
+}
+
+func unsynchsafe(b []byte) int {
+	return int(b[0]&0x7F)<<21 | int(b[1]&0x7F)<<14 | int(b[2]&0x7F)<<7 | int(b[3]&0x7F)
+}
+
+// parseID3 reads the text frames muxic writes from an ID3v2.3 or v2.4 tag.
+// Unsynchronised tags and anything unreadable give no tags.
+func parseID3(data []byte) Tags {
+	tags := Tags{}
+	if len(data) < 10 || string(data[:3]) != "ID3" || (data[3] != 3 && data[3] != 4) || data[5]&0x80 != 0 {
+		return tags
+	}
+	version := data[3]
+	end := min(len(data), 10+unsynchsafe(data[6:10]))
+	pos := 10
+	if data[5]&0x40 != 0 && pos+4 <= end { // extended header
+		size := int(binary.BigEndian.Uint32(data[pos : pos+4]))
+		if version == 4 {
+			size = unsynchsafe(data[pos : pos+4])
+		} else {
+			size += 4
+		}
+		pos += size
+	}
+
+	for pos+10 <= end && data[pos] != 0 {
+		id := string(data[pos : pos+4])
+		size := int(binary.BigEndian.Uint32(data[pos+4 : pos+8]))
+		if version == 4 {
+			size = unsynchsafe(data[pos+4 : pos+8])
+		}
+		if pos+10+size > end {
+			break
+		}
+		body := data[pos+10 : pos+10+size]
+		pos += 10 + size
+
+		// TYER is the v2.3 year frame that TDRC replaced
+		if id == "TYER" {
+			id = "TDRC"
+		}
+		for _, field := range tagFields {
+			if field.ID3 == id && len(body) > 0 {
+				if text, ok := decodeID3Frame(id, body); ok {
+					tags[field.Key] = text
+				}
+			}
+		}
+	}
+	return tags
+}
+
+// decodeID3Frame returns the text of a text or comment frame.
+func decodeID3Frame(id string, body []byte) (string, bool) {
+	encoding, body := body[0], body[1:]
+	if id == "COMM" {
+		if len(body) < 3 {
+			return "", false
+		}
+		// Skip the language and the description
+		body = body[3:]
+		terminator := []byte{0}
+		if encoding == id3UTF16 || encoding == id3UTF16BE {
+			terminator = []byte{0, 0}
+		}
+		i := 0
+		for ; i+len(terminator) <= len(body); i += len(terminator) {
+			if bytes.Equal(body[i:i+len(terminator)], terminator) {
+				break
+			}
+		}
+		if i+len(terminator) > len(body) {
+			return "", false
+		}
+		body = body[i+len(terminator):]
+	}
+	return decodeID3Text(encoding, body)
+}
+
+func decodeID3Text(encoding byte, text []byte) (string, bool) {
+	switch encoding {
+	case id3Latin1:
+		runes := make([]rune, 0, len(text))
+		for _, b := range text {
+			runes = append(runes, rune(b))
+		}
+		return strings.TrimRight(string(runes), "\x00"), true
+	case id3UTF16, id3UTF16BE:
+		order := binary.ByteOrder(binary.BigEndian)
+		if encoding == id3UTF16 && len(text) >= 2 {
+			if text[0] == 0xFF && text[1] == 0xFE {
+				order = binary.LittleEndian
+			}
+			text = text[2:]
+		}
+		units := make([]uint16, len(text)/2)
+		for i := range units {
+			units[i] = order.Uint16(text[2*i:])
+		}
+		return strings.TrimRight(string(utf16.Decode(units)), "\x00"), true
+	case id3UTF8:
+		return strings.TrimRight(string(text), "\x00"), true
+	default:
+		return "", false
+	}
+}
+
+// flacVendor is the vendor string of the Vorbis comments muxic writes.
+const flacVendor = "muxic"
+
+// encodeVorbisComments returns the body of a VORBIS_COMMENT block.
+func encodeVorbisComments(tags Tags) []byte {
+	var comments []string
+	for _, field := range tagFields {
+		if value := tags[field.Key]; value != "" {
+			comments = append(comments, field.Vorbis+"="+value)
+		}
+	}
+	body := binary.LittleEndian.AppendUint32(nil, uint32(len(flacVendor)))
+	body = append(body, flacVendor...)
+	body = binary.LittleEndian.AppendUint32(body, uint32(len(comments)))
+	for _, comment := range comments {
+		body = binary.LittleEndian.AppendUint32(body, uint32(len(comment)))
+		body = append(body, comment...)
+	}
+	return body
+}
+
+// parseVorbisComments adds the comments muxic knows to tags. Field names are
+// case-insensitive.
+func parseVorbisComments(body []byte, tags Tags) {
+	read := func() (string, bool) {
+		if len(body) < 4 {
+			return "", false
+		}
+		n := int(binary.LittleEndian.Uint32(body))
+		if 4+n > len(body) {
+			return "", false
+		}
+		s := string(body[4 : 4+n])
+		body = body[4+n:]
+		return s, true
+	}
+	if _, ok := read(); !ok { // vendor
+		return
+	}
+	if len(body) < 4 {
+		return
+	}
+	count := int(binary.LittleEndian.Uint32(body))
+	body = body[4:]
+	for i := 0; i < count; i++ {
+		comment, ok := read()
+		if !ok {
+			return
+		}
+		name, value, _ := strings.Cut(comment, "=")
+		for _, field := range tagFields {
+			if strings.EqualFold(field.Vorbis, name) {
+				tags[field.Key] = value
+			}
+		}
+	}
+}
+
+// writeFLACComments replaces the Vorbis comments of a FLAC file. The file
+// is rewritten, so it goes to a temporary file first.
+func writeFLACComments(path string, tags Tags) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	blocks, framesStart, err := splitFLACMetadata(data)
+	if err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+	blocks = slices.DeleteFunc(blocks, func(b flacBlock) bool { return b.blockType == flacBlockVorbisComment })
+	// STREAMINFO has to stay first
+	blocks = slices.Insert(blocks, 1, flacBlock{blockType: flacBlockVorbisComment, body: encodeVorbisComments(tags)})
+
+	out := []byte(flacMagic)
+	for i, block := range blocks {
+		header := block.blockType
+		if i == len(blocks)-1 {
+			header |= 0x80
+		}
+		n := len(block.body)
+		out = append(out, header, byte(n>>16), byte(n>>8), byte(n))
+		out = append(out, block.body...)
+	}
+	out = append(out, data[framesStart:]...)
+
+	tempPath := path + ".tmp"
+	if err := os.WriteFile(tempPath, out, 0644); err != nil {
+		os.Remove(tempPath)
+		return err
+	}
+	return os.Rename(tempPath, path)
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"bytes"
+	"maps"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var testTags = Tags{
+	"title":   "Night Drive",
+	"artist":  "The Muxers",
+	"album":   "Städte",
+	"genre":   "Synthwave",
+	"comment": "Mastered at -14 LUFS",
+	"track":   "3/12",
+	"date":    "2024-05-01",
+}
+
+func TestExtractTagFlags(t *testing.T) {
+	args, tags, err := extractTagFlags([]string{"export", "--tag", "Title=A=B", "mix", "--tag=track=3"})
+	if err != nil {
+		t.Fatalf("extractTagFlags failed: %v", err)
+	}
+	if len(args) != 2 || args[0] != "export" || args[1] != "mix" {
+		t.Errorf("Expected the tag flags removed, got %v", args)
+	}
+	if !maps.Equal(tags, Tags{"title": "A=B", "track": "3"}) {
+		t.Errorf("Unexpected tags %v", tags)
+	}
+
+	for _, bad := range []string{"colour=red", "title", "track=three", "track=3/", "date=24", "date=2024-5"} {
+		if _, _, err := extractTagFlags([]string{"--tag", bad}); err == nil {
+			t.Errorf("Expected --tag %s to fail", bad)
+		}
+	}
+	if _, _, err := extractTagFlags([]string{"--tag"}); err == nil {
+		t.Error("Expected a missing value to fail")
+	}
+}
+
+func TestInfoListRoundTrip(t *testing.T) {
+	body := encodeInfoList(testTags)
+	if string(body[:4]) != "INFO" || !bytes.Contains(body, []byte("Night Drive\x00")) {
+		t.Fatalf("Unexpected LIST body %q", body)
+	}
+	if got := parseInfoList(body[4:]); !maps.Equal(got, testTags) {
+		t.Errorf("Expected %v, got %v", testTags, got)
+	}
+}
+
+func TestID3RoundTrip(t *testing.T) {
+	tag := encodeID3(testTags)
+	if string(tag[:5]) != "ID3\x04\x00" || unsynchsafe(tag[6:10]) != len(tag)-10 {
+		t.Fatalf("Unexpected ID3v2.4 header % X", tag[:10])
+	}
+	if got := parseID3(tag); !maps.Equal(got, testTags) {
+		t.Errorf("Expected %v, got %v", testTags, got)
+	}
+	if got := parseID3([]byte("ID3")); len(got) != 0 {
+		t.Errorf("Expected a truncated tag to give nothing, got %v", got)
+	}
+}
+
+// id3v23Frame builds an ID3v2.3 frame, whose size is a plain integer.
+func id3v23Frame(id string, body []byte) []byte {
+	n := len(body)
+	frame := append([]byte(id), byte(n>>24), byte(n>>16), byte(n>>8), byte(n), 0, 0)
+	return append(frame, body...)
+}
+
+func TestParseID3_v23(t *testing.T) {
+	// UTF-16 with a byte order mark, Latin-1 and a comment with a description
+	title := []byte{id3UTF16, 0xFF, 0xFE, 'S', 0, 0xE4, 0, 0, 0}
+	year := []byte{id3Latin1, '1', '9', '9', '9'}
+	comment := []byte{id3UTF16BE, 'e', 'n', 'g', 0, 'd', 0, 0, 0, 'H', 0, 'i'}
+	frames := append(id3v23Frame("TIT2", title), id3v23Frame("TYER", year)...)
+	frames = append(frames, id3v23Frame("COMM", comment)...)
+	frames = append(frames, id3v23Frame("APIC", []byte{0, 1, 2})...)
+	frames = append(frames, 0, 0, 0, 0) // padding
+	tag := append([]byte{'I', 'D', '3', 3, 0, 0}, synchsafe(len(frames))...)
+	tag = append(tag, frames...)
+
+	got := parseID3(tag)
+	expected := Tags{"title": "Sä", "date": "1999", "comment": "Hi"}
+	if !maps.Equal(got, expected) {
+		t.Errorf("Expected %v, got %v", expected, got)
+	}
+}
+
+func TestExportTags_WAV(t *testing.T) {
+	isolateProjects(t)
+	projectRoot = t.TempDir()
+	if err := ensureTracksDir(); err != nil {
+		t.Fatal(err)
+	}
+	writeTestTrack(t, getTrackPath("final_mix"), 2, []int16{1000, -1000, 2000, -2000})
+	_, original, _ := readWavFile(getTrackPath("final_mix"))
+
+	out := filepath.Join(t.TempDir(), "master.wav")
+	if err := exportTrack("final_mix", out, exportOptions{Tags: testTags}); err != nil {
+		t.Fatalf("exportTrack failed: %v", err)
+	}
+	got, err := readAudioTags(out)
+	if err != nil {
+		t.Fatalf("readAudioTags failed: %v", err)
+	}
+	if !maps.Equal(got, testTags) {
+		t.Errorf("Expected %v, got %v", testTags, got)
+	}
+	if _, data, err := readWavFile(out); err != nil || !bytes.Equal(data, original) {
+		t.Errorf("Expected the audio unchanged, got %v", err)
+	}
+
+	// Retagging replaces both the LIST INFO chunk and the ID3 tag
+	if err := writeAudioTags(out, Tags{"title": "Take Two"}); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := readAudioTags(out); !maps.Equal(got, Tags{"title": "Take Two"}) {
+		t.Errorf("Expected only the new title, got %v", got)
+	}
+
+	// The track itself stays untagged
+	if got, _ := readAudioTags(getTrackPath("final_mix")); len(got) != 0 {
+		t.Errorf("Expected the track untouched, got %v", got)
+	}
+
+	if err := exportTrack("final_mix", filepath.Join(t.TempDir(), "master.aiff"), exportOptions{Tags: testTags}); err == nil {
+		t.Error("Expected tagging an AIFF export to fail")
+	}
+}
+
+func TestExportTags_FLAC(t *testing.T) {
+	isolateProjects(t)
+	projectRoot = t.TempDir()
+	if err := ensureTracksDir(); err != nil {
+		t.Fatal(err)
+	}
+	writeTestTrack(t, getTrackPath("final_mix"), 1, []int16{0, 100, -100, 32767, -32768})
+
+	out := filepath.Join(t.TempDir(), "master.flac")
+	if err := exportTrack("final_mix", out, exportOptions{Tags: testTags, Compression: 5}); err != nil {
+		t.Fatalf("exportTrack failed: %v", err)
+	}
+	got, err := readAudioTags(out)
+	if err != nil {
+		t.Fatalf("readAudioTags failed: %v", err)
+	}
+	if !maps.Equal(got, testTags) {
+		t.Errorf("Expected %v, got %v", testTags, got)
+	}
+	_, original, _ := readWavFile(getTrackPath("final_mix"))
+	if _, data, err := readAudioFile(out); err != nil || !bytes.Equal(data, original) {
+		t.Errorf("Expected the FLAC file to decode unchanged, got %v", err)
+	}
+
+	// Retagging replaces the comment block rather than adding another
+	if err := writeAudioTags(out, Tags{"artist": "Someone"}); err != nil {
+		t.Fatal(err)
+	}
+	data, _ := os.ReadFile(out)
+	blocks, _, err := splitFLACMetadata(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	comments := 0
+	for _, block := range blocks {
+		if block.blockType == flacBlockVorbisComment {
+			comments++
+		}
+	}
+	if comments != 1 || blocks[0].blockType != flacBlockStreamInfo {
+		t.Errorf("Expected STREAMINFO then one comment block, got %+v", blocks)
+	}
+	if got, _ := readAudioTags(out); !maps.Equal(got, Tags{"artist": "Someone"}) {
+		t.Errorf("Expected only the new artist, got %v", got)
+	}
+}
+
+func TestMixTracks_Tags(t *testing.T) {
+	isolateProjects(t)
+	projectRoot = t.TempDir()
+	if err := ensureTracksDir(); err != nil {
+		t.Fatal(err)
+	}
+	writeTestTrack(t, getTrackPath("vocals"), 1, []int16{1000, -1000})
+
+	tags := Tags{"title": "Demo", "date": "2024"}
+	if err := mixTracks("final_mix", nil, defaultResampleQuality, ditherNone, tags); err != nil {
+		t.Fatalf("mixTracks failed: %v", err)
+	}
+	got, err := readAudioTags(getTrackPath("final_mix"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !maps.Equal(got, tags) {
+		t.Errorf("Expected %v, got %v", tags, got)
+	}
+}
+
+func TestTagsCarriedOver(t *testing.T) {
+	isolateProjects(t)
+	projectRoot = t.TempDir()
+	if err := ensureTracksDir(); err != nil {
+		t.Fatal(err)
+	}
+	writeTestTrack(t, getTrackPath("final_mix"), 1, []int16{8192, -4096, 0})
+	if err := writeAudioTags(getTrackPath("final_mix"), testTags); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := normalizeTrack("final_mix", normalizeTarget{Mode: normalizePeak, Level: 0}, "", ditherNone); err != nil {
+		t.Fatalf("normalizeTrack failed: %v", err)
+	}
+	if got, _ := readAudioTags(getTrackPath("final_mix")); !maps.Equal(got, testTags) {
+		t.Errorf("Expected normalize to keep the tags, got %v", got)
+	}
+
+	// Vorbis comments become WAV tags on import
+	exported := filepath.Join(t.TempDir(), "master.flac")
+	if err := exportTrack("final_mix", exported, exportOptions{Tags: Tags{"title": "Single"}, Compression: 5}); err != nil {
+		t.Fatal(err)
+	}
+	if err := importTrack(exported, "single"); err != nil {
+		t.Fatalf("importTrack failed: %v", err)
+	}
+	if got, _ := readAudioTags(getTrackPath("single")); !maps.Equal(got, Tags{"title": "Single"}) {
+		t.Errorf("Expected import to keep the tags, got %v", got)
+	}
+}
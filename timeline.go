@@ -0,0 +1,122 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultTempo       = 120.0
+	defaultBeatsPerBar = 4
+)
+
+const positionUsage = `Positions may be given as:
+  12.5 or 12.5s    seconds from the start of the song
+  88200smp         samples at the track's sample rate
+  9:1 or 9:2.5     bar and beat, counted from 1:1 at the project tempo`
+
+// BPM returns the project tempo, defaulting to 120.
+func (p Project) BPM() float64 {
+	if p.Tempo > 0 {
+		return p.Tempo
+	}
+	return defaultTempo
+}
+
+// Meter returns the number of beats in a bar, defaulting to 4.
+func (p Project) Meter() int {
+	if p.BeatsPerBar > 0 {
+		return p.BeatsPerBar
+	}
+	return defaultBeatsPerBar
+}
+
+// parsePosition converts a timeline position to seconds. Sample positions are
+// counted at the given rate, and bar:beat positions at the project tempo.
+func parsePosition(position string, sampleRate uint32, project Project) (float64, error) {
+	invalid := fmt.Errorf("invalid position '%s'\n%s", position, positionUsage)
+
+	var seconds float64
+	switch {
+	case strings.Contains(position, ":"):
+		barText, beatText, _ := strings.Cut(position, ":")
+		bar, err := strconv.Atoi(barText)
+		if err != nil || bar < 1 {
+			return 0, invalid
+		}
+		beat, err := strconv.ParseFloat(beatText, 64)
+		if err != nil || beat < 1 || beat >= float64(project.Meter())+1 {
+			return 0, invalid
+		}
+		beats := float64((bar-1)*project.Meter()) + beat - 1
+		seconds = beats * 60 / project.BPM()
+	case strings.HasSuffix(position, "smp"):
+		samples, err := strconv.ParseInt(strings.TrimSuffix(position, "smp"), 10, 64)
+		if err != nil || sampleRate == 0 {
+			return 0, invalid
+		}
+		seconds = float64(samples) / float64(sampleRate)
+	default:
+		value, err := strconv.ParseFloat(strings.TrimSuffix(position, "s"), 64)
+		if err != nil {
+			return 0, invalid
+		}
+		seconds = value
+	}
+
+	if seconds < 0 || math.IsNaN(seconds) || math.IsInf(seconds, 0) {
+		return 0, fmt.Errorf("position '%s' is before the start of the song", position)
+	}
+	return seconds, nil
+}
+
+// offsetFrames converts an offset in seconds to whole frames at a sample rate.
+func offsetFrames(seconds float64, sampleRate uint32) int {
+	return int(math.Round(seconds * float64(sampleRate)))
+}
+
+// formatOffset renders an offset as a timestamp with the bar and beat it
+// falls on, e.g. "0:04.0 (bar 3:1)".
+func formatOffset(seconds float64, project Project) string {
+	beats := math.Round(seconds*project.BPM()/60*100) / 100
+	bar := int(beats)/project.Meter() + 1
+	beat := math.Mod(beats, float64(project.Meter())) + 1
+	timestamp := formatTimestamp(time.Duration(seconds * float64(time.Second)))
+	return fmt.Sprintf("%s (bar %d:%g)", timestamp, bar, beat)
+}
+
+// tempoCommand shows or sets the tempo and meter used for bar:beat positions.
+func tempoCommand(args []string) error {
+	project, err := LoadProject()
+	if err != nil {
+		return err
+	}
+
+	if len(args) == 0 {
+		fmt.Printf("Tempo: %g BPM, %d beats per bar\n", project.BPM(), project.Meter())
+		return nil
+	}
+
+	bpm, err := strconv.ParseFloat(args[0], 64)
+	if err != nil || bpm <= 0 {
+		return fmt.Errorf("invalid tempo '%s'", args[0])
+	}
+	project.Tempo = bpm
+	if len(args) >= 2 {
+		beatsPerBar, err := strconv.Atoi(args[1])
+		if err != nil || beatsPerBar < 1 {
+			return errors.New("beats per bar must be a positive whole number")
+		}
+		project.BeatsPerBar = beatsPerBar
+	}
+
+	if err := project.Save(); err != nil {
+		return err
+	}
+	fmt.Printf("Tempo set to %g BPM, %d beats per bar\n", project.BPM(), project.Meter())
+	return nil
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestParsePosition(t *testing.T) {
+	project := Project{Tempo: 90, BeatsPerBar: 3}
+	tests := []struct {
+		position string
+		want     float64
+	}{
+		{"12.5", 12.5},
+		{"4s", 4},
+		{"22050smp", 0.5},
+		{"1:1", 0},
+		{"2:1", 2},   // three beats at 90 BPM
+		{"3:2.5", 5}, // 7.5 beats
+	}
+	for _, tt := range tests {
+		got, err := parsePosition(tt.position, 44100, project)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.position, err)
+			continue
+		}
+		if math.Abs(got-tt.want) > 1e-9 {
+			t.Errorf("%s: expected %g seconds, got %g", tt.position, tt.want, got)
+		}
+	}
+
+	for _, bad := range []string{"", "abc", "-1", "0:1", "1:4", "1:0", "1.5smp"} {
+		if _, err := parsePosition(bad, 44100, project); err == nil {
+			t.Errorf("%q: expected an error", bad)
+		}
+	}
+}
+
+func TestFormatOffset(t *testing.T) {
+	if got := formatOffset(4, Project{}); got != "0:04.0 (bar 3:1)" {
+		t.Errorf("Unexpected offset at 120 BPM: %s", got)
+	}
+	if got := formatOffset(0.75, Project{Tempo: 120, BeatsPerBar: 3}); got != "0:00.8 (bar 1:2.5)" {
+		t.Errorf("Unexpected offset at 120 BPM in 3/4: %s", got)
+	}
+}
@@ -0,0 +1,849 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+)
+
+const (
+	waveFormatPCM        = 0x0001
+	waveFormatIEEEFloat  = 0x0003
+	waveFormatExtensible = 0xFFFE
+)
+
+// GUID is a Windows GUID in the byte order it is stored in a fmt chunk.
+type GUID [16]byte
+
+// WAVEFORMATEXTENSIBLE sub-formats are the format tag followed by a fixed
+// suffix, so a GUID carrying any tag can be built from it.
+var (
+	subFormatPCM       = subFormatFor(waveFormatPCM)
+	subFormatIEEEFloat = subFormatFor(waveFormatIEEEFloat)
+)
+
+func subFormatFor(tag uint16) GUID {
+	guid := GUID{0, 0, 0x00, 0x00, 0x00, 0x00, 0x10, 0x00, 0x80, 0x00, 0x00, 0xAA, 0x00, 0x38, 0x9B, 0x71}
+	binary.LittleEndian.PutUint16(guid[0:2], tag)
+	return guid
+}
+
+// extensibleSize is the number of bytes following cbSize in a
+// WAVEFORMATEXTENSIBLE fmt chunk.
+const extensibleSize = 22
+
+// WaveFormat describes how sample data is laid out. It mirrors the Windows
+// WAVEFORMATEXTENSIBLE structure so it can be filled from a device or a file.
+type WaveFormat struct {
+	FormatTag     uint16
+	Channels      uint16
+	SampleRate    uint32
+	ByteRate      uint32
+	BlockAlign    uint16
+	BitsPerSample uint16
+	// ValidBitsPerSample, ChannelMask and SubFormat are only meaningful when
+	// FormatTag is waveFormatExtensible.
+	ValidBitsPerSample uint16
+	ChannelMask        uint32
+	SubFormat          GUID
+}
+
+// Encoding returns the format tag that describes the samples themselves,
+// looking through EXTENSIBLE to its sub-format.
+func (wfx *WaveFormat) Encoding() uint16 {
+	if wfx.FormatTag != waveFormatExtensible {
+		return wfx.FormatTag
+	}
+	if tag := binary.LittleEndian.Uint16(wfx.SubFormat[0:2]); wfx.SubFormat == subFormatFor(tag) {
+		return tag
+	}
+	return 0
+}
+
+// newPCMFormat returns an integer PCM format with derived block align and
+// byte rate. Formats plain WAVEFORMATEX cannot describe unambiguously, those
+// with more than two channels or more than 16 bits, use EXTENSIBLE.
+func newPCMFormat(channels uint16, sampleRate uint32, bitsPerSample uint16) *WaveFormat {
+	blockAlign := channels * (bitsPerSample / 8)
+	wfx := &WaveFormat{
+		FormatTag:     waveFormatPCM,
+		Channels:      channels,
+		SampleRate:    sampleRate,
+		ByteRate:      sampleRate * uint32(blockAlign),
+		BlockAlign:    blockAlign,
+		BitsPerSample: bitsPerSample,
+	}
+	if channels > 2 || bitsPerSample > 16 {
+		wfx.FormatTag = waveFormatExtensible
+		wfx.ValidBitsPerSample = bitsPerSample
+		wfx.ChannelMask = defaultChannelMask(channels)
+		wfx.SubFormat = subFormatPCM
+	}
+	return wfx
+}
+
+// Speaker positions for the channel mask, in WAV channel order.
+const (
+	speakerFrontLeft   = 0x1
+	speakerFrontRight  = 0x2
+	speakerFrontCenter = 0x4
+	speakerLFE         = 0x8
+	speakerBackLeft    = 0x10
+	speakerBackRight   = 0x20
+	speakerSideLeft    = 0x200
+	speakerSideRight   = 0x400
+)
+
+// defaultChannelMask returns the usual speaker layout for a channel count:
+// mono, stereo, 3.0, quad, 5.0, 5.1 and 7.1. Other counts are left
+// unassigned.
+func defaultChannelMask(channels uint16) uint32 {
+	switch channels {
+	case 1:
+		return speakerFrontCenter
+	case 2:
+		return speakerFrontLeft | speakerFrontRight
+	case 3:
+		return speakerFrontLeft | speakerFrontRight | speakerFrontCenter
+	case 4:
+		return speakerFrontLeft | speakerFrontRight | speakerBackLeft | speakerBackRight
+	case 5:
+		return speakerFrontLeft | speakerFrontRight | speakerFrontCenter | speakerBackLeft | speakerBackRight
+	case 6:
+		return speakerFrontLeft | speakerFrontRight | speakerFrontCenter | speakerLFE | speakerBackLeft | speakerBackRight
+	case 8:
+		return speakerFrontLeft | speakerFrontRight | speakerFrontCenter | speakerLFE |
+			speakerBackLeft | speakerBackRight | speakerSideLeft | speakerSideRight
+	default:
+		return 0
+	}
+}
+
+// newFloatFormat returns a 32-bit IEEE float format, using EXTENSIBLE for
+// more than two channels.
+func newFloatFormat(channels uint16, sampleRate uint32) *WaveFormat {
+	wfx := &WaveFormat{
+		FormatTag:     waveFormatIEEEFloat,
+		Channels:      channels,
+		SampleRate:    sampleRate,
+		ByteRate:      sampleRate * uint32(channels) * 4,
+		BlockAlign:    channels * 4,
+		BitsPerSample: 32,
+	}
+	if channels > 2 {
+		wfx.FormatTag = waveFormatExtensible
+		wfx.ValidBitsPerSample = 32
+		wfx.ChannelMask = defaultChannelMask(channels)
+		wfx.SubFormat = subFormatIEEEFloat
+	}
+	return wfx
+}
+
+// saveWavFile writes sample data as a WAV file, or as RF64 when the file
+// would be larger than the 32-bit RIFF sizes can describe. Extra chunks go
+// ahead of the fmt chunk, as with createLongWavWriter.
+func saveWavFile(path string, audioData []byte, wfx *WaveFormat, extra ...[]byte) error {
+	chunks := bytes.Join(extra, nil)
+	rf64 := int64(len(audioData)) > maxRIFFDataSize(wavHeaderSize(wfx, len(chunks)))
+	w, err := newWavWriter(path, wfx, rf64, chunks)
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(audioData); err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
+
+// fmtChunkSize returns the size of the fmt chunk for a format. Plain PCM uses
+// the original 16-byte chunk; every other format carries cbSize, which is 22
+// for EXTENSIBLE and 0 otherwise.
+func fmtChunkSize(wfx *WaveFormat) uint32 {
+	switch wfx.FormatTag {
+	case waveFormatPCM:
+		return 16
+	case waveFormatExtensible:
+		return 18 + extensibleSize
+	default:
+		return 18
+	}
+}
+
+// hasFactChunk reports whether a format needs a fact chunk. The spec requires
+// one for every encoding other than integer PCM.
+// wavHeaderSize returns the length of a plain WAV header up to the start of
+// the samples, with extraSize bytes of extra chunks ahead of the fmt chunk.
+func wavHeaderSize(wfx *WaveFormat, extraSize int) int64 {
+	size := 12 + int64(extraSize) + 8 + int64(fmtChunkSize(wfx)) + 8
+	if hasFactChunk(wfx) {
+		size += 12
+	}
+	return size
+}
+
+// maxRIFFDataSize returns the most sample bytes a plain RIFF file can hold
+// when its data starts at dataOffset, so that the RIFF size, which counts
+// the header, the data and its pad byte, still fits in 32 bits.
+func maxRIFFDataSize(dataOffset int64) int64 {
+	size := math.MaxUint32 - (dataOffset - 8)
+	return size - size%2
+}
+
+func hasFactChunk(wfx *WaveFormat) bool {
+	return wfx.Encoding() != waveFormatPCM
+}
+
+// factLengthOffset returns where the frame count in a written fact chunk
+// lives, or 0 if the format has none.
+func factLengthOffset(wfx *WaveFormat) int64 {
+	if !hasFactChunk(wfx) {
+		return 0
+	}
+	return 12 + 8 + int64(fmtChunkSize(wfx)) + 8
+}
+
+// RF64 (EBU Tech 3306, and BW64 from ITU-R BS.2088) lifts the 4 GB limit by
+// moving the sizes into a ds64 chunk right after the header and setting the
+// 32-bit fields to rf64Placeholder. Writers that may need it reserve the
+// space with a JUNK chunk of the same size, renamed once the data outgrows
+// RIFF.
+const (
+	ds64Size        = 28 // RIFF size, data size and frame count, then a table length of 0
+	reservedSize    = 8 + ds64Size
+	rf64Placeholder = math.MaxUint32
+)
+
+// ds64 holds the 64-bit sizes of an RF64 file.
+type ds64 struct {
+	riffSize    uint64
+	dataSize    uint64
+	sampleCount uint64
+}
+
+func parseDS64Chunk(body []byte) (*ds64, error) {
+	if len(body) < 24 {
+		return nil, fmt.Errorf("ds64 chunk is %d bytes, expected at least 24", len(body))
+	}
+	return &ds64{
+		riffSize:    binary.LittleEndian.Uint64(body[0:8]),
+		dataSize:    binary.LittleEndian.Uint64(body[8:16]),
+		sampleCount: binary.LittleEndian.Uint64(body[16:24]),
+	}, nil
+}
+
+// readRIFFHeader checks the 12-byte file header, accepting RF64 and BW64 as
+// well as RIFF, and reports whether the sizes live in a ds64 chunk.
+func readRIFFHeader(r io.Reader) (bool, error) {
+	header := make([]byte, 12)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return false, fmt.Errorf("not a WAV file: file is too short for a RIFF header")
+	}
+	rf64 := false
+	switch string(header[0:4]) {
+	case "RIFF":
+	case "RF64", "BW64":
+		rf64 = true
+	default:
+		return false, fmt.Errorf("not a WAV file: expected RIFF header, got %q", header[0:4])
+	}
+	if string(header[8:12]) != "WAVE" {
+		return false, fmt.Errorf("not a WAV file: expected WAVE form type, got %q", header[8:12])
+	}
+	return rf64, nil
+}
+
+// rf64ChunkSize returns the real size of a chunk, looking it up in ds64 when
+// the 32-bit field holds the placeholder. Only the data chunk is looked up;
+// muxic never writes the ds64 table that sizes other chunks.
+func rf64ChunkSize(chunkID string, size int64, sizes *ds64) (int64, error) {
+	if size != rf64Placeholder || sizes == nil || chunkID != "data" {
+		return size, nil
+	}
+	if sizes.dataSize > math.MaxInt64 {
+		return 0, fmt.Errorf("ds64 data size %d is out of range", sizes.dataSize)
+	}
+	return int64(sizes.dataSize), nil
+}
+
+// writeWavHeader writes the RIFF header, a JUNK chunk reserving room for
+// ds64 if asked, any extra chunks, the fmt chunk, a fact chunk where
+// required and the header of an empty data chunk. The sizes are patched once
+// the data is written.
+func writeWavHeader(f io.Writer, wfx *WaveFormat, reserveDS64 bool, extra []byte) error {
+	fmtSize := fmtChunkSize(wfx)
+
+	// WAV header
+	if _, err := f.Write([]byte("RIFF")); err != nil {
+		return err
+	}
+	if err := binary.Write(f, binary.LittleEndian, uint32(0)); err != nil {
+		return err
+	}
+	if _, err := f.Write([]byte("WAVE")); err != nil {
+		return err
+	}
+
+	if reserveDS64 {
+		if _, err := f.Write([]byte("JUNK")); err != nil {
+			return err
+		}
+		if err := binary.Write(f, binary.LittleEndian, uint32(ds64Size)); err != nil {
+			return err
+		}
+		if _, err := f.Write(make([]byte, ds64Size)); err != nil {
+			return err
+		}
+	}
+	if _, err := f.Write(extra); err != nil {
+		return err
+	}
+
+	// fmt chunk
+	if _, err := f.Write([]byte("fmt ")); err != nil {
+		return err
+	}
+	if err := binary.Write(f, binary.LittleEndian, fmtSize); err != nil {
+		return err
+	}
+
+	if err := binary.Write(f, binary.LittleEndian, wfx.FormatTag); err != nil {
+		return err
+	}
+	if err := binary.Write(f, binary.LittleEndian, wfx.Channels); err != nil {
+		return err
+	}
+	if err := binary.Write(f, binary.LittleEndian, wfx.SampleRate); err != nil {
+		return err
+	}
+	if err := binary.Write(f, binary.LittleEndian, wfx.ByteRate); err != nil {
+		return err
+	}
+	if err := binary.Write(f, binary.LittleEndian, wfx.BlockAlign); err != nil {
+		return err
+	}
+	if err := binary.Write(f, binary.LittleEndian, wfx.BitsPerSample); err != nil {
+		return err
+	}
+
+	if fmtSize > 16 {
+		if err := binary.Write(f, binary.LittleEndian, uint16(fmtSize-18)); err != nil {
+			return err
+		}
+	}
+	if wfx.FormatTag == waveFormatExtensible {
+		if err := binary.Write(f, binary.LittleEndian, wfx.ValidBitsPerSample); err != nil {
+			return err
+		}
+		if err := binary.Write(f, binary.LittleEndian, wfx.ChannelMask); err != nil {
+			return err
+		}
+		if _, err := f.Write(wfx.SubFormat[:]); err != nil {
+			return err
+		}
+	}
+
+	// fact chunk: the number of frames, patched along with the sizes
+	if hasFactChunk(wfx) {
+		if _, err := f.Write([]byte("fact")); err != nil {
+			return err
+		}
+		if err := binary.Write(f, binary.LittleEndian, uint32(4)); err != nil {
+			return err
+		}
+		if err := binary.Write(f, binary.LittleEndian, uint32(0)); err != nil {
+			return err
+		}
+	}
+
+	// data chunk
+	if _, err := f.Write([]byte("data")); err != nil {
+		return err
+	}
+	return binary.Write(f, binary.LittleEndian, uint32(0))
+}
+
+// wavLayout records where a WAV file's size fields are, so they can be
+// patched once the length of the data is known.
+type wavLayout struct {
+	reserved   bool  // a JUNK or ds64 chunk follows the RIFF header
+	factOffset int64 // frame count in the fact chunk, or 0 if none
+	dataOffset int64 // first byte of sample data
+}
+
+// WavWriter streams sample data to a WAV file as it arrives. The header is
+// written up front with empty sizes, which Close patches to the real length.
+type WavWriter struct {
+	f        *os.File
+	format   *WaveFormat
+	layout   wavLayout
+	dataSize int64
+}
+
+// createWavWriter starts a plain WAV file, which can hold up to 4 GB
+// including its header.
+func createWavWriter(path string, wfx *WaveFormat) (*WavWriter, error) {
+	return newWavWriter(path, wfx, false, nil)
+}
+
+// createLongWavWriter starts a WAV file with room reserved for a ds64
+// chunk, so it turns into RF64 if the data outgrows 4 GB. Recordings and
+// the file backend's playback output use it since their length is not known
+// up front. Extra chunks, such as metadata encoded with appendRIFFChunk, go
+// ahead of the fmt chunk.
+func createLongWavWriter(path string, wfx *WaveFormat, extra ...[]byte) (*WavWriter, error) {
+	return newWavWriter(path, wfx, true, bytes.Join(extra, nil))
+}
+
+func newWavWriter(path string, wfx *WaveFormat, reserveDS64 bool, extra []byte) (*WavWriter, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := writeWavHeader(f, wfx, reserveDS64, extra); err != nil {
+		f.Close()
+		return nil, err
+	}
+	dataOffset, err := f.Seek(0, io.SeekCurrent)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	layout := wavLayout{reserved: reserveDS64, dataOffset: dataOffset}
+	if offset := factLengthOffset(wfx); offset != 0 {
+		layout.factOffset = offset + int64(len(extra))
+		if reserveDS64 {
+			layout.factOffset += reservedSize
+		}
+	}
+	return &WavWriter{f: f, format: wfx, layout: layout}, nil
+}
+
+func (w *WavWriter) Format() *WaveFormat {
+	return w.format
+}
+
+// DataSize returns the number of sample bytes written so far.
+func (w *WavWriter) DataSize() int64 {
+	return w.dataSize
+}
+
+func (w *WavWriter) Write(p []byte) (int, error) {
+	if limit := maxRIFFDataSize(w.layout.dataOffset); !w.layout.reserved && w.dataSize+int64(len(p)) > limit {
+		return 0, fmt.Errorf("WAV data would exceed the %d byte limit", limit)
+	}
+	n, err := w.f.Write(p)
+	w.dataSize += int64(n)
+	return n, err
+}
+
+// Close pads the data chunk to an even length, patches the RIFF and data
+// sizes and closes the file.
+func (w *WavWriter) Close() error {
+	err := w.finalize()
+	if closeErr := w.f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+func (w *WavWriter) finalize() error {
+	if w.dataSize%2 == 1 {
+		if _, err := w.f.Write([]byte{0}); err != nil {
+			return err
+		}
+	}
+	return w.writeSizes()
+}
+
+// Sync patches the header to describe the data written so far and flushes
+// the file to disk, so a crash loses at most what came after the last Sync.
+func (w *WavWriter) Sync() error {
+	if err := w.writeSizes(); err != nil {
+		return err
+	}
+	return w.f.Sync()
+}
+
+func (w *WavWriter) writeSizes() error {
+	return patchWavSizes(w.f, w.layout, w.dataSize, w.format.BlockAlign)
+}
+
+// patchWavSizes rewrites the sizes and frame count in a WAV file's header to
+// describe dataSize bytes of samples. Files with a reserved chunk become
+// RF64 when the file outgrows the 32-bit fields, and plain RIFF otherwise.
+func patchWavSizes(f io.WriterAt, layout wavLayout, dataSize int64, blockAlign uint16) error {
+	riffSize := layout.dataOffset - 8 + dataSize + dataSize%2
+	frames := dataSize / int64(blockAlign)
+
+	if riffSize <= math.MaxUint32 {
+		header := binary.LittleEndian.AppendUint32([]byte("RIFF"), uint32(riffSize))
+		if _, err := f.WriteAt(header, 0); err != nil {
+			return err
+		}
+		if layout.reserved {
+			if _, err := f.WriteAt([]byte("JUNK"), 12); err != nil {
+				return err
+			}
+		}
+		if err := patchDataSize(f, layout.dataOffset, uint32(dataSize)); err != nil {
+			return err
+		}
+		if layout.factOffset != 0 {
+			return patchFactLength(f, layout.factOffset, uint32(frames))
+		}
+		return nil
+	}
+
+	if !layout.reserved {
+		return fmt.Errorf("%d bytes of audio needs RF64, but the file has no room for a ds64 chunk", dataSize)
+	}
+	header := binary.LittleEndian.AppendUint32([]byte("RF64"), rf64Placeholder)
+	if _, err := f.WriteAt(header, 0); err != nil {
+		return err
+	}
+	chunk := binary.LittleEndian.AppendUint32([]byte("ds64"), ds64Size)
+	chunk = binary.LittleEndian.AppendUint64(chunk, uint64(riffSize))
+	chunk = binary.LittleEndian.AppendUint64(chunk, uint64(dataSize))
+	chunk = binary.LittleEndian.AppendUint64(chunk, uint64(frames))
+	chunk = binary.LittleEndian.AppendUint32(chunk, 0)
+	if _, err := f.WriteAt(chunk, 12); err != nil {
+		return err
+	}
+	if err := patchDataSize(f, layout.dataOffset, rf64Placeholder); err != nil {
+		return err
+	}
+	if layout.factOffset != 0 {
+		return patchFactLength(f, layout.factOffset, rf64Placeholder)
+	}
+	return nil
+}
+
+func patchDataSize(f io.WriterAt, dataOffset int64, dataSize uint32) error {
+	_, err := f.WriteAt(binary.LittleEndian.AppendUint32(nil, dataSize), dataOffset-4)
+	return err
+}
+
+// patchFactLength rewrites the frame count held in a fact chunk.
+func patchFactLength(f io.WriterAt, offset int64, frames uint32) error {
+	_, err := f.WriteAt(binary.LittleEndian.AppendUint32(nil, frames), offset)
+	return err
+}
+
+// repairWavFile fixes the RIFF and data sizes of a WAV file whose writer never
+// got to patch them, e.g. after a crash mid-recording. The data chunk is taken
+// to run to the end of the file, minus any trailing partial frame. It returns
+// the number of sample bytes kept.
+func repairWavFile(path string) (int64, error) {
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+
+	if _, err := readRIFFHeader(f); err != nil {
+		return 0, fmt.Errorf("%s: not a WAV file", path)
+	}
+
+	// Walk the header chunks up to "data"; only its size is untrustworthy
+	var wfx *WaveFormat
+	var layout wavLayout
+	chunkHeader := make([]byte, 8)
+	for first := true; ; first = false {
+		if _, err := io.ReadFull(f, chunkHeader); err != nil {
+			return 0, fmt.Errorf("%s: no data chunk to recover", path)
+		}
+		chunkID := string(chunkHeader[0:4])
+		chunkSize := int64(binary.LittleEndian.Uint32(chunkHeader[4:8]))
+		if chunkID == "data" {
+			break
+		}
+		if first && (chunkID == "JUNK" || chunkID == "ds64") && chunkSize == ds64Size {
+			layout.reserved = true
+		}
+		if chunkID == "fact" && chunkSize >= 4 {
+			if layout.factOffset, err = f.Seek(0, io.SeekCurrent); err != nil {
+				return 0, err
+			}
+		}
+
+		body, err := readChunkBody(f, chunkID, chunkSize+chunkSize%2)
+		if err != nil {
+			return 0, fmt.Errorf("%s: %v", path, err)
+		}
+		if chunkID == "fmt " {
+			if wfx, err = parseFmtChunk(body[:chunkSize]); err != nil {
+				return 0, fmt.Errorf("%s: %v", path, err)
+			}
+		}
+	}
+	if wfx == nil {
+		return 0, fmt.Errorf("%s: missing fmt chunk", path)
+	}
+
+	if layout.dataOffset, err = f.Seek(0, io.SeekCurrent); err != nil {
+		return 0, err
+	}
+	dataSize := info.Size() - layout.dataOffset
+	dataSize -= dataSize % int64(wfx.BlockAlign)
+	if limit := maxRIFFDataSize(layout.dataOffset); !layout.reserved && dataSize > limit {
+		dataSize = limit - limit%int64(wfx.BlockAlign)
+	}
+
+	if err := f.Truncate(layout.dataOffset + dataSize); err != nil {
+		return 0, err
+	}
+	if dataSize%2 == 1 {
+		if _, err := f.WriteAt([]byte{0}, layout.dataOffset+dataSize); err != nil {
+			return 0, err
+		}
+	}
+
+	if err := patchWavSizes(f, layout, dataSize, wfx.BlockAlign); err != nil {
+		return 0, err
+	}
+	return dataSize, f.Sync()
+}
+
+// readWavFile parses a RIFF/WAVE file and returns its format and raw sample data.
+func readWavFile(path string) (*WaveFormat, []byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+
+	wfx, audioData, err := parseWav(bufio.NewReader(f))
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return wfx, audioData, nil
+}
+
+// readWavHeader returns a WAV file's format and the size of its sample data
+// without reading the samples themselves.
+func readWavHeader(path string) (*WaveFormat, int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer f.Close()
+
+	rf64, err := readRIFFHeader(f)
+	if err != nil {
+		return nil, 0, fmt.Errorf("%s: not a WAV file", path)
+	}
+
+	var wfx *WaveFormat
+	var sizes *ds64
+	chunkHeader := make([]byte, 8)
+	for {
+		if _, err := io.ReadFull(f, chunkHeader); err != nil {
+			return nil, 0, fmt.Errorf("%s: missing data chunk", path)
+		}
+		chunkID := string(chunkHeader[0:4])
+		chunkSize, err := rf64ChunkSize(chunkID, int64(binary.LittleEndian.Uint32(chunkHeader[4:8])), sizes)
+		if err != nil {
+			return nil, 0, fmt.Errorf("%s: %v", path, err)
+		}
+
+		switch chunkID {
+		case "ds64":
+			body, err := readChunkBody(f, chunkID, chunkSize)
+			if err != nil {
+				return nil, 0, fmt.Errorf("%s: %v", path, err)
+			}
+			if rf64 {
+				if sizes, err = parseDS64Chunk(body); err != nil {
+					return nil, 0, fmt.Errorf("%s: %v", path, err)
+				}
+			}
+		case "fmt ":
+			body, err := readChunkBody(f, chunkID, chunkSize)
+			if err != nil {
+				return nil, 0, fmt.Errorf("%s: %v", path, err)
+			}
+			if wfx, err = parseFmtChunk(body); err != nil {
+				return nil, 0, fmt.Errorf("%s: %v", path, err)
+			}
+		case "data":
+			if wfx == nil {
+				return nil, 0, fmt.Errorf("%s: missing fmt chunk", path)
+			}
+			if rf64 && sizes == nil {
+				return nil, 0, fmt.Errorf("%s: RF64 file is missing its ds64 chunk", path)
+			}
+			return wfx, chunkSize, nil
+		default:
+			if _, err := f.Seek(chunkSize, io.SeekCurrent); err != nil {
+				return nil, 0, err
+			}
+		}
+		if chunkSize%2 == 1 {
+			if _, err := f.Seek(1, io.SeekCurrent); err != nil {
+				return nil, 0, err
+			}
+		}
+	}
+}
+
+// parseWav reads a RIFF/WAVE or RF64 stream chunk by chunk. Chunks other
+// than "fmt ", "data" and "ds64" (LIST, bext, JUNK, fact, ...) are skipped,
+// honouring the pad byte that follows odd-sized chunks.
+func parseWav(r io.Reader) (*WaveFormat, []byte, error) {
+	rf64, err := readRIFFHeader(r)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var wfx *WaveFormat
+	var audioData []byte
+	var sizes *ds64
+
+	chunkHeader := make([]byte, 8)
+	for {
+		n, err := io.ReadFull(r, chunkHeader)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("truncated chunk header (%d of 8 bytes)", n)
+		}
+		chunkID := string(chunkHeader[0:4])
+		chunkSize, err := rf64ChunkSize(chunkID, int64(binary.LittleEndian.Uint32(chunkHeader[4:8])), sizes)
+		if err != nil {
+			return nil, nil, err
+		}
+		if rf64 && chunkID == "data" && sizes == nil {
+			return nil, nil, errors.New("RF64 file is missing its ds64 chunk")
+		}
+
+		switch chunkID {
+		case "ds64":
+			body, err := readChunkBody(r, chunkID, chunkSize)
+			if err != nil {
+				return nil, nil, err
+			}
+			if rf64 {
+				if sizes, err = parseDS64Chunk(body); err != nil {
+					return nil, nil, err
+				}
+			}
+		case "fmt ", "data":
+			if chunkID == "fmt " && wfx != nil {
+				return nil, nil, fmt.Errorf("duplicate fmt chunk")
+			}
+			if chunkID == "data" && audioData != nil {
+				return nil, nil, fmt.Errorf("duplicate data chunk")
+			}
+			body, err := readChunkBody(r, chunkID, chunkSize)
+			if err != nil {
+				return nil, nil, err
+			}
+			if chunkID == "fmt " {
+				if wfx, err = parseFmtChunk(body); err != nil {
+					return nil, nil, err
+				}
+			} else {
+				audioData = body
+			}
+		default:
+			if _, err := io.CopyN(io.Discard, r, chunkSize); err != nil {
+				return nil, nil, fmt.Errorf("truncated %q chunk: expected %d bytes", chunkID, chunkSize)
+			}
+		}
+
+		// Odd-sized chunks are followed by a pad byte. Writers that leave it
+		// off the final chunk are common enough to tolerate.
+		if chunkSize%2 == 1 {
+			if _, err := io.CopyN(io.Discard, r, 1); err != nil && err != io.EOF {
+				return nil, nil, err
+			}
+		}
+	}
+
+	if wfx == nil {
+		return nil, nil, fmt.Errorf("missing fmt chunk")
+	}
+	if audioData == nil {
+		return nil, nil, fmt.Errorf("missing data chunk")
+	}
+	if len(audioData)%int(wfx.BlockAlign) != 0 {
+		return nil, nil, fmt.Errorf("data chunk size %d is not a multiple of the %d-byte block align", len(audioData), wfx.BlockAlign)
+	}
+	return wfx, audioData, nil
+}
+
+func readChunkBody(r io.Reader, chunkID string, size int64) ([]byte, error) {
+	// Read through a LimitReader rather than allocating size bytes up front,
+	// so a corrupt size field cannot trigger a huge allocation.
+	body, err := io.ReadAll(io.LimitReader(r, size))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(body)) != size {
+		return nil, fmt.Errorf("truncated %q chunk: expected %d bytes, got %d", chunkID, size, len(body))
+	}
+	return body, nil
+}
+
+func parseFmtChunk(body []byte) (*WaveFormat, error) {
+	if len(body) < 16 {
+		return nil, fmt.Errorf("fmt chunk is %d bytes, expected at least 16", len(body))
+	}
+	wfx := &WaveFormat{
+		FormatTag:     binary.LittleEndian.Uint16(body[0:2]),
+		Channels:      binary.LittleEndian.Uint16(body[2:4]),
+		SampleRate:    binary.LittleEndian.Uint32(body[4:8]),
+		ByteRate:      binary.LittleEndian.Uint32(body[8:12]),
+		BlockAlign:    binary.LittleEndian.Uint16(body[12:14]),
+		BitsPerSample: binary.LittleEndian.Uint16(body[14:16]),
+	}
+	if len(body) >= 18 {
+		cbSize := int(binary.LittleEndian.Uint16(body[16:18]))
+		if 18+cbSize > len(body) {
+			return nil, fmt.Errorf("fmt chunk declares %d extension bytes but only %d follow", cbSize, len(body)-18)
+		}
+		if wfx.FormatTag == waveFormatExtensible {
+			if cbSize < extensibleSize {
+				return nil, fmt.Errorf("EXTENSIBLE fmt chunk has %d extension bytes, expected %d", cbSize, extensibleSize)
+			}
+			wfx.ValidBitsPerSample = binary.LittleEndian.Uint16(body[18:20])
+			wfx.ChannelMask = binary.LittleEndian.Uint32(body[20:24])
+			copy(wfx.SubFormat[:], body[24:40])
+		}
+	} else if wfx.FormatTag == waveFormatExtensible {
+		return nil, errors.New("EXTENSIBLE fmt chunk is missing its extension")
+	}
+
+	if wfx.Channels == 0 {
+		return nil, errors.New("fmt chunk declares zero channels")
+	}
+	if wfx.SampleRate == 0 {
+		return nil, errors.New("fmt chunk declares a zero sample rate")
+	}
+	if wfx.BitsPerSample == 0 {
+		return nil, errors.New("fmt chunk declares zero bits per sample")
+	}
+	expectedAlign := wfx.Channels * ((wfx.BitsPerSample + 7) / 8)
+	if wfx.BlockAlign != expectedAlign {
+		return nil, fmt.Errorf("fmt chunk block align is %d, expected %d for %d channels of %d bits",
+			wfx.BlockAlign, expectedAlign, wfx.Channels, wfx.BitsPerSample)
+	}
+	if wfx.ValidBitsPerSample > wfx.BitsPerSample {
+		return nil, fmt.Errorf("fmt chunk declares %d valid bits in a %d-bit container", wfx.ValidBitsPerSample, wfx.BitsPerSample)
+	}
+	return wfx, nil
+}
@@ -0,0 +1,437 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func buildChunk(id string, body []byte) []byte {
+	chunk := []byte(id)
+	chunk = binary.LittleEndian.AppendUint32(chunk, uint32(len(body)))
+	chunk = append(chunk, body...)
+	if len(body)%2 == 1 {
+		chunk = append(chunk, 0)
+	}
+	return chunk
+}
+
+func buildWav(chunks ...[]byte) []byte {
+	body := []byte("WAVE")
+	for _, c := range chunks {
+		body = append(body, c...)
+	}
+	return buildChunk("RIFF", body)
+}
+
+func pcm16FmtChunk(channels uint16) []byte {
+	body := binary.LittleEndian.AppendUint16(nil, 1)
+	body = binary.LittleEndian.AppendUint16(body, channels)
+	body = binary.LittleEndian.AppendUint32(body, 44100)
+	body = binary.LittleEndian.AppendUint32(body, 44100*uint32(channels)*2)
+	body = binary.LittleEndian.AppendUint16(body, channels*2)
+	body = binary.LittleEndian.AppendUint16(body, 16)
+	return buildChunk("fmt ", body)
+}
+
+func TestReadWavFile_RoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "roundtrip.wav")
+	audioData := []byte{0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, 0x08}
+	wfx := &WaveFormat{
+		FormatTag:     1, // PCM
+		Channels:      2,
+		SampleRate:    48000,
+		ByteRate:      192000,
+		BlockAlign:    4,
+		BitsPerSample: 16,
+	}
+
+	if err := saveWavFile(path, audioData, wfx); err != nil {
+		t.Fatalf("saveWavFile failed: %v", err)
+	}
+
+	readWfx, readData, err := readWavFile(path)
+	if err != nil {
+		t.Fatalf("readWavFile failed: %v", err)
+	}
+	if readWfx.Channels != 2 || readWfx.SampleRate != 48000 || readWfx.BitsPerSample != 16 || readWfx.BlockAlign != 4 {
+		t.Errorf("Unexpected format read back: %+v", readWfx)
+	}
+	if !bytes.Equal(readData, audioData) {
+		t.Errorf("Expected data %v, got %v", audioData, readData)
+	}
+}
+
+func TestParseWav_SkipsUnknownChunks(t *testing.T) {
+	data := []byte{0x10, 0x00, 0x20, 0x00}
+	content := buildWav(
+		buildChunk("JUNK", make([]byte, 28)),
+		pcm16FmtChunk(2),
+		buildChunk("LIST", []byte("INFOodd")), // 7 bytes, padded to 8
+		buildChunk("data", data),
+		buildChunk("bext", []byte{1, 2, 3}),
+	)
+
+	wfx, audioData, err := parseWav(bytes.NewReader(content))
+	if err != nil {
+		t.Fatalf("parseWav failed: %v", err)
+	}
+	if wfx.Channels != 2 {
+		t.Errorf("Expected 2 channels, got %d", wfx.Channels)
+	}
+	if !bytes.Equal(audioData, data) {
+		t.Errorf("Expected data %v, got %v", data, audioData)
+	}
+}
+
+func TestParseWav_Malformed(t *testing.T) {
+	truncatedData := buildWav(pcm16FmtChunk(2), buildChunk("data", make([]byte, 8)))
+	truncatedData = truncatedData[:len(truncatedData)-4]
+
+	tests := []struct {
+		name    string
+		content []byte
+		wantErr string
+	}{
+		{"too short", []byte("RIFF"), "too short"},
+		{"not riff", append([]byte("RIFX"), make([]byte, 8)...), "expected RIFF header"},
+		{"not wave", append([]byte("RIFF\x00\x00\x00\x00"), []byte("AVI ")...), "expected WAVE form type"},
+		{"no fmt", buildWav(buildChunk("data", make([]byte, 4))), "missing fmt chunk"},
+		{"no data", buildWav(pcm16FmtChunk(2)), "missing data chunk"},
+		{"truncated data", truncatedData, `truncated "data" chunk`},
+		{"short fmt", buildWav(buildChunk("fmt ", make([]byte, 10))), "expected at least 16"},
+		{"zero channels", buildWav(pcm16FmtChunk(0), buildChunk("data", nil)), "zero channels"},
+		{"partial frame", buildWav(pcm16FmtChunk(2), buildChunk("data", make([]byte, 6))), "not a multiple"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, err := parseWav(bytes.NewReader(tt.content))
+			if err == nil {
+				t.Fatalf("Expected error containing %q, got nil", tt.wantErr)
+			}
+			if !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Expected error containing %q, got %q", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestWavWriter_PatchesSizesOnClose(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "stream.wav")
+	w, err := createWavWriter(path, newPCMFormat(1, 8000, 8))
+	if err != nil {
+		t.Fatalf("createWavWriter failed: %v", err)
+	}
+
+	// Odd total length exercises the pad byte
+	for _, block := range [][]byte{{1, 2}, {3, 4}, {5}} {
+		if _, err := w.Write(block); err != nil {
+			t.Fatalf("Write failed: %v", err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read written file: %v", err)
+	}
+	if len(content) != 44+6 {
+		t.Fatalf("Expected 50 bytes including pad, got %d", len(content))
+	}
+	if riffSize := binary.LittleEndian.Uint32(content[4:8]); riffSize != uint32(len(content)-8) {
+		t.Errorf("Expected RIFF size %d, got %d", len(content)-8, riffSize)
+	}
+
+	_, audioData, err := readWavFile(path)
+	if err != nil {
+		t.Fatalf("readWavFile failed: %v", err)
+	}
+	if !bytes.Equal(audioData, []byte{1, 2, 3, 4, 5}) {
+		t.Errorf("Expected streamed data back, got %v", audioData)
+	}
+}
+
+func TestReadWavFile_ExtensibleRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "surround.wav")
+	wfx := newPCMFormat(6, 48000, 24)
+	if wfx.FormatTag != waveFormatExtensible {
+		t.Fatalf("Expected 24-bit 5.1 to use EXTENSIBLE, got tag 0x%04X", wfx.FormatTag)
+	}
+	audioData := make([]byte, int(wfx.BlockAlign)*2)
+
+	if err := saveWavFile(path, audioData, wfx); err != nil {
+		t.Fatalf("saveWavFile failed: %v", err)
+	}
+
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmtSize := binary.LittleEndian.Uint32(raw[16:20]); fmtSize != 40 {
+		t.Errorf("Expected a 40-byte fmt chunk, got %d", fmtSize)
+	}
+	if cbSize := binary.LittleEndian.Uint16(raw[36:38]); cbSize != 22 {
+		t.Errorf("Expected cbSize 22, got %d", cbSize)
+	}
+
+	readWfx, _, err := readWavFile(path)
+	if err != nil {
+		t.Fatalf("readWavFile failed: %v", err)
+	}
+	if *readWfx != *wfx {
+		t.Errorf("Expected %+v, got %+v", *wfx, *readWfx)
+	}
+	if readWfx.ChannelMask != 0x3F || readWfx.ValidBitsPerSample != 24 || readWfx.Encoding() != waveFormatPCM {
+		t.Errorf("Unexpected EXTENSIBLE fields: %+v", *readWfx)
+	}
+}
+
+func TestWaveFormat_Encoding(t *testing.T) {
+	float := &WaveFormat{FormatTag: waveFormatExtensible, BitsPerSample: 32, SubFormat: subFormatIEEEFloat}
+	if !isFloatFormat(float) {
+		t.Error("Expected EXTENSIBLE with the float sub-format to be float")
+	}
+	// 32-bit integer PCM is common in EXTENSIBLE and must not be read as float
+	pcm32 := &WaveFormat{FormatTag: waveFormatExtensible, BitsPerSample: 32, SubFormat: subFormatPCM}
+	if isFloatFormat(pcm32) || pcm32.Encoding() != waveFormatPCM {
+		t.Error("Expected EXTENSIBLE with the PCM sub-format to be integer PCM")
+	}
+	unknown := &WaveFormat{FormatTag: waveFormatExtensible, SubFormat: GUID{1, 2, 3}}
+	if unknown.Encoding() != 0 {
+		t.Errorf("Expected an unknown sub-format to have no encoding, got 0x%04X", unknown.Encoding())
+	}
+}
+
+func TestParseFmtChunk_ShortExtensible(t *testing.T) {
+	body := binary.LittleEndian.AppendUint16(nil, waveFormatExtensible)
+	body = binary.LittleEndian.AppendUint16(body, 2)
+	body = binary.LittleEndian.AppendUint32(body, 44100)
+	body = binary.LittleEndian.AppendUint32(body, 44100*4)
+	body = binary.LittleEndian.AppendUint16(body, 4)
+	body = binary.LittleEndian.AppendUint16(body, 16)
+	body = binary.LittleEndian.AppendUint16(body, 0)
+
+	if _, err := parseFmtChunk(body); err == nil || !strings.Contains(err.Error(), "extension") {
+		t.Errorf("Expected an error about the missing extension, got %v", err)
+	}
+}
+
+func TestWavWriter_FloatFactChunk(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "float.wav")
+	wfx := newFloatFormat(2, 48000)
+	w, err := createWavWriter(path, wfx)
+	if err != nil {
+		t.Fatalf("createWavWriter failed: %v", err)
+	}
+	if _, err := w.Write(make([]byte, 3*int(wfx.BlockAlign))); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// RIFF header, an 18-byte fmt chunk with cbSize 0, then fact
+	if tag := binary.LittleEndian.Uint16(raw[20:22]); tag != waveFormatIEEEFloat {
+		t.Errorf("Expected the IEEE float tag, got 0x%04X", tag)
+	}
+	if fmtSize := binary.LittleEndian.Uint32(raw[16:20]); fmtSize != 18 {
+		t.Errorf("Expected an 18-byte fmt chunk, got %d", fmtSize)
+	}
+	if string(raw[38:42]) != "fact" {
+		t.Fatalf("Expected a fact chunk after fmt, got %q", raw[38:42])
+	}
+	if frames := binary.LittleEndian.Uint32(raw[46:50]); frames != 3 {
+		t.Errorf("Expected the fact chunk to hold 3 frames, got %d", frames)
+	}
+	if riffSize := binary.LittleEndian.Uint32(raw[4:8]); int(riffSize) != len(raw)-8 {
+		t.Errorf("Expected RIFF size %d, got %d", len(raw)-8, riffSize)
+	}
+
+	readWfx, data, err := readWavFile(path)
+	if err != nil {
+		t.Fatalf("readWavFile failed: %v", err)
+	}
+	if !isFloatFormat(readWfx) || len(data) != 3*int(wfx.BlockAlign) {
+		t.Errorf("Unexpected read back: %+v with %d bytes", *readWfx, len(data))
+	}
+}
+
+// growSparse extends a writer's data chunk to size bytes without writing
+// them, leaving a sparse file.
+func growSparse(t *testing.T, w *WavWriter, size int64) {
+	t.Helper()
+	if err := w.f.Truncate(w.layout.dataOffset + size); err != nil {
+		t.Fatalf("Truncate failed: %v", err)
+	}
+	if _, err := w.f.Seek(0, io.SeekEnd); err != nil {
+		t.Fatal(err)
+	}
+	w.dataSize = size
+}
+
+func TestWavWriter_SwitchesToRF64(t *testing.T) {
+	const size = 5 << 30
+	path := filepath.Join(t.TempDir(), "long.wav")
+	wfx := newFloatFormat(8, 48000)
+	w, err := createLongWavWriter(path, wfx)
+	if err != nil {
+		t.Fatalf("createLongWavWriter failed: %v", err)
+	}
+	growSparse(t, w, size)
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	header := make([]byte, 12+reservedSize)
+	_, err = io.ReadFull(f, header)
+	f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(header[0:4]) != "RF64" || binary.LittleEndian.Uint32(header[4:8]) != rf64Placeholder {
+		t.Errorf("Expected an RF64 header with a placeholder size, got % X", header[0:8])
+	}
+	if string(header[12:16]) != "ds64" {
+		t.Fatalf("Expected the reserved chunk to become ds64, got %q", header[12:16])
+	}
+	sizes, err := parseDS64Chunk(header[20:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sizes.dataSize != size || sizes.sampleCount != size/uint64(wfx.BlockAlign) || sizes.riffSize != uint64(w.layout.dataOffset-8+size) {
+		t.Errorf("Unexpected ds64 sizes %+v", sizes)
+	}
+
+	readFormat, dataSize, err := readAudioHeader(path)
+	if err != nil {
+		t.Fatalf("readAudioHeader failed: %v", err)
+	}
+	if dataSize != size || readFormat.Channels != 8 || !isFloatFormat(readFormat) {
+		t.Errorf("Expected %d bytes of 8-channel float, got %d bytes of %+v", int64(size), dataSize, readFormat)
+	}
+}
+
+func TestWavWriter_LargeHeaderNearLimit(t *testing.T) {
+	// Data just under 4 GB only fits as RIFF with a small header; 64 KB of
+	// metadata ahead of it has to tip the file over into RF64
+	const size = math.MaxUint32 - 4096
+	wfx := newPCMFormat(2, 48000, 24)
+	extra := appendRIFFChunk(nil, "iXML", make([]byte, 64<<10))
+	path := filepath.Join(t.TempDir(), "long.wav")
+	w, err := createLongWavWriter(path, wfx, extra)
+	if err != nil {
+		t.Fatalf("createLongWavWriter failed: %v", err)
+	}
+	growSparse(t, w, size)
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	header := make([]byte, 12)
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = io.ReadFull(f, header)
+	f.Close()
+	if err != nil || string(header[0:4]) != "RF64" {
+		t.Errorf("Expected an RF64 file, got %q, %v", header[0:4], err)
+	}
+	if _, dataSize, err := readAudioHeader(path); err != nil || dataSize != size {
+		t.Errorf("Expected %d bytes of data, got %d, %v", int64(size), dataSize, err)
+	}
+
+	// Without the reserve the writer refuses rather than wrapping the size
+	plain, err := newWavWriter(filepath.Join(t.TempDir(), "plain.wav"), wfx, false, extra)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer plain.Close()
+	growSparse(t, plain, size)
+	if _, err := plain.Write(make([]byte, 6)); err == nil {
+		t.Error("Expected a write past the RIFF size limit to fail")
+	}
+}
+
+func TestWavHeaderSize(t *testing.T) {
+	extra := appendRIFFChunk(nil, "bext", make([]byte, 700))
+	for _, wfx := range []*WaveFormat{newPCMFormat(2, 44100, 16), newFloatFormat(2, 48000), newPCMFormat(6, 48000, 24)} {
+		w, err := newWavWriter(filepath.Join(t.TempDir(), "take.wav"), wfx, false, extra)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Close()
+		if got := wavHeaderSize(wfx, len(extra)); got != w.layout.dataOffset {
+			t.Errorf("%+v: expected a %d byte header, got %d", wfx, w.layout.dataOffset, got)
+		}
+	}
+	if got := maxRIFFDataSize(44); got != math.MaxUint32-37 {
+		t.Errorf("Expected %d bytes after a 44-byte header, got %d", int64(math.MaxUint32-37), got)
+	}
+}
+
+func TestWavWriter_ReservedChunkStaysJunk(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "short.wav")
+	w, err := createLongWavWriter(path, newPCMFormat(2, 44100, 16))
+	if err != nil {
+		t.Fatalf("createLongWavWriter failed: %v", err)
+	}
+	if _, err := w.Write([]byte{1, 2, 3, 4}); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	raw, _ := os.ReadFile(path)
+	if string(raw[0:4]) != "RIFF" || string(raw[12:16]) != "JUNK" {
+		t.Errorf("Expected a RIFF file with a JUNK chunk, got %q and %q", raw[0:4], raw[12:16])
+	}
+	if _, data, err := readWavFile(path); err != nil || !bytes.Equal(data, []byte{1, 2, 3, 4}) {
+		t.Errorf("Expected the data back, got %v, %v", data, err)
+	}
+}
+
+func TestParseWav_RF64(t *testing.T) {
+	samples := []byte{1, 2, 3, 4, 5, 6, 7, 8}
+	ds64Body := binary.LittleEndian.AppendUint64(nil, 0) // RIFF size is not checked
+	ds64Body = binary.LittleEndian.AppendUint64(ds64Body, uint64(len(samples)))
+	ds64Body = binary.LittleEndian.AppendUint64(ds64Body, 2)
+	ds64Body = binary.LittleEndian.AppendUint32(ds64Body, 0)
+	data := append([]byte("data"), 0xFF, 0xFF, 0xFF, 0xFF)
+	data = append(data, samples...)
+
+	for _, id := range []string{"RF64", "BW64"} {
+		content := append([]byte(id+"\xFF\xFF\xFF\xFFWAVE"), buildChunk("ds64", ds64Body)...)
+		content = append(content, pcm16FmtChunk(2)...)
+		content = append(content, data...)
+		wfx, audioData, err := parseWav(bytes.NewReader(content))
+		if err != nil {
+			t.Fatalf("%s: parseWav failed: %v", id, err)
+		}
+		if wfx.Channels != 2 || !bytes.Equal(audioData, samples) {
+			t.Errorf("%s: expected the samples back, got %v", id, audioData)
+		}
+	}
+
+	missing := append([]byte("RF64\xFF\xFF\xFF\xFFWAVE"), pcm16FmtChunk(2)...)
+	missing = append(missing, data...)
+	if _, _, err := parseWav(bytes.NewReader(missing)); err == nil || !strings.Contains(err.Error(), "ds64") {
+		t.Errorf("Expected an error about the missing ds64 chunk, got %v", err)
+	}
+}
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const projectUsage = `Usage:
+  muxic project new <name> [dir]
+  muxic project open <name|dir>
+  muxic project list
+  muxic project close`
+
+// extractProjectFlag pulls a --project flag out of the arguments, wherever it
+// appears, and returns the remaining arguments alongside its value.
+func extractProjectFlag(args []string) ([]string, string, error) {
+	rest, projectRef, err := extractFlag(args, "project")
+	if err != nil {
+		return nil, "", errors.New("--project requires a project name or directory")
+	}
+	return rest, projectRef, nil
+}
+
+// extractFlag pulls a valued --name flag out of the arguments, accepting both
+// "--name value" and "--name=value". It returns the remaining arguments and
+// the flag's value, or "" when it is absent.
+func extractFlag(args []string, name string) ([]string, string, error) {
+	flag := "--" + name
+	var rest []string
+	value := ""
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case arg == flag:
+			if i+1 >= len(args) {
+				return nil, "", fmt.Errorf("%s requires a value", flag)
+			}
+			value = args[i+1]
+			i++
+		case strings.HasPrefix(arg, flag+"="):
+			value = strings.TrimPrefix(arg, flag+"=")
+		default:
+			rest = append(rest, arg)
+		}
+	}
+	return rest, value, nil
+}
+
+// extractFlagValues is extractFlag for flags that may be repeated. It
+// returns every value in the order given.
+func extractFlagValues(args []string, name string) ([]string, []string, error) {
+	flag := "--" + name
+	var rest, values []string
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case arg == flag:
+			if i+1 >= len(args) {
+				return nil, nil, fmt.Errorf("%s requires a value", flag)
+			}
+			values = append(values, args[i+1])
+			i++
+		case strings.HasPrefix(arg, flag+"="):
+			values = append(values, strings.TrimPrefix(arg, flag+"="))
+		default:
+			rest = append(rest, arg)
+		}
+	}
+	return rest, values, nil
+}
+
+// useProject points projectRoot at the project named on the command line, or
+// the active project from the user config. Without either, muxic keeps working
+// in the current directory.
+func useProject(projectRef string) error {
+	config, err := LoadConfig()
+	if err != nil {
+		return err
+	}
+
+	if projectRef == "" {
+		if config.ActiveProject == nil {
+			return nil
+		}
+		projectRef = *config.ActiveProject
+	}
+
+	root, err := resolveProject(config, projectRef)
+	if err != nil {
+		return err
+	}
+	projectRoot = root
+	return nil
+}
+
+// resolveProject turns a registered project name or a directory into a
+// project root.
+func resolveProject(config Config, ref string) (string, error) {
+	if root, ok := config.Projects[ref]; ok {
+		ref = root
+	}
+	info, err := os.Stat(ref)
+	if os.IsNotExist(err) {
+		return "", fmt.Errorf("project '%s' not found", ref)
+	}
+	if err != nil {
+		return "", err
+	}
+	if !info.IsDir() {
+		return "", fmt.Errorf("project '%s' is not a directory", ref)
+	}
+	return filepath.Abs(ref)
+}
+
+func projectCommand(args []string) error {
+	if len(args) < 1 {
+		return errors.New("project action required\n" + projectUsage)
+	}
+
+	config, err := LoadConfig()
+	if err != nil {
+		return err
+	}
+
+	switch args[0] {
+	case "new":
+		if len(args) < 2 {
+			return errors.New("project name required\n" + projectUsage)
+		}
+		dir := args[1]
+		if len(args) >= 3 {
+			dir = args[2]
+		}
+		return newProject(config, args[1], dir)
+	case "open":
+		if len(args) < 2 {
+			return errors.New("project name or directory required\n" + projectUsage)
+		}
+		return openProject(config, args[1])
+	case "list":
+		return listProjects(config)
+	case "close":
+		config.ActiveProject = nil
+		if err := config.Save(); err != nil {
+			return err
+		}
+		fmt.Println("Closed project; commands now use the current directory")
+		return nil
+	default:
+		return fmt.Errorf("unknown project action '%s'\n%s", args[0], projectUsage)
+	}
+}
+
+func newProject(config Config, name, dir string) error {
+	if _, ok := config.Projects[name]; ok {
+		return fmt.Errorf("project '%s' already exists", name)
+	}
+
+	root, err := filepath.Abs(dir)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(filepath.Join(root, projectFileName)); err == nil {
+		return fmt.Errorf("%s already holds a project; use 'muxic project open'", root)
+	}
+
+	projectRoot = root
+	if err := ensureTracksDir(); err != nil {
+		return err
+	}
+	if err := (Project{}).Save(); err != nil {
+		return err
+	}
+
+	if config.Projects == nil {
+		config.Projects = map[string]string{}
+	}
+	config.Projects[name] = root
+	config.ActiveProject = &root
+	if err := config.Save(); err != nil {
+		return err
+	}
+
+	fmt.Printf("[OK] Created project '%s' in %s\n", name, root)
+	return nil
+}
+
+// openProject activates a registered project, or registers and activates a
+// directory under its base name.
+func openProject(config Config, ref string) error {
+	root, err := resolveProject(config, ref)
+	if err != nil {
+		return err
+	}
+
+	name := projectName(config, root)
+	if name == "" {
+		name = filepath.Base(root)
+		if config.Projects == nil {
+			config.Projects = map[string]string{}
+		}
+		if _, taken := config.Projects[name]; taken {
+			return fmt.Errorf("another project is already registered as '%s'", name)
+		}
+		config.Projects[name] = root
+	}
+
+	config.ActiveProject = &root
+	if err := config.Save(); err != nil {
+		return err
+	}
+
+	fmt.Printf("Opened project '%s' (%s)\n", name, root)
+	return nil
+}
+
+func listProjects(config Config) error {
+	fmt.Println("[PROJECTS] Known Projects:")
+	fmt.Println("==================")
+
+	if len(config.Projects) == 0 {
+		fmt.Println("  (no projects yet, create one with 'muxic project new <name>')")
+		return nil
+	}
+
+	var names []string
+	for name := range config.Projects {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		root := config.Projects[name]
+		indicator := " "
+		if config.ActiveProject != nil && *config.ActiveProject == root {
+			indicator = "*"
+		}
+		fmt.Printf("%s %s (%s)\n", indicator, name, root)
+	}
+	return nil
+}
+
+// projectName returns the registered name for a project root, if any.
+func projectName(config Config, root string) string {
+	for name, r := range config.Projects {
+		if r == root {
+			return name
+		}
+	}
+	return ""
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// isolateProjects gives the test its own user config and restores the
+// project root afterwards.
+func isolateProjects(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	t.Setenv("MUXIC_CONFIG", filepath.Join(dir, configFileName))
+	oldRoot := projectRoot
+	t.Cleanup(func() { projectRoot = oldRoot })
+	return dir
+}
+
+func TestExtractProjectFlag(t *testing.T) {
+	tests := []struct {
+		args     []string
+		wantArgs []string
+		wantRef  string
+	}{
+		{[]string{"list"}, []string{"list"}, ""},
+		{[]string{"--project", "demo", "list"}, []string{"list"}, "demo"},
+		{[]string{"record", "vocals", "--project=C:/songs/demo"}, []string{"record", "vocals"}, "C:/songs/demo"},
+	}
+	for _, tt := range tests {
+		args, ref, err := extractProjectFlag(tt.args)
+		if err != nil {
+			t.Fatalf("extractProjectFlag(%v) failed: %v", tt.args, err)
+		}
+		if !reflect.DeepEqual(args, tt.wantArgs) || ref != tt.wantRef {
+			t.Errorf("extractProjectFlag(%v) = %v, %q; expected %v, %q", tt.args, args, ref, tt.wantArgs, tt.wantRef)
+		}
+	}
+
+	if _, _, err := extractProjectFlag([]string{"list", "--project"}); err == nil {
+		t.Error("Expected error for --project without a value")
+	}
+}
+
+func TestProjectLifecycle(t *testing.T) {
+	dir := isolateProjects(t)
+	songRoot := filepath.Join(dir, "song")
+
+	if err := projectCommand([]string{"new", "song", songRoot}); err != nil {
+		t.Fatalf("project new failed: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(songRoot, TracksDir)); err != nil {
+		t.Errorf("Expected tracks dir in new project: %v", err)
+	}
+
+	// A fresh process picks up the active project from the user config
+	projectRoot = "."
+	if err := useProject(""); err != nil {
+		t.Fatalf("useProject failed: %v", err)
+	}
+	if projectRoot != songRoot {
+		t.Errorf("Expected active project %s, got %s", songRoot, projectRoot)
+	}
+	if got := getTrackPath("vocals"); got != filepath.Join(songRoot, TracksDir, "vocals.wav") {
+		t.Errorf("Track path not resolved in project: %s", got)
+	}
+
+	// --project overrides the active project for one command
+	other := filepath.Join(dir, "other")
+	os.MkdirAll(other, 0755)
+	if err := useProject(other); err != nil {
+		t.Fatalf("useProject with directory failed: %v", err)
+	}
+	if projectRoot != other {
+		t.Errorf("Expected --project root %s, got %s", other, projectRoot)
+	}
+
+	if err := projectCommand([]string{"close"}); err != nil {
+		t.Fatalf("project close failed: %v", err)
+	}
+	config, _ := LoadConfig()
+	if config.ActiveProject != nil {
+		t.Errorf("Expected no active project after close, got %s", *config.ActiveProject)
+	}
+	if config.Projects["song"] != songRoot {
+		t.Errorf("Expected song to stay registered, got %v", config.Projects)
+	}
+
+	if err := projectCommand([]string{"open", "song"}); err != nil {
+		t.Fatalf("project open failed: %v", err)
+	}
+	config, _ = LoadConfig()
+	if config.ActiveProject == nil || *config.ActiveProject != songRoot {
+		t.Errorf("Expected song to be active again, got %v", config.ActiveProject)
+	}
+
+	if err := projectCommand([]string{"new", "song", filepath.Join(dir, "dupe")}); err == nil {
+		t.Error("Expected error creating a project with a taken name")
+	}
+}
+
+func TestUseProject_Missing(t *testing.T) {
+	isolateProjects(t)
+	if err := useProject(filepath.Join(t.TempDir(), "nope")); err == nil {
+		t.Error("Expected error for a missing project")
+	}
+}